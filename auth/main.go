@@ -0,0 +1,261 @@
+// Package auth authenticates API requests with per-user API keys. A key is
+// "<id>.<secret>", the secret is shown once when the key is created and only
+// its SHA-256 is kept, together with a copy sealed by the signer provider to verify
+// request signatures. Keys are scoped, expire and can be revoked.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"nft-market/config"
+	"nft-market/signer"
+	"nft-market/storage"
+	"nft-market/validation"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Scopes limit what a key can be used for, every endpoint requires one.
+const (
+	ScopeUser       = "user"
+	ScopeCollection = "collection"
+	ScopeToken      = "token"
+	// ScopeKeys allows creating and revoking the user's keys
+	ScopeKeys = "keys"
+)
+
+// Scopes are the ones granted to keys created without any
+var Scopes = []string{ScopeUser, ScopeCollection, ScopeToken, ScopeKeys}
+
+var (
+	ErrInvalidKey = errors.New("invalid API key")
+	ErrExpired    = errors.New("API key has expired")
+	ErrRevoked    = errors.New("API key has been revoked")
+)
+
+var keyID = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+var cfg = config.Default().Auth
+
+// Configure sets the signature window and key lifetime. It is expected to be
+// called once on startup, before serving any requests.
+func Configure(c config.Auth) {
+	cfg = c
+	nonces = newNonceCache()
+}
+
+// APIKey is the stored record of a key.
+type APIKey struct {
+	ID     string `json:"id"`
+	UserID string `json:"userid"`
+	Name   string `json:"name,omitempty"`
+	// Hash is the hex encoded SHA-256 of the secret
+	Hash         string          `json:"hash"`
+	SealedSecret json.RawMessage `json:"sealed_secret"`
+	Scopes       []string        `json:"scopes"`
+	Created      time.Time       `json:"created"`
+	Expires      *time.Time      `json:"expires,omitempty"`
+	Revoked      *time.Time      `json:"revoked,omitempty"`
+}
+
+// KeyInfo is what is shown of a key to its user.
+type KeyInfo struct {
+	ID      string     `json:"id"`
+	Name    string     `json:"name,omitempty"`
+	Scopes  []string   `json:"scopes"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Revoked *time.Time `json:"revoked,omitempty"`
+}
+
+func (k *APIKey) Info() KeyInfo {
+	return KeyInfo{
+		ID:      k.ID,
+		Name:    k.Name,
+		Scopes:  k.Scopes,
+		Created: k.Created,
+		Expires: k.Expires,
+		Revoked: k.Revoked,
+	}
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	return contains(k.Scopes, scope)
+}
+
+func (k *APIKey) check(now time.Time) error {
+	if k.Revoked != nil {
+		return ErrRevoked
+	}
+	if k.Expires != nil && !now.Before(*k.Expires) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// KeyRequest describes a key to create, ExpiresIn is a duration like "720h"
+// and defaults to the configured key lifetime, which is also the longest
+// one allowed. NotAfter caps the expiry further, keys created with another
+// key mustn't outlive it.
+type KeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresIn string     `json:"expires_in,omitempty"`
+	NotAfter  *time.Time `json:"-"`
+}
+
+// Verify records the invalid fields of the request in v, prefixed with
+// prefix.
+func (req *KeyRequest) Verify(v *validation.Validator, prefix string) {
+	for _, scope := range req.Scopes {
+		if !contains(Scopes, scope) {
+			v.Fail(prefix+"scopes", "has unknown scope "+scope)
+		}
+	}
+	if req.ExpiresIn != "" {
+		if ttl, err := time.ParseDuration(req.ExpiresIn); err != nil || ttl <= 0 {
+			v.Fail(prefix+"expires_in", "is not a positive duration")
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, s := range list {
+		if s == value {
+			return true
+		}
+	}
+	return false
+}
+
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func secretLabel(keyid string) string {
+	return "apikey/" + keyid
+}
+
+func saveKey(key *APIKey) error {
+	data, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+	return storage.SetAPIKey(key.ID, data)
+}
+
+func getKey(keyid string) (*APIKey, error) {
+	if !keyID.MatchString(keyid) {
+		return nil, ErrInvalidKey
+	}
+	data, err := storage.GetAPIKey(keyid)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	var key APIKey
+	if err = json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// CreateKey issues a key for the user and returns it together with the
+// "<id>.<secret>" value the user authenticates with.
+func CreateKey(userid string, req *KeyRequest) (*APIKey, string, error) {
+	var v validation.Validator
+	req.Verify(&v, "")
+	if err := v.Err(); err != nil {
+		return nil, "", err
+	}
+
+	random := make([]byte, 40)
+	if _, err := rand.Read(random); err != nil {
+		return nil, "", err
+	}
+	id := hex.EncodeToString(random[:8])
+	secret := base64.RawURLEncoding.EncodeToString(random[8:])
+
+	sealed, err := signer.SealSecret(secretLabel(id), []byte(secret))
+	if err != nil {
+		return nil, "", errors.New("failed to seal API key")
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = Scopes
+	}
+	now := time.Now()
+	key := &APIKey{
+		ID:           id,
+		UserID:       userid,
+		Name:         req.Name,
+		Hash:         hashSecret(secret),
+		SealedSecret: sealed,
+		Scopes:       scopes,
+		Created:      now,
+	}
+
+	ttl := time.Duration(cfg.KeyTTL)
+	if req.ExpiresIn != "" {
+		requested, _ := time.ParseDuration(req.ExpiresIn)
+		if ttl == 0 || requested < ttl {
+			ttl = requested
+		}
+	}
+	if ttl > 0 {
+		expires := now.Add(ttl)
+		key.Expires = &expires
+	}
+	if req.NotAfter != nil && (key.Expires == nil || req.NotAfter.Before(*key.Expires)) {
+		expires := *req.NotAfter
+		key.Expires = &expires
+	}
+
+	if err = saveKey(key); err != nil {
+		return nil, "", errors.New("failed to save API key")
+	}
+	return key, id + "." + secret, nil
+}
+
+// ListKeys returns the user's keys, oldest first, including the expired and
+// revoked ones.
+func ListKeys(userid string) ([]APIKey, error) {
+	ids, err := storage.ListAPIKeys()
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	for _, id := range ids {
+		key, err := getKey(id)
+		if err != nil || key.UserID != userid {
+			continue
+		}
+		keys = append(keys, *key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Created.Before(keys[j].Created)
+	})
+	return keys, nil
+}
+
+// RevokeKey revokes one of the user's keys, it stays listed.
+func RevokeKey(userid string, keyid string) (*APIKey, error) {
+	key, err := getKey(keyid)
+	if err != nil || key.UserID != userid {
+		return nil, errors.New("API key " + keyid + " doesn't exist")
+	}
+	if key.Revoked == nil {
+		now := time.Now()
+		key.Revoked = &now
+		if err = saveKey(key); err != nil {
+			return nil, errors.New("failed to save API key")
+		}
+	}
+	return key, nil
+}
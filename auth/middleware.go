@@ -0,0 +1,205 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"io"
+	"net/http"
+	"nft-market/signer"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Requests carry the key as "<id>.<secret>" in HeaderKey. Signed requests
+// carry only the key ID there, and the timestamp in Unix seconds, a nonce
+// and the signature computed by Sign in the other headers.
+const (
+	HeaderKey       = "X-Api-Key"
+	HeaderTimestamp = "X-Api-Timestamp"
+	HeaderNonce     = "X-Api-Nonce"
+	HeaderSignature = "X-Api-Signature"
+)
+
+const contextKey = "auth.key"
+
+var (
+	ErrUnauthenticated = errors.New("request is not authenticated")
+	ErrUserMismatch    = errors.New("userid doesn't match the API key")
+)
+
+// nonceCache remembers the nonces of signed requests until their timestamp
+// is out of the signature window, after which they are rejected anyway.
+type nonceCache struct {
+	mutex  sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+var nonces = newNonceCache()
+
+func newNonceCache() *nonceCache {
+	return &nonceCache{seen: make(map[string]time.Time)}
+}
+
+// use records the nonce and returns whether it hadn't been seen before.
+func (n *nonceCache) use(nonce string, now time.Time) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	window := time.Duration(cfg.SignatureWindow)
+	if now.Sub(n.pruned) > window {
+		for seen, expires := range n.seen {
+			if now.After(expires) {
+				delete(n.seen, seen)
+			}
+		}
+		n.pruned = now
+	}
+
+	if _, ok := n.seen[nonce]; ok {
+		return false
+	}
+	// the timestamp can be up to a window in the future
+	n.seen[nonce] = now.Add(2 * window)
+	return true
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of a request with the key's
+// secret. The signed message is the method, the request URI with the query,
+// the timestamp, the nonce and the hex encoded SHA-256 of the body, each
+// followed by a newline.
+func Sign(secret string, method string, uri string, timestamp string, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	for _, part := range []string{method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:])} {
+		mac.Write([]byte(part + "\n"))
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func authenticate(r *http.Request, now time.Time) (*APIKey, error) {
+	value := r.Header.Get(HeaderKey)
+	if value == "" {
+		return nil, errors.New("API key is missing")
+	}
+	if r.Header.Get(HeaderSignature) != "" {
+		return authenticateSigned(r, value, now)
+	}
+	if cfg.RequireSignature {
+		return nil, errors.New("request has to be signed")
+	}
+
+	id, secret, ok := strings.Cut(value, ".")
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	key, err := getKey(id)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	if subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(key.Hash)) != 1 {
+		return nil, ErrInvalidKey
+	}
+	if err = key.check(now); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func authenticateSigned(r *http.Request, id string, now time.Time) (*APIKey, error) {
+	key, err := getKey(id)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	if err = key.check(now); err != nil {
+		return nil, err
+	}
+
+	timestamp := r.Header.Get(HeaderTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errors.New("request timestamp is missing")
+	}
+	skew := now.Sub(time.Unix(seconds, 0))
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > time.Duration(cfg.SignatureWindow) {
+		return nil, errors.New("request timestamp is out of the signature window")
+	}
+	nonce := r.Header.Get(HeaderNonce)
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return nil, errors.New("request nonce has to be 16 to 128 characters")
+	}
+
+	// the body is read here to be signed and put back for the handler
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	secret, err := signer.OpenSecret(secretLabel(key.ID), key.SealedSecret)
+	if err != nil {
+		return nil, errors.New("failed to open API key")
+	}
+	expected := Sign(string(secret), r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(r.Header.Get(HeaderSignature)))) {
+		return nil, errors.New("invalid request signature")
+	}
+	if !nonces.use(key.ID+"/"+nonce, now) {
+		return nil, errors.New("request nonce has been used already")
+	}
+	return key, nil
+}
+
+// Middleware authenticates every request with an API key that has the
+// given scope.
+func Middleware(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			key, err := authenticate(c.Request(), time.Now())
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			}
+			if !key.HasScope(scope) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "API key doesn't have the " + scope + " scope"})
+			}
+			c.Set(contextKey, key)
+			return next(c)
+		}
+	}
+}
+
+// Key returns the key the request has been authenticated with, nil outside
+// of Middleware.
+func Key(c echo.Context) *APIKey {
+	key, _ := c.Get(contextKey).(*APIKey)
+	return key
+}
+
+// HasScope tells whether the request's key has the scope.
+func HasScope(c echo.Context, scope string) bool {
+	key := Key(c)
+	return key != nil && key.HasScope(scope)
+}
+
+// Identify returns the user the request has been authenticated as. The
+// userid of the request body, if any, has to be that user.
+func Identify(c echo.Context, userid string) (string, error) {
+	key := Key(c)
+	if key == nil {
+		return "", ErrUnauthenticated
+	}
+	if userid != "" && userid != key.UserID {
+		return "", ErrUserMismatch
+	}
+	return key.UserID, nil
+}
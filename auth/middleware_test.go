@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"nft-market/config"
+	"nft-market/keystore"
+	"nft-market/signer"
+	"nft-market/storage"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"userid":"u"}`)
+	// computed independently from the format documented on Sign
+	want := "17dce1a90e645f6f43024919eb69e1806c130a7a8f7f2b1bd8b7ba84e51c1418"
+	if got := Sign("secret", "POST", "/collection?x=1", "1683000000", "0123456789abcdef", body); got != want {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	// every part is signed, moving bytes between parts changes the signature
+	tests := []struct {
+		name      string
+		secret    string
+		method    string
+		uri       string
+		timestamp string
+		nonce     string
+		body      string
+	}{
+		{"secret", "secreT", "POST", "/collection?x=1", "1683000000", "0123456789abcdef", `{"userid":"u"}`},
+		{"method", "secret", "GET", "/collection?x=1", "1683000000", "0123456789abcdef", `{"userid":"u"}`},
+		{"query", "secret", "POST", "/collection?x=2", "1683000000", "0123456789abcdef", `{"userid":"u"}`},
+		{"timestamp", "secret", "POST", "/collection?x=1", "1683000001", "0123456789abcdef", `{"userid":"u"}`},
+		{"nonce", "secret", "POST", "/collection?x=1", "1683000000", "0123456789abcdeg", `{"userid":"u"}`},
+		{"body", "secret", "POST", "/collection?x=1", "1683000000", "0123456789abcdef", `{"userid":"v"}`},
+		{"boundary", "secret", "POST", "/collection?x=", "11683000000", "0123456789abcdef", `{"userid":"u"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Sign(test.secret, test.method, test.uri, test.timestamp, test.nonce, []byte(test.body)); got == want {
+				t.Error("signature didn't change")
+			}
+		})
+	}
+}
+
+// setupKeys stores keys in a temporary directory and returns a valid,
+// a revoked and an expiring key value
+func setupKeys(t *testing.T, c config.Auth) (valid string, revoked string, expiring string) {
+	t.Helper()
+
+	storage.SetBackend(storage.NewFSBackend(t.TempDir() + "/"))
+	if err := keystore.Init(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	signer.SetProvider(signer.NewKeystoreProvider(big.NewInt(1)))
+	Configure(c)
+
+	var err error
+	if _, valid, err = CreateKey("user", &KeyRequest{Scopes: []string{ScopeUser}}); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	key, revoked, err := CreateKey("user", &KeyRequest{})
+	if err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	if _, err = RevokeKey("user", key.ID); err != nil {
+		t.Fatalf("failed to revoke key: %v", err)
+	}
+	if _, expiring, err = CreateKey("user", &KeyRequest{ExpiresIn: "1h"}); err != nil {
+		t.Fatalf("failed to create key: %v", err)
+	}
+	return valid, revoked, expiring
+}
+
+func TestAuthenticate(t *testing.T) {
+	valid, revoked, expiring := setupKeys(t, config.Auth{SignatureWindow: config.Duration(5 * time.Minute)})
+	id, secret, _ := strings.Cut(valid, ".")
+
+	tests := []struct {
+		name  string
+		value string
+		later time.Duration
+		err   string
+	}{
+		{"valid", valid, 0, ""},
+		{"missing", "", 0, "API key is missing"},
+		{"no secret", id, 0, ErrInvalidKey.Error()},
+		{"wrong secret", id + "." + secret[1:], 0, ErrInvalidKey.Error()},
+		{"unknown", "0123456789abcdef." + secret, 0, ErrInvalidKey.Error()},
+		{"not an ID", "../users/user." + secret, 0, ErrInvalidKey.Error()},
+		{"revoked", revoked, 0, ErrRevoked.Error()},
+		{"before expiry", expiring, 59 * time.Minute, ""},
+		{"expired", expiring, time.Hour, ErrExpired.Error()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/user", nil)
+			r.Header.Set(HeaderKey, test.value)
+			key, err := authenticate(r, time.Now().Add(test.later))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if key.UserID != "user" {
+				t.Errorf("got user %v, want user", key.UserID)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSigned(t *testing.T) {
+	valid, revoked, _ := setupKeys(t, config.Auth{SignatureWindow: config.Duration(5 * time.Minute)})
+	id, secret, _ := strings.Cut(valid, ".")
+	revokedID, revokedSecret, _ := strings.Cut(revoked, ".")
+	now := time.Now()
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	body := `{"userid":"user"}`
+
+	// request returns a request signed with secret, edit changes it after
+	// signing
+	request := func(id string, secret string, timestamp string, nonce string, edit func(r *http.Request)) *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/user?page=1", strings.NewReader(body))
+		r.Header.Set(HeaderKey, id)
+		r.Header.Set(HeaderTimestamp, timestamp)
+		r.Header.Set(HeaderNonce, nonce)
+		r.Header.Set(HeaderSignature, Sign(secret, r.Method, "/user?page=1", timestamp, nonce, []byte(body)))
+		if edit != nil {
+			edit(r)
+		}
+		return r
+	}
+	nonce := 0
+	// fresh returns a nonce that hasn't been used
+	fresh := func() string {
+		nonce++
+		return "nonce-" + strconv.Itoa(1000000000+nonce)
+	}
+	used := fresh()
+
+	tests := []struct {
+		name string
+		r    *http.Request
+		err  string
+	}{
+		{"valid", request(id, secret, timestamp, used, nil), ""},
+		{"upper case signature", request(id, secret, timestamp, fresh(), func(r *http.Request) {
+			r.Header.Set(HeaderSignature, strings.ToUpper(r.Header.Get(HeaderSignature)))
+		}), ""},
+		{"window start", request(id, secret, strconv.FormatInt(now.Add(-5*time.Minute+time.Second).Unix(), 10), fresh(), nil), ""},
+		{"window end", request(id, secret, strconv.FormatInt(now.Add(5*time.Minute).Unix(), 10), fresh(), nil), ""},
+		{"replay", request(id, secret, timestamp, used, nil), "request nonce has been used already"},
+		{"wrong secret", request(id, secret[1:], timestamp, fresh(), nil), "invalid request signature"},
+		{"secret as ID", request(valid, secret, timestamp, fresh(), nil), ErrInvalidKey.Error()},
+		{"unknown key", request("0123456789abcdef", secret, timestamp, fresh(), nil), ErrInvalidKey.Error()},
+		{"revoked", request(revokedID, revokedSecret, timestamp, fresh(), nil), ErrRevoked.Error()},
+		{"method", request(id, secret, timestamp, fresh(), func(r *http.Request) { r.Method = http.MethodPut }), "invalid request signature"},
+		{"query", request(id, secret, timestamp, fresh(), func(r *http.Request) { r.URL.RawQuery = "page=2" }), "invalid request signature"},
+		{"body", request(id, secret, timestamp, fresh(), func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(`{"userid":"other"}`))
+		}), "invalid request signature"},
+		{"signed timestamp", request(id, secret, timestamp, fresh(), func(r *http.Request) {
+			r.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix()+1, 10))
+		}), "invalid request signature"},
+		{"signed nonce", request(id, secret, timestamp, fresh(), func(r *http.Request) { r.Header.Set(HeaderNonce, fresh()) }), "invalid request signature"},
+		{"too old", request(id, secret, strconv.FormatInt(now.Add(-5*time.Minute-time.Second).Unix(), 10), fresh(), nil), "request timestamp is out of the signature window"},
+		{"too new", request(id, secret, strconv.FormatInt(now.Add(5*time.Minute+time.Second).Unix(), 10), fresh(), nil), "request timestamp is out of the signature window"},
+		{"no timestamp", request(id, secret, "", fresh(), nil), "request timestamp is missing"},
+		{"milliseconds", request(id, secret, timestamp+".000", fresh(), nil), "request timestamp is missing"},
+		{"short nonce", request(id, secret, timestamp, "0123456789abcde", nil), "request nonce has to be 16 to 128 characters"},
+		{"long nonce", request(id, secret, timestamp, strings.Repeat("n", 129), nil), "request nonce has to be 16 to 128 characters"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := authenticate(test.r, now)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if key.UserID != "user" {
+				t.Errorf("got user %v, want user", key.UserID)
+			}
+			// the handler still gets the body
+			if got, _ := io.ReadAll(test.r.Body); string(got) != body {
+				t.Errorf("handler got body %q, want %q", got, body)
+			}
+		})
+	}
+}
+
+func TestRequireSignature(t *testing.T) {
+	valid, _, _ := setupKeys(t, config.Auth{RequireSignature: true, SignatureWindow: config.Duration(5 * time.Minute)})
+	id, secret, _ := strings.Cut(valid, ".")
+
+	r := httptest.NewRequest(http.MethodGet, "/user", nil)
+	r.Header.Set(HeaderKey, valid)
+	if _, err := authenticate(r, time.Now()); err == nil || err.Error() != "request has to be signed" {
+		t.Errorf("bare key got error %v, want request has to be signed", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	r.Header.Set(HeaderKey, id)
+	r.Header.Set(HeaderTimestamp, timestamp)
+	r.Header.Set(HeaderNonce, "0123456789abcdef")
+	r.Header.Set(HeaderSignature, Sign(secret, http.MethodGet, "/user", timestamp, "0123456789abcdef", nil))
+	if _, err := authenticate(r, time.Now()); err != nil {
+		t.Errorf("signed request got error %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"nft-market/imxmock"
+	"time"
+)
+
+// imxmock serves a local stand-in for the ImmutableX REST API, point the
+// market at it with -marketplace imx -imx-api http://<listen address>
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8081", "address to listen on")
+	confirmAfter := flag.Duration("confirm-after", time.Minute, "time until a withdrawal is confirmed")
+	flag.Parse()
+
+	m := imxmock.New()
+	m.ConfirmAfter = *confirmAfter
+
+	log.Printf("serving mock ImmutableX API on http://%v", *listen)
+	log.Fatal(http.ListenAndServe(*listen, m))
+}
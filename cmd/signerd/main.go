@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+	"net"
+	"nft-market/config"
+	"nft-market/keystore"
+	"nft-market/nftimx"
+	"nft-market/signer"
+	"nft-market/storage"
+	"nft-market/wallet"
+	"os"
+)
+
+// signerd holds the keystore master key and the wallet seed, and signs for
+// the market over a Unix socket. It also creates the keys of new users and
+// seals API key secrets, so the market process never has access to user
+// keys it didn't just receive for import. It reads the same configuration
+// as the market, see package config.
+func main() {
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Panicf("failed to load configuration: %v", err)
+		return
+	}
+	socket := cfg.Signer.Socket
+
+	closeStorage, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Panicf("failed to open storage: %v", err)
+		return
+	}
+	defer closeStorage()
+
+	if err = keystore.Init(os.Getenv(keystore.MasterKeyEnv)); err != nil {
+		log.Panicf("failed to initialize keystore from %v: %v", keystore.MasterKeyEnv, err)
+		return
+	}
+	if err = wallet.Init(os.Getenv(wallet.SeedEnv)); err != nil {
+		log.Panicf("failed to initialize wallet seed from %v: %v", wallet.SeedEnv, err)
+		return
+	}
+
+	_ = os.Remove(socket)
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		log.Panicf("failed to listen on %v: %v", socket, err)
+		return
+	}
+	defer listener.Close()
+	if err = os.Chmod(socket, 0600); err != nil {
+		log.Panicf("failed to restrict access to %v: %v", socket, err)
+		return
+	}
+
+	log.Printf("signing on %v", socket)
+	log.Fatal(signer.Serve(listener, signer.NewKeystoreProvider(nftimx.ChainID(cfg.Marketplace))))
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
+	"log"
+	"nft-market/config"
+	"nft-market/keystore"
+	"nft-market/storage"
+	"nft-market/wallet"
+	"os"
+	"strings"
+)
+
+// walletrecover regenerates the keys of custodial users from the wallet
+// seed and checks them against the stored addresses:
+//
+//	walletrecover [verify|restore] [market flags]
+//
+// verify, the default, only reports. restore also seals the regenerated
+// keys into the keystore again, replacing lost or damaged key files; it
+// needs the keystore master key. Users whose keys aren't derived from the
+// seed, imported or created before there was one, are skipped. It reads
+// the same configuration as the market, see package config, and exits with
+// status 1 if any address doesn't match.
+func main() {
+	mode, args := "verify", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		mode, args = args[0], args[1:]
+	}
+	if mode != "verify" && mode != "restore" {
+		log.Fatalf("unknown mode '%v', use verify or restore", mode)
+	}
+
+	cfg, err := config.Load(os.Args[0], args)
+	if err != nil {
+		log.Panicf("failed to load configuration: %v", err)
+		return
+	}
+
+	closeStorage, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Panicf("failed to open storage: %v", err)
+		return
+	}
+	defer closeStorage()
+
+	if os.Getenv(wallet.SeedEnv) == "" {
+		log.Panicf("wallet seed is missing, set %v", wallet.SeedEnv)
+		return
+	}
+	if err = wallet.Init(os.Getenv(wallet.SeedEnv)); err != nil {
+		log.Panicf("failed to initialize wallet seed from %v: %v", wallet.SeedEnv, err)
+		return
+	}
+	if mode == "restore" {
+		if err = keystore.Init(os.Getenv(keystore.MasterKeyEnv)); err != nil {
+			log.Panicf("failed to initialize keystore from %v: %v", keystore.MasterKeyEnv, err)
+			return
+		}
+	}
+
+	users, err := storage.ListUsers()
+	if err != nil {
+		log.Panic("failed to read storage")
+		return
+	}
+
+	derived, mismatched := 0, 0
+	for _, userid := range users {
+		ok, err := recoverUser(userid, mode == "restore")
+		if err == storage.ErrNoWalletIndex {
+			continue
+		}
+		derived++
+		if err != nil {
+			fmt.Printf("%v: %v\n", userid, err)
+		}
+		if !ok {
+			mismatched++
+		}
+	}
+
+	fmt.Printf("%v users, %v with derived keys, %v not matching\n", len(users), derived, mismatched)
+	if mismatched > 0 {
+		os.Exit(1)
+	}
+}
+
+// recoverUser regenerates the user's keys and returns whether the address
+// matches. The Stark key is only restored if it is the one derived from the
+// regenerated key, users may have imported theirs.
+func recoverUser(userid string, restore bool) (bool, error) {
+	index, err := storage.GetUserWalletIndex(userid)
+	if err != nil {
+		return false, err
+	}
+	privateKey, err := wallet.UserKey(index)
+	if err != nil {
+		return false, err
+	}
+	address := crypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+	stored, err := storage.GetUserAddress(userid)
+	if err != nil {
+		return false, fmt.Errorf("failed to read address: %v", err)
+	}
+	if !strings.EqualFold(string(stored), address) {
+		return false, fmt.Errorf("index %v derives %v, stored address is %s", index, address, stored)
+	}
+
+	starkKey, err := wallet.DeriveStarkKey(privateKey)
+	if err != nil {
+		return true, fmt.Errorf("failed to derive Stark key: %v", err)
+	}
+	l2signer, err := stark.NewSigner(starkKey)
+	if err != nil {
+		return true, fmt.Errorf("failed to derive Stark key: %v", err)
+	}
+	storedStark, _ := storage.GetUserStarkAddress(userid)
+	starkDerived := strings.EqualFold(string(storedStark), l2signer.GetAddress())
+
+	fmt.Printf("%v: index %v, address %v matches", userid, index, address)
+	if !starkDerived {
+		fmt.Print(", Stark key isn't derived")
+	}
+	fmt.Println()
+	if !restore {
+		return true, nil
+	}
+
+	err = keystore.SetUserPrivateKey(userid, []byte(hexutil.Encode(crypto.FromECDSA(privateKey))[2:]))
+	if err != nil {
+		return true, fmt.Errorf("failed to restore private key: %v", err)
+	}
+	if starkDerived {
+		err = keystore.SetUserStarkPrivateKey(userid, []byte(fmt.Sprintf("%x", starkKey)))
+		if err != nil {
+			return true, fmt.Errorf("failed to restore Stark private key: %v", err)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,384 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/ethereum/go-ethereum/common"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// EnvPrefix is prepended to the upper-cased flag name to get the environment
+// variable overriding a setting, e.g. NFT_MARKET_LISTEN for -listen.
+const EnvPrefix = "NFT_MARKET_"
+
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+type Storage struct {
+	// Backend is either "fs" or "bolt"
+	Backend string `json:"backend"`
+	// Path is the directory of the fs backend, it has to end with a slash
+	Path string `json:"path"`
+	// Database is the file of the bolt backend, defaults to Path + "market.db"
+	Database string `json:"database"`
+	// LegacyPath is the directory older versions wrote collections to, they
+	// are moved into storage once, not at all if it is empty
+	LegacyPath string `json:"legacy_path"`
+}
+
+type Signer struct {
+	// Provider is either "keystore" or "daemon". With "daemon" the market
+	// holds neither the keystore master key nor the wallet seed, signerd
+	// listening on Socket creates and seals keys and signs with them.
+	Provider string `json:"provider"`
+	Socket   string `json:"socket"`
+}
+
+// Currency is an ERC-20 token accepted for sales, deposits and withdrawals
+// besides ETH.
+type Currency struct {
+	Symbol          string `json:"symbol"`
+	ContractAddress string `json:"contract_address"`
+	Decimals        int    `json:"decimals"`
+}
+
+type Marketplace struct {
+	// Provider is either "imx" or "fake", which keeps everything in memory
+	// and is only meant for development
+	Provider string `json:"provider"`
+	// Environment is the ImmutableX environment, "sandbox" or "mainnet"
+	Environment string `json:"environment"`
+	// APIURL points the imx provider at a mock API instead of Environment
+	APIURL            string  `json:"api_url"`
+	AlchemyAPIKey     string  `json:"alchemy_api_key"`
+	RoyaltyPercentage float64 `json:"royalty_percentage"`
+	// Currencies can only be set in the configuration file
+	Currencies []Currency `json:"currencies"`
+}
+
+type Workers struct {
+	Max      int `json:"max"`
+	Capacity int `json:"capacity"`
+}
+
+// Duration is a time.Duration written as a string like "90s" or "1h" in the
+// configuration file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+type Jobs struct {
+	// MaxAttempts is how many times a failing job is retried before it is
+	// given up on
+	MaxAttempts int `json:"max_attempts"`
+	// RetryDelay is the first backoff delay, doubled on every further retry
+	// or poll up to MaxRetryDelay
+	RetryDelay    Duration `json:"retry_delay"`
+	MaxRetryDelay Duration `json:"max_retry_delay"`
+}
+
+type Auth struct {
+	// RequireSignature rejects requests authenticated with a bare API key,
+	// they have to be HMAC signed
+	RequireSignature bool `json:"require_signature"`
+	// SignatureWindow is how far the timestamp of a signed request may be
+	// off, nonces are remembered for as long
+	SignatureWindow Duration `json:"signature_window"`
+	// KeyTTL is the lifetime of new API keys, they don't expire if it is 0
+	KeyTTL Duration `json:"key_ttl"`
+}
+
+// SIWE configures Sign-In with Ethereum (EIP-4361) for users bringing their
+// own wallet. It is disabled if Domain is empty.
+type SIWE struct {
+	// Domain is the host the messages have to be issued for
+	Domain string `json:"domain"`
+	// URI is the URI the messages have to be issued for, any if empty
+	URI string `json:"uri"`
+	// NonceTTL is how long an issued nonce can be signed in with
+	NonceTTL Duration `json:"nonce_ttl"`
+	// SessionTTL is the lifetime of the API keys issued on sign in
+	SessionTTL Duration `json:"session_ttl"`
+}
+
+type Config struct {
+	Listen string `json:"listen"`
+	// AdminListen serves the admin API, keep it on a private address
+	AdminListen string `json:"admin_listen"`
+	// AdminToken is the bearer token the admin API requires, it can only be
+	// left empty if AdminListen is a loopback address
+	AdminToken  string      `json:"admin_token"`
+	TLS         TLS         `json:"tls"`
+	Storage     Storage     `json:"storage"`
+	Signer      Signer      `json:"signer"`
+	Marketplace Marketplace `json:"marketplace"`
+	Workers     Workers     `json:"workers"`
+	Jobs        Jobs        `json:"jobs"`
+	Auth        Auth        `json:"auth"`
+	SIWE        SIWE        `json:"siwe"`
+}
+
+func Default() *Config {
+	return &Config{
+		Listen:      ":8080",
+		AdminListen: "127.0.0.1:8090",
+		Storage: Storage{
+			Backend:    "fs",
+			Path:       "data/",
+			LegacyPath: "./",
+		},
+		Signer: Signer{
+			Provider: "keystore",
+			Socket:   "signerd.sock",
+		},
+		Marketplace: Marketplace{
+			Provider:          "imx",
+			Environment:       "sandbox",
+			RoyaltyPercentage: 10,
+		},
+		Workers: Workers{
+			Max:      100,
+			Capacity: 1000,
+		},
+		Jobs: Jobs{
+			MaxAttempts:   10,
+			RetryDelay:    Duration(time.Minute),
+			MaxRetryDelay: Duration(time.Hour),
+		},
+		Auth: Auth{
+			SignatureWindow: Duration(5 * time.Minute),
+			KeyTTL:          Duration(90 * 24 * time.Hour),
+		},
+		SIWE: SIWE{
+			NonceTTL:   Duration(10 * time.Minute),
+			SessionTTL: Duration(24 * time.Hour),
+		},
+	}
+}
+
+func (c *Config) flags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	file := fs.String("config", "", "JSON configuration file")
+	fs.StringVar(&c.Listen, "listen", c.Listen, "address to serve the API on")
+	fs.StringVar(&c.AdminListen, "admin-listen", c.AdminListen, "address to serve the admin API on, disabled if empty")
+	fs.StringVar(&c.AdminToken, "admin-token", c.AdminToken, "bearer token required by the admin API, better set in the environment")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "TLS certificate file, serves plain HTTP if empty")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "TLS private key file")
+	fs.StringVar(&c.Storage.Backend, "storage", c.Storage.Backend, "storage backend: fs or bolt")
+	fs.StringVar(&c.Storage.Path, "storage-path", c.Storage.Path, "directory of the fs storage backend")
+	fs.StringVar(&c.Storage.Database, "db", c.Storage.Database, "database file used by the bolt storage backend (default <storage-path>market.db)")
+	fs.StringVar(&c.Storage.LegacyPath, "storage-legacy-path", c.Storage.LegacyPath, "directory older versions wrote collections to, migrated once")
+	fs.StringVar(&c.Signer.Provider, "signer", c.Signer.Provider, "signer provider: keystore or daemon")
+	fs.StringVar(&c.Signer.Socket, "signer-socket", c.Signer.Socket, "unix socket of the signing daemon")
+	fs.StringVar(&c.Marketplace.Provider, "marketplace", c.Marketplace.Provider, "marketplace provider: imx or fake (in-memory, offline)")
+	fs.StringVar(&c.Marketplace.Environment, "imx-env", c.Marketplace.Environment, "ImmutableX environment: sandbox or mainnet")
+	fs.StringVar(&c.Marketplace.APIURL, "imx-api", c.Marketplace.APIURL, "base URL of a mock ImmutableX API (see cmd/imxmock) used instead of imx-env")
+	fs.StringVar(&c.Marketplace.AlchemyAPIKey, "alchemy-api-key", c.Marketplace.AlchemyAPIKey, "Alchemy API key for the Ethereum RPC of imx-env")
+	fs.Float64Var(&c.Marketplace.RoyaltyPercentage, "royalty", c.Marketplace.RoyaltyPercentage, "royalty percentage paid to the creator on mints")
+	fs.IntVar(&c.Workers.Max, "workers", c.Workers.Max, "maximum number of background workers")
+	fs.IntVar(&c.Workers.Capacity, "worker-capacity", c.Workers.Capacity, "maximum number of queued background tasks")
+	fs.IntVar(&c.Jobs.MaxAttempts, "job-attempts", c.Jobs.MaxAttempts, "maximum number of attempts of a failing background job")
+	fs.DurationVar((*time.Duration)(&c.Jobs.RetryDelay), "job-retry-delay", time.Duration(c.Jobs.RetryDelay), "initial delay between background job retries")
+	fs.DurationVar((*time.Duration)(&c.Jobs.MaxRetryDelay), "job-max-retry-delay", time.Duration(c.Jobs.MaxRetryDelay), "maximum delay between background job retries")
+	fs.BoolVar(&c.Auth.RequireSignature, "auth-require-signature", c.Auth.RequireSignature, "only accept HMAC signed API requests")
+	fs.DurationVar((*time.Duration)(&c.Auth.SignatureWindow), "auth-signature-window", time.Duration(c.Auth.SignatureWindow), "maximum clock difference of signed API requests")
+	fs.DurationVar((*time.Duration)(&c.Auth.KeyTTL), "auth-key-ttl", time.Duration(c.Auth.KeyTTL), "lifetime of new API keys, 0 for keys that don't expire")
+	fs.StringVar(&c.SIWE.Domain, "siwe-domain", c.SIWE.Domain, "domain of Sign-In with Ethereum messages, disabled if empty")
+	fs.StringVar(&c.SIWE.URI, "siwe-uri", c.SIWE.URI, "URI of Sign-In with Ethereum messages, any if empty")
+	fs.DurationVar((*time.Duration)(&c.SIWE.NonceTTL), "siwe-nonce-ttl", time.Duration(c.SIWE.NonceTTL), "how long a Sign-In with Ethereum nonce is valid")
+	fs.DurationVar((*time.Duration)(&c.SIWE.SessionTTL), "siwe-session-ttl", time.Duration(c.SIWE.SessionTTL), "lifetime of the API keys issued on Sign-In with Ethereum")
+	return fs, file
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load builds the configuration from, in increasing order of precedence,
+// the defaults, the JSON file given by -config (or NFT_MARKET_CONFIG), the
+// NFT_MARKET_* environment variables and the command line flags in args.
+func Load(name string, args []string) (*Config, error) {
+	c := Default()
+	fs, file := c.flags(name)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// flags have been written into c already, remember them so they can be
+	// applied again on top of the file and the environment
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	if *file == "" {
+		*file = os.Getenv(envName("config"))
+	}
+	if *file != "" {
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return nil, err
+		}
+		if err = json.Unmarshal(data, c); err != nil {
+			return nil, errors.New("invalid configuration file " + *file + ": " + err.Error())
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || f.Name == "config" || err != nil {
+			return
+		}
+		if e := f.Value.Set(value); e != nil {
+			err = errors.New("invalid " + envName(f.Name) + ": " + e.Error())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for name, value := range set {
+		if err = fs.Set(name, value); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.Storage.Database == "" {
+		c.Storage.Database = c.Storage.Path + "market.db"
+	}
+	if err = c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func oneOf(value string, allowed ...string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
+
+// loopback tells whether the listen address only accepts local connections.
+// An empty host listens on every interface.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil || host == "" {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func validateCurrencies(currencies []Currency) error {
+	seen := make(map[string]bool)
+	for _, currency := range currencies {
+		symbol := strings.ToUpper(currency.Symbol)
+		if symbol == "" {
+			return errors.New("currency symbol is empty")
+		}
+		if symbol == "ETH" {
+			return errors.New("currency ETH is built in")
+		}
+		if !common.IsHexAddress(currency.ContractAddress) {
+			return errors.New("invalid contract address of currency " + currency.Symbol)
+		}
+		address := strings.ToLower(currency.ContractAddress)
+		if seen[symbol] || seen[address] {
+			return errors.New("currency " + currency.Symbol + " is configured twice")
+		}
+		seen[symbol], seen[address] = true, true
+		if currency.Decimals < 0 || currency.Decimals > 36 {
+			return errors.New("decimals of currency " + currency.Symbol + " have to be between 0 and 36")
+		}
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return errors.New("listen address is empty")
+	}
+	if c.AdminListen != "" && c.AdminToken == "" && !loopback(c.AdminListen) {
+		return errors.New("admin API on " + c.AdminListen + " needs an admin token, it isn't a loopback address")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return errors.New("TLS needs both a certificate and a key file")
+	}
+	if !oneOf(c.Storage.Backend, "fs", "bolt") {
+		return errors.New("unknown storage backend '" + c.Storage.Backend + "'")
+	}
+	if c.Storage.Path == "" || !strings.HasSuffix(c.Storage.Path, "/") {
+		return errors.New("storage path has to end with a slash")
+	}
+	if c.Storage.LegacyPath != "" && !strings.HasSuffix(c.Storage.LegacyPath, "/") {
+		return errors.New("legacy storage path has to end with a slash")
+	}
+	if !oneOf(c.Signer.Provider, "keystore", "daemon") {
+		return errors.New("unknown signer provider '" + c.Signer.Provider + "'")
+	}
+	if c.Signer.Provider == "daemon" && c.Signer.Socket == "" {
+		return errors.New("signer socket is empty")
+	}
+	if !oneOf(c.Marketplace.Provider, "imx", "fake") {
+		return errors.New("unknown marketplace provider '" + c.Marketplace.Provider + "'")
+	}
+	if !oneOf(c.Marketplace.Environment, "sandbox", "mainnet") {
+		return errors.New("unknown ImmutableX environment '" + c.Marketplace.Environment + "'")
+	}
+	if c.Marketplace.Provider == "imx" && c.Marketplace.APIURL == "" && c.Marketplace.AlchemyAPIKey == "" {
+		return errors.New("imx marketplace needs an Alchemy API key")
+	}
+	if c.Marketplace.RoyaltyPercentage < 0 || c.Marketplace.RoyaltyPercentage > 100 {
+		return errors.New("royalty percentage has to be between 0 and 100")
+	}
+	if err := validateCurrencies(c.Marketplace.Currencies); err != nil {
+		return err
+	}
+	if c.Workers.Max <= 0 || c.Workers.Capacity < 0 {
+		return errors.New("invalid worker pool size")
+	}
+	if c.Jobs.MaxAttempts <= 0 {
+		return errors.New("job attempts have to be positive")
+	}
+	if c.Jobs.RetryDelay <= 0 || c.Jobs.MaxRetryDelay < c.Jobs.RetryDelay {
+		return errors.New("invalid job retry delays")
+	}
+	if c.Auth.SignatureWindow <= 0 {
+		return errors.New("signature window has to be positive")
+	}
+	if c.Auth.KeyTTL < 0 {
+		return errors.New("API key lifetime can't be negative")
+	}
+	if c.SIWE.Domain != "" && (c.SIWE.NonceTTL <= 0 || c.SIWE.SessionTTL <= 0) {
+		return errors.New("Sign-In with Ethereum nonce and session lifetimes have to be positive")
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package currency describes what prices, deposits and withdrawals are paid
+// in: ETH, and the ERC-20 tokens configured for the market.
+package currency
+
+import (
+	"errors"
+	"math/big"
+	"nft-market/config"
+	"nft-market/wei"
+	"regexp"
+	"strings"
+)
+
+type Currency struct {
+	Symbol string
+	// Address is the ERC-20 contract of the token, empty for ETH
+	Address  string
+	Decimals int
+}
+
+// ETH is always accepted, amounts of it are in wei
+var ETH = &Currency{Symbol: "ETH", Decimals: 18}
+
+var currencies = []*Currency{ETH}
+
+var (
+	ErrUnknown = errors.New("is not a configured currency")
+	ErrSymbol  = errors.New("is in another currency")
+)
+
+var amount = regexp.MustCompile(`^([0-9.]+)\s*([a-zA-Z]*)$`)
+
+// Configure sets the ERC-20 tokens accepted besides ETH. It is expected to
+// be called once on startup, before serving any requests.
+func Configure(cfgs []config.Currency) {
+	currencies = []*Currency{ETH}
+	for _, cfg := range cfgs {
+		currencies = append(currencies, &Currency{
+			Symbol:   strings.ToUpper(cfg.Symbol),
+			Address:  strings.ToLower(cfg.ContractAddress),
+			Decimals: cfg.Decimals,
+		})
+	}
+}
+
+// Lookup finds a currency by symbol or contract address, case insensitive.
+// An empty value is ETH.
+func Lookup(value string) (*Currency, error) {
+	if value == "" {
+		return ETH, nil
+	}
+	for _, currency := range currencies {
+		if strings.EqualFold(currency.Symbol, value) || (currency.Address != "" && strings.EqualFold(currency.Address, value)) {
+			return currency, nil
+		}
+	}
+	return nil, ErrUnknown
+}
+
+// Key identifies the currency in storage, it is the contract address of
+// ERC-20 tokens and the symbol of ETH, either can be passed to Lookup.
+func (c *Currency) Key() string {
+	if c.Address == "" {
+		return c.Symbol
+	}
+	return c.Address
+}
+
+func (c *Currency) IsETH() bool {
+	return c.Address == ""
+}
+
+// Parse converts an amount into base units of the currency. Amounts without
+// a unit are in base units whatever the currency, wei for ETH, so they can't
+// have a fraction. With a unit they are decimal numbers: ETH amounts in any
+// unit of wei.Parse, ERC-20 amounts in tokens, e.g. "12.5 USDC" for
+// 12500000 base units of a 6 decimals token.
+func (c *Currency) Parse(value string) (*big.Int, error) {
+	if c.IsETH() {
+		return wei.Parse(value)
+	}
+
+	match := amount.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return nil, wei.ErrInvalid
+	}
+	if match[2] == "" {
+		if strings.Contains(match[1], ".") {
+			return nil, wei.ErrNoUnit
+		}
+		return wei.Scale(match[1], 0)
+	}
+	if !strings.EqualFold(match[2], c.Symbol) {
+		return nil, ErrSymbol
+	}
+	return wei.Scale(match[1], c.Decimals)
+}
+
+// Format writes base units of the currency as a decimal number of whole
+// ETH or tokens, e.g. "1.5".
+func (c *Currency) Format(value *big.Int) string {
+	return wei.Format(value, c.Decimals)
+}
+
+// FormatString is Format for amounts stored as decimal strings of base
+// units, it returns an empty string for values that aren't amounts.
+func (c *Currency) FormatString(value string) string {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return ""
+	}
+	return c.Format(amount)
+}
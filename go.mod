@@ -3,21 +3,23 @@ module nft-market
 go 1.20
 
 require (
+	github.com/alitto/pond v1.8.3
 	github.com/ethereum/go-ethereum v1.10.25
+	github.com/google/uuid v1.3.0
 	github.com/holiman/uint256 v1.2.0
 	github.com/immutable/imx-core-sdk-golang v0.2.2
 	github.com/labstack/echo/v4 v4.10.2
+	github.com/tyler-smith/go-bip39 v1.1.0
+	go.etcd.io/bbolt v1.3.7
 )
 
 require (
-	github.com/alitto/pond v1.8.3 // indirect
 	github.com/btcsuite/btcd/btcec/v2 v2.2.1 // indirect
 	github.com/deckarep/golang-set v1.8.0 // indirect
 	github.com/decred/dcrd/dcrec/secp256k1/v4 v4.1.0 // indirect
 	github.com/dontpanicdao/caigo v0.3.1 // indirect
 	github.com/go-ole/go-ole v1.2.6 // indirect
 	github.com/go-stack/stack v1.8.1 // indirect
-	github.com/google/uuid v1.3.0 // indirect
 	github.com/gorilla/websocket v1.5.0 // indirect
 	github.com/labstack/gommon v0.4.0 // indirect
 	github.com/mattn/go-colorable v0.1.13 // indirect
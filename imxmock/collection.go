@@ -0,0 +1,160 @@
+package imxmock
+
+import (
+	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx/api"
+	"net/http"
+	"time"
+)
+
+type collection struct {
+	info   api.Collection
+	schema []api.MetadataSchemaRequest
+}
+
+func requireIMXSignature(r *http.Request) error {
+	if r.Header.Get("IMX-Signature") == "" || r.Header.Get("IMX-Timestamp") == "" {
+		return errors.New("missing IMX-Signature or IMX-Timestamp header")
+	}
+	return nil
+}
+
+func nullableString(value *string) api.NullableString {
+	return *api.NewNullableString(value)
+}
+
+func (m *Mock) createProject(r *http.Request) (int, interface{}, error) {
+	if err := requireIMXSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateProjectRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if req.Name == "" || req.CompanyName == "" || req.ContactEmail == "" {
+		return http.StatusBadRequest, nil, errors.New("project name, company name and contact email are required")
+	}
+	id := m.nextID()
+	m.projects[id] = req
+	return http.StatusCreated, api.CreateProjectResponse{Id: id}, nil
+}
+
+func (m *Mock) createCollection(r *http.Request) (int, interface{}, error) {
+	if err := requireIMXSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateCollectionRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if req.ContractAddress == "" || req.Name == "" || req.OwnerPublicKey == "" {
+		return http.StatusBadRequest, nil, errors.New("contract address, name and owner public key are required")
+	}
+	if _, ok := m.projects[req.ProjectId]; !ok {
+		return http.StatusNotFound, nil, errors.New("project doesn't exist")
+	}
+	address := user(req.ContractAddress)
+	if _, ok := m.collections[address]; ok {
+		return http.StatusConflict, nil, errors.New("collection " + req.ContractAddress + " already exists")
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	c := &collection{info: api.Collection{
+		Address:            address,
+		CollectionImageUrl: nullableString(req.CollectionImageUrl),
+		CreatedAt:          nullableString(&now),
+		Description:        nullableString(req.Description),
+		IconUrl:            nullableString(req.IconUrl),
+		MetadataApiUrl:     nullableString(req.MetadataApiUrl),
+		Name:               req.Name,
+		ProjectId:          req.ProjectId,
+		UpdatedAt:          nullableString(&now),
+	}}
+	m.collections[address] = c
+	return http.StatusCreated, c.info, nil
+}
+
+func (m *Mock) updateCollection(r *http.Request, address string) (int, interface{}, error) {
+	if err := requireIMXSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	c, ok := m.collections[user(address)]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("collection " + address + " doesn't exist")
+	}
+	var req api.UpdateCollectionRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	if req.Name != nil {
+		c.info.Name = *req.Name
+	}
+	if req.Description != nil {
+		c.info.Description = nullableString(req.Description)
+	}
+	if req.IconUrl != nil {
+		c.info.IconUrl = nullableString(req.IconUrl)
+	}
+	if req.CollectionImageUrl != nil {
+		c.info.CollectionImageUrl = nullableString(req.CollectionImageUrl)
+	}
+	if req.MetadataApiUrl != nil {
+		c.info.MetadataApiUrl = nullableString(req.MetadataApiUrl)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	c.info.UpdatedAt = nullableString(&now)
+	return http.StatusOK, c.info, nil
+}
+
+func (m *Mock) getCollection(address string) (int, interface{}, error) {
+	c, ok := m.collections[user(address)]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("collection " + address + " doesn't exist")
+	}
+	return http.StatusOK, c.info, nil
+}
+
+// addMetadataSchema only adds fields, like IMX existing ones can only be
+// changed one by one
+func (m *Mock) addMetadataSchema(r *http.Request, address string) (int, interface{}, error) {
+	if err := requireIMXSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	c, ok := m.collections[user(address)]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("collection " + address + " doesn't exist")
+	}
+	var req api.AddMetadataSchemaToCollectionRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	for _, field := range req.Metadata {
+		for _, existing := range c.schema {
+			if existing.Name == field.Name {
+				return http.StatusConflict, nil, errors.New("metadata field " + field.Name + " already exists")
+			}
+		}
+	}
+	c.schema = append(c.schema, req.Metadata...)
+	return http.StatusCreated, api.SuccessResponse{Result: "success"}, nil
+}
+
+func (m *Mock) getMetadataSchema(address string) (int, interface{}, error) {
+	c, ok := m.collections[user(address)]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("collection " + address + " doesn't exist")
+	}
+	schema := make([]api.MetadataSchemaProperty, 0, len(c.schema))
+	for _, field := range c.schema {
+		property := api.MetadataSchemaProperty{Name: field.Name, Type: "text"}
+		if field.Type != nil {
+			property.Type = *field.Type
+		}
+		if field.Filterable != nil {
+			property.Filterable = *field.Filterable
+		}
+		schema = append(schema, property)
+	}
+	return http.StatusOK, schema, nil
+}
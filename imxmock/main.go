@@ -0,0 +1,874 @@
+package imxmock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"github.com/immutable/imx-core-sdk-golang/imx/api"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ethTokenType     = "ETH"
+	erc20TokenType   = "ERC20"
+	erc721TokenType  = "ERC721"
+	signableLifetime = 30 * 24 * time.Hour
+)
+
+// ChainID is answered to eth_chainId and net_version, clients are pointed
+// at the mock with nftimx.MockEnvironment, which has to use the same chain.
+var ChainID = big.NewInt(1337)
+
+type order struct {
+	user    string
+	sell    api.SignableToken
+	buy     api.SignableToken
+	amount  *big.Int
+	status  string
+	created time.Time
+	updated time.Time
+}
+
+type withdrawal struct {
+	user     string
+	token    api.SignableToken
+	amount   *big.Int
+	status   string
+	prepared time.Time
+}
+
+type transfer struct {
+	sender   string
+	receiver string
+	token    api.SignableToken
+	amount   *big.Int
+}
+
+type mint struct {
+	user  string
+	token api.SignableToken
+}
+
+// pending is a request that has been made signable but not submitted yet,
+// keyed by the nonce handed out with the signable details.
+type pending struct {
+	kind     string
+	user     string
+	order    *order
+	orderID  int32
+	transfer *transfer
+	withdraw *withdrawal
+}
+
+// Mock implements the ImmutableX v1 REST endpoints used by the SDK workflows
+// (registration, projects, collections, mints, orders, trades, transfers and
+// withdrawals) on top of in-memory state. Fungible balances have to be
+// seeded with Fund since deposits happen on L1. Withdrawals are rolled up
+// ConfirmAfter after they were created.
+type Mock struct {
+	ConfirmAfter time.Duration
+
+	mutex       sync.Mutex
+	lastID      int32
+	users       map[string]string
+	balances    map[string]map[string]*big.Int
+	owners      map[string]string
+	mints       map[int32]*mint
+	orders      map[int32]*order
+	trades      map[int32]int32
+	transfers   map[int32]*transfer
+	withdrawals map[int32]*withdrawal
+	pending     map[int32]*pending
+	projects    map[int32]api.CreateProjectRequest
+	collections map[string]*collection
+}
+
+func New() *Mock {
+	return &Mock{
+		users:       make(map[string]string),
+		balances:    make(map[string]map[string]*big.Int),
+		owners:      make(map[string]string),
+		mints:       make(map[int32]*mint),
+		orders:      make(map[int32]*order),
+		trades:      make(map[int32]int32),
+		transfers:   make(map[int32]*transfer),
+		withdrawals: make(map[int32]*withdrawal),
+		pending:     make(map[int32]*pending),
+		projects:    make(map[int32]api.CreateProjectRequest),
+		collections: make(map[string]*collection),
+	}
+}
+
+// NewServer starts a mock on a local port, for use in tests. The caller is
+// responsible for closing the server.
+func NewServer() (*httptest.Server, *Mock) {
+	m := New()
+	return httptest.NewServer(m), m
+}
+
+// Fund credits the L1 address with amount of the given token, ETH when token is nil.
+func (m *Mock) Fund(address string, token *api.SignableToken, amount *big.Int) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if token == nil {
+		eth := imx.SignableETHToken()
+		token = &eth
+	}
+	balance := m.balance(user(address), fungibleKey(*token))
+	balance.Add(balance, amount)
+}
+
+// Balance returns the L1 address balance of the given token, ETH when token is nil.
+func (m *Mock) Balance(address string, token *api.SignableToken) *big.Int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if token == nil {
+		eth := imx.SignableETHToken()
+		token = &eth
+	}
+	return new(big.Int).Set(m.balance(user(address), fungibleKey(*token)))
+}
+
+// Owner returns the lowercased L1 address holding the ERC-721 asset.
+func (m *Mock) Owner(contractAddress string, tokenID string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.owners[assetKey(imx.SignableERC721Token(tokenID, contractAddress))]
+}
+
+func tokenType(token api.SignableToken) string {
+	if token.Type == nil {
+		return ""
+	}
+	return *token.Type
+}
+
+func tokenData(token api.SignableToken, key string) string {
+	value, _ := token.Data[key].(string)
+	return value
+}
+
+func fungibleKey(token api.SignableToken) string {
+	if tokenType(token) == erc20TokenType {
+		return erc20TokenType + ":" + strings.ToLower(tokenData(token, "token_address"))
+	}
+	return ethTokenType
+}
+
+func assetKey(token api.SignableToken) string {
+	if tokenType(token) == erc721TokenType {
+		return strings.ToLower(tokenData(token, "token_address")) + "/" + tokenData(token, "token_id")
+	}
+	return fungibleKey(token)
+}
+
+func assetID(token api.SignableToken) string {
+	return "0x" + hex.EncodeToString(crypto.Keccak256([]byte(assetKey(token))))[:62]
+}
+
+func user(address string) string {
+	return strings.ToLower(address)
+}
+
+// Must be called with the mutex held.
+func (m *Mock) nextID() int32 {
+	m.lastID++
+	return m.lastID
+}
+
+// Must be called with the mutex held.
+func (m *Mock) balance(user string, key string) *big.Int {
+	balances, ok := m.balances[user]
+	if !ok {
+		balances = make(map[string]*big.Int)
+		m.balances[user] = balances
+	}
+	balance, ok := balances[key]
+	if !ok {
+		balance = new(big.Int)
+		balances[key] = balance
+	}
+	return balance
+}
+
+// starkKey returns the registered Stark key of the user, or a stable
+// placeholder for users that never registered.
+// Must be called with the mutex held.
+func (m *Mock) starkKey(user string) string {
+	if key, ok := m.users[user]; ok {
+		return key
+	}
+	return "0x" + hex.EncodeToString(crypto.Keccak256([]byte(user)))[:62]
+}
+
+// Must be called with the mutex held.
+func (m *Mock) hasAsset(user string, token api.SignableToken, amount *big.Int) bool {
+	if tokenType(token) == erc721TokenType {
+		return m.owners[assetKey(token)] == user
+	}
+	return m.balance(user, fungibleKey(token)).Cmp(amount) >= 0
+}
+
+// Must be called with the mutex held.
+func (m *Mock) moveAsset(from string, to string, token api.SignableToken, amount *big.Int) {
+	if tokenType(token) == erc721TokenType {
+		m.owners[assetKey(token)] = to
+		return
+	}
+	key := fungibleKey(token)
+	m.balance(from, key).Sub(m.balance(from, key), amount)
+	if to != "" {
+		m.balance(to, key).Add(m.balance(to, key), amount)
+	}
+}
+
+// Must be called with the mutex held.
+func (m *Mock) addPending(p *pending) int32 {
+	nonce := m.nextID()
+	m.pending[nonce] = p
+	return nonce
+}
+
+// Must be called with the mutex held.
+func (m *Mock) takePending(nonce int32, kind string) (*pending, error) {
+	p, ok := m.pending[nonce]
+	if !ok || p.kind != kind {
+		return nil, errors.New("unknown nonce " + strconv.FormatInt(int64(nonce), 10))
+	}
+	delete(m.pending, nonce)
+	return p, nil
+}
+
+func payloadHash() string {
+	// 31 random bytes always fit into the Stark field
+	buf := make([]byte, 31)
+	_, _ = rand.Read(buf)
+	return "0x" + hex.EncodeToString(buf)
+}
+
+func expiration() int32 {
+	return int32(time.Now().Add(signableLifetime).Unix() / 3600)
+}
+
+func parseAmount(amount string) (*big.Int, error) {
+	value, ok := new(big.Int).SetString(amount, 10)
+	if !ok || value.Sign() < 0 {
+		return nil, errors.New("invalid amount " + amount)
+	}
+	return value, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, api.APIError{Code: http.StatusText(status), Message: err.Error()})
+}
+
+func readJSON(r *http.Request, body interface{}) error {
+	return json.NewDecoder(r.Body).Decode(body)
+}
+
+func requireSignature(r *http.Request) error {
+	if r.Header.Get("x-imx-eth-address") == "" || r.Header.Get("x-imx-eth-signature") == "" {
+		return errors.New("missing x-imx-eth-address or x-imx-eth-signature header")
+	}
+	return nil
+}
+
+func (m *Mock) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/rpc/") {
+		m.serveRPC(w, r)
+		return
+	}
+
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	route := r.Method + " " + strings.Join(path, "/")
+	var id string
+	if len(path) >= 3 {
+		id = path[2]
+		route = r.Method + " " + path[0] + "/" + path[1] + "/{id}"
+		if len(path) > 3 {
+			route += "/" + strings.Join(path[3:], "/")
+		}
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	var status int
+	var res interface{}
+	var err error
+	switch route {
+	case "POST v1/signable-registration-offchain":
+		status, res, err = m.signableRegistration(r)
+	case "POST v1/users":
+		status, res, err = m.registerUser(r)
+	case "GET v1/users/{id}":
+		status, res, err = m.getUser(id)
+	case "POST v2/mints":
+		status, res, err = m.mintTokens(r)
+	case "GET v1/mints/{id}":
+		status, res, err = m.getMint(id)
+	case "POST v3/signable-order-details":
+		status, res, err = m.signableOrder(r)
+	case "POST v1/orders":
+		status, res, err = m.createOrder(r)
+	case "GET v1/orders/{id}":
+		status, res, err = m.getOrder(id)
+	case "POST v1/signable-cancel-order-details":
+		status, res, err = m.signableCancelOrder(r)
+	case "DELETE v1/orders/{id}":
+		status, res, err = m.cancelOrder(r, id)
+	case "POST v3/signable-trade-details":
+		status, res, err = m.signableTrade(r)
+	case "POST v1/trades":
+		status, res, err = m.createTrade(r)
+	case "POST v1/signable-transfer-details":
+		status, res, err = m.signableTransferV1(r)
+	case "POST v1/transfers":
+		status, res, err = m.createTransferV1(r)
+	case "POST v2/signable-transfer-details":
+		status, res, err = m.signableTransfer(r)
+	case "POST v2/transfers":
+		status, res, err = m.createTransfer(r)
+	case "POST v1/signable-withdrawal-details":
+		status, res, err = m.signableWithdrawal(r)
+	case "POST v1/withdrawals":
+		status, res, err = m.createWithdrawal(r)
+	case "GET v1/withdrawals/{id}":
+		status, res, err = m.getWithdrawal(id)
+	case "POST v1/projects":
+		status, res, err = m.createProject(r)
+	case "POST v1/collections":
+		status, res, err = m.createCollection(r)
+	case "PATCH v1/collections/{id}":
+		status, res, err = m.updateCollection(r, id)
+	case "GET v1/collections/{id}":
+		status, res, err = m.getCollection(id)
+	case "POST v1/collections/{id}/metadata-schema":
+		status, res, err = m.addMetadataSchema(r, id)
+	case "GET v1/collections/{id}/metadata-schema":
+		status, res, err = m.getMetadataSchema(id)
+	default:
+		status, err = http.StatusNotFound, errors.New("no route for "+r.Method+" "+r.URL.Path)
+	}
+
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, status, res)
+}
+
+func parseID(id string) (int32, error) {
+	value, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid ID " + id)
+	}
+	return int32(value), nil
+}
+
+func (m *Mock) signableRegistration(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableRegistrationRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	return http.StatusOK, api.GetSignableRegistrationOffchainResponse{
+		PayloadHash:     payloadHash(),
+		SignableMessage: "Only sign this key linking request from Immutable X",
+	}, nil
+}
+
+func (m *Mock) registerUser(r *http.Request) (int, interface{}, error) {
+	var req api.RegisterUserRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	if req.EthSignature == "" || req.StarkSignature == "" {
+		return http.StatusBadRequest, nil, errors.New("missing signatures")
+	}
+	if _, ok := m.users[user(req.EtherKey)]; ok {
+		return http.StatusConflict, nil, errors.New("user " + req.EtherKey + " is already registered")
+	}
+	m.users[user(req.EtherKey)] = req.StarkKey
+	return http.StatusOK, api.RegisterUserResponse{TxHash: payloadHash()}, nil
+}
+
+func (m *Mock) getUser(address string) (int, interface{}, error) {
+	key, ok := m.users[user(address)]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("user " + address + " is not registered")
+	}
+	return http.StatusOK, api.GetUsersApiResponse{Accounts: []string{key}}, nil
+}
+
+func (m *Mock) mintTokens(r *http.Request) (int, interface{}, error) {
+	var req []api.MintRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+
+	results := make([]api.MintResultDetails, 0)
+	for _, request := range req {
+		if request.AuthSignature == "" {
+			return http.StatusBadRequest, nil, errors.New("missing auth signature")
+		}
+		for _, mintUser := range request.Users {
+			for _, token := range mintUser.Tokens {
+				signable := imx.SignableERC721Token(token.Id, request.ContractAddress)
+				if _, ok := m.owners[assetKey(signable)]; ok {
+					return http.StatusConflict, nil, errors.New("token " + token.Id + " is already minted")
+				}
+				m.owners[assetKey(signable)] = user(mintUser.User)
+				id := m.nextID()
+				m.mints[id] = &mint{user: user(mintUser.User), token: signable}
+				results = append(results, api.MintResultDetails{
+					ContractAddress: request.ContractAddress,
+					TokenId:         token.Id,
+					TxId:            id,
+				})
+			}
+		}
+	}
+	return http.StatusOK, api.MintTokensResponse{Results: results}, nil
+}
+
+func (m *Mock) getMint(id string) (int, interface{}, error) {
+	mintID, err := parseID(id)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	minted, ok := m.mints[mintID]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("mint " + id + " doesn't exist")
+	}
+	return http.StatusOK, map[string]interface{}{
+		"transaction_id": mintID,
+		"status":         "success",
+		"user":           minted.user,
+		"token":          minted.token,
+	}, nil
+}
+
+func (m *Mock) signableOrder(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableOrderRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	amount, err := parseAmount(req.AmountBuy)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	seller := user(req.User)
+	if !m.hasAsset(seller, req.TokenSell, big.NewInt(1)) {
+		return http.StatusBadRequest, nil, errors.New("asset is not owned by " + req.User)
+	}
+
+	nonce := m.addPending(&pending{
+		kind: "order",
+		user: seller,
+		order: &order{
+			user:   seller,
+			sell:   req.TokenSell,
+			buy:    req.TokenBuy,
+			amount: amount,
+		},
+	})
+	return http.StatusOK, api.GetSignableOrderResponse{
+		AmountBuy:           req.AmountBuy,
+		AmountSell:          req.AmountSell,
+		AssetIdBuy:          assetID(req.TokenBuy),
+		AssetIdSell:         assetID(req.TokenSell),
+		ExpirationTimestamp: expiration(),
+		Nonce:               nonce,
+		PayloadHash:         payloadHash(),
+		SignableMessage:     "Create order " + strconv.FormatInt(int64(nonce), 10),
+		StarkKey:            m.starkKey(seller),
+		VaultIdBuy:          nonce,
+		VaultIdSell:         nonce,
+	}, nil
+}
+
+func (m *Mock) createOrder(r *http.Request) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateOrderRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	p, err := m.takePending(req.Nonce, "order")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	for _, existing := range m.orders {
+		if existing.status == "active" && assetKey(existing.sell) == assetKey(p.order.sell) {
+			return http.StatusConflict, nil, errors.New("asset is already on sale")
+		}
+	}
+
+	id := m.nextID()
+	p.order.status = "active"
+	p.order.created = time.Now()
+	p.order.updated = p.order.created
+	m.orders[id] = p.order
+	return http.StatusOK, api.CreateOrderResponse{OrderId: id, Status: "active", Time: int32(p.order.created.Unix())}, nil
+}
+
+func orderToken(token api.SignableToken, amount *big.Int) api.Token {
+	data := api.TokenData{Quantity: amount.String(), QuantityWithFees: amount.String()}
+	if address := tokenData(token, "token_address"); address != "" {
+		data.TokenAddress = &address
+	}
+	if tokenID := tokenData(token, "token_id"); tokenID != "" {
+		data.TokenId = &tokenID
+		data.Id = &tokenID
+	}
+	return api.Token{Data: data, Type: tokenType(token)}
+}
+
+func (m *Mock) getOrder(id string) (int, interface{}, error) {
+	orderID, err := parseID(id)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	o, ok := m.orders[orderID]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("order " + id + " doesn't exist")
+	}
+	created := o.created.Format(time.RFC3339)
+	updated := o.updated.Format(time.RFC3339)
+	return http.StatusOK, api.Order{
+		AmountSold:          *api.NewNullableString(nil),
+		Buy:                 orderToken(o.buy, o.amount),
+		ExpirationTimestamp: *api.NewNullableString(nil),
+		OrderId:             orderID,
+		Sell:                orderToken(o.sell, big.NewInt(1)),
+		Status:              o.status,
+		Timestamp:           *api.NewNullableString(&created),
+		UpdatedTimestamp:    *api.NewNullableString(&updated),
+		User:                o.user,
+	}, nil
+}
+
+func (m *Mock) signableCancelOrder(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableCancelOrderRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	o, ok := m.orders[req.OrderId]
+	if !ok || o.status != "active" {
+		return http.StatusBadRequest, nil, errors.New("order is not active")
+	}
+	return http.StatusOK, api.GetSignableCancelOrderResponse{
+		OrderId:         req.OrderId,
+		PayloadHash:     payloadHash(),
+		SignableMessage: "Cancel order " + strconv.FormatInt(int64(req.OrderId), 10),
+	}, nil
+}
+
+func (m *Mock) cancelOrder(r *http.Request, id string) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	orderID, err := parseID(id)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	o, ok := m.orders[orderID]
+	if !ok || o.status != "active" {
+		return http.StatusBadRequest, nil, errors.New("order " + id + " is not active")
+	}
+	if o.user != user(r.Header.Get("x-imx-eth-address")) {
+		return http.StatusUnauthorized, nil, errors.New("order " + id + " doesn't belong to the signer")
+	}
+	o.status = "cancelled"
+	o.updated = time.Now()
+	return http.StatusOK, api.CancelOrderResponse{OrderId: orderID, Status: o.status}, nil
+}
+
+func (m *Mock) signableTrade(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableTradeRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	o, ok := m.orders[req.OrderId]
+	if !ok || o.status != "active" {
+		return http.StatusBadRequest, nil, errors.New("order is not active")
+	}
+	buyer := user(req.User)
+	if buyer == o.user {
+		return http.StatusBadRequest, nil, errors.New("can't buy own order")
+	}
+	if !m.hasAsset(buyer, o.buy, o.amount) {
+		return http.StatusBadRequest, nil, errors.New("insufficient balance")
+	}
+
+	nonce := m.addPending(&pending{kind: "trade", user: buyer, orderID: req.OrderId})
+	return http.StatusOK, api.GetSignableTradeResponse{
+		AmountBuy:           "1",
+		AmountSell:          o.amount.String(),
+		AssetIdBuy:          assetID(o.sell),
+		AssetIdSell:         assetID(o.buy),
+		ExpirationTimestamp: expiration(),
+		Nonce:               nonce,
+		PayloadHash:         payloadHash(),
+		SignableMessage:     "Create trade " + strconv.FormatInt(int64(nonce), 10),
+		StarkKey:            m.starkKey(buyer),
+		VaultIdBuy:          nonce,
+		VaultIdSell:         nonce,
+	}, nil
+}
+
+func (m *Mock) createTrade(r *http.Request) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateTradeRequestV1
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	p, err := m.takePending(req.Nonce, "trade")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	o, ok := m.orders[p.orderID]
+	if !ok || o.status != "active" {
+		return http.StatusBadRequest, nil, errors.New("order is not active")
+	}
+	if !m.hasAsset(p.user, o.buy, o.amount) {
+		return http.StatusBadRequest, nil, errors.New("insufficient balance")
+	}
+
+	m.moveAsset(p.user, o.user, o.buy, o.amount)
+	m.moveAsset(o.user, p.user, o.sell, big.NewInt(1))
+	o.status = "filled"
+	o.updated = time.Now()
+	id := m.nextID()
+	m.trades[id] = p.orderID
+	return http.StatusOK, api.CreateTradeResponse{Status: "success", TradeId: id}, nil
+}
+
+// Must be called with the mutex held.
+func (m *Mock) prepareTransfer(sender string, receiver string, token api.SignableToken, amount string) (*api.SignableTransferResponseDetails, error) {
+	value, err := parseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+	if !m.hasAsset(sender, token, value) {
+		return nil, errors.New("insufficient balance")
+	}
+
+	nonce := m.addPending(&pending{
+		kind: "transfer",
+		user: sender,
+		transfer: &transfer{
+			sender:   sender,
+			receiver: user(receiver),
+			token:    token,
+			amount:   value,
+		},
+	})
+	return &api.SignableTransferResponseDetails{
+		Amount:              amount,
+		AssetId:             assetID(token),
+		ExpirationTimestamp: expiration(),
+		Nonce:               nonce,
+		PayloadHash:         payloadHash(),
+		ReceiverStarkKey:    m.starkKey(user(receiver)),
+		ReceiverVaultId:     nonce,
+		SenderVaultId:       nonce,
+		Token:               token,
+	}, nil
+}
+
+// Must be called with the mutex held.
+func (m *Mock) executeTransfer(nonce int32) (int32, error) {
+	p, err := m.takePending(nonce, "transfer")
+	if err != nil {
+		return 0, err
+	}
+	t := p.transfer
+	if !m.hasAsset(t.sender, t.token, t.amount) {
+		return 0, errors.New("insufficient balance")
+	}
+	m.moveAsset(t.sender, t.receiver, t.token, t.amount)
+	id := m.nextID()
+	m.transfers[id] = t
+	return id, nil
+}
+
+func (m *Mock) signableTransferV1(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableTransferRequestV1
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	details, err := m.prepareTransfer(user(req.Sender), req.Receiver, req.Token, req.Amount)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	senderStarkKey := m.starkKey(user(req.Sender))
+	return http.StatusOK, api.GetSignableTransferResponseV1{
+		Amount:              details.Amount,
+		AssetId:             details.AssetId,
+		ExpirationTimestamp: details.ExpirationTimestamp,
+		Nonce:               details.Nonce,
+		PayloadHash:         details.PayloadHash,
+		ReceiverStarkKey:    details.ReceiverStarkKey,
+		ReceiverVaultId:     details.ReceiverVaultId,
+		SenderStarkKey:      &senderStarkKey,
+		SenderVaultId:       details.SenderVaultId,
+		SignableMessage:     "Create transfer " + strconv.FormatInt(int64(details.Nonce), 10),
+	}, nil
+}
+
+func (m *Mock) createTransferV1(r *http.Request) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateTransferRequestV1
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	id, err := m.executeTransfer(req.Nonce)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	return http.StatusOK, api.CreateTransferResponseV1{
+		SentSignature: req.StarkSignature,
+		Status:        "success",
+		Time:          int32(time.Now().Unix()),
+		TransferId:    id,
+	}, nil
+}
+
+func (m *Mock) signableTransfer(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableTransferRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	sender := user(req.SenderEtherKey)
+	responses := make([]api.SignableTransferResponseDetails, 0, len(req.SignableRequests))
+	for _, request := range req.SignableRequests {
+		details, err := m.prepareTransfer(sender, request.Receiver, request.Token, request.Amount)
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+		responses = append(responses, *details)
+	}
+	return http.StatusOK, api.GetSignableTransferResponse{
+		SenderStarkKey:    m.starkKey(sender),
+		SignableMessage:   "Create transfers",
+		SignableResponses: responses,
+	}, nil
+}
+
+func (m *Mock) createTransfer(r *http.Request) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateTransferRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	ids := make([]int32, 0, len(req.Requests))
+	for _, request := range req.Requests {
+		id, err := m.executeTransfer(request.Nonce)
+		if err != nil {
+			return http.StatusBadRequest, nil, err
+		}
+		ids = append(ids, id)
+	}
+	return http.StatusOK, api.CreateTransferResponse{TransferIds: ids}, nil
+}
+
+func (m *Mock) signableWithdrawal(r *http.Request) (int, interface{}, error) {
+	var req api.GetSignableWithdrawalRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	amount, err := parseAmount(req.Amount)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	owner := user(req.User)
+	if !m.hasAsset(owner, req.Token, amount) {
+		return http.StatusBadRequest, nil, errors.New("insufficient balance")
+	}
+
+	nonce := m.addPending(&pending{
+		kind:     "withdrawal",
+		user:     owner,
+		withdraw: &withdrawal{user: owner, token: req.Token, amount: amount},
+	})
+	return http.StatusOK, api.GetSignableWithdrawalResponse{
+		Amount:          req.Amount,
+		AssetId:         assetID(req.Token),
+		Nonce:           nonce,
+		PayloadHash:     payloadHash(),
+		SignableMessage: "Create withdrawal " + strconv.FormatInt(int64(nonce), 10),
+		StarkKey:        m.starkKey(owner),
+		VaultId:         nonce,
+	}, nil
+}
+
+func (m *Mock) createWithdrawal(r *http.Request) (int, interface{}, error) {
+	if err := requireSignature(r); err != nil {
+		return http.StatusUnauthorized, nil, err
+	}
+	var req api.CreateWithdrawalRequest
+	if err := readJSON(r, &req); err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	p, err := m.takePending(req.Nonce, "withdrawal")
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	w := p.withdraw
+	if !m.hasAsset(w.user, w.token, w.amount) {
+		return http.StatusBadRequest, nil, errors.New("insufficient balance")
+	}
+
+	m.moveAsset(w.user, "", w.token, w.amount)
+	w.status = "included"
+	w.prepared = time.Now()
+	id := m.nextID()
+	m.withdrawals[id] = w
+	return http.StatusOK, api.CreateWithdrawalResponse{Status: "success", Time: int32(w.prepared.Unix()), WithdrawalId: id}, nil
+}
+
+func (m *Mock) getWithdrawal(id string) (int, interface{}, error) {
+	withdrawalID, err := parseID(id)
+	if err != nil {
+		return http.StatusBadRequest, nil, err
+	}
+	w, ok := m.withdrawals[withdrawalID]
+	if !ok {
+		return http.StatusNotFound, nil, errors.New("withdrawal " + id + " doesn't exist")
+	}
+	if w.status == "included" && time.Since(w.prepared) >= m.ConfirmAfter {
+		w.status = "confirmed"
+	}
+	return http.StatusOK, api.Withdrawal{
+		RollupStatus:      w.status,
+		Sender:            w.user,
+		Status:            "success",
+		Timestamp:         w.prepared.Format(time.RFC3339),
+		Token:             orderToken(w.token, w.amount),
+		TransactionId:     withdrawalID,
+		WithdrawnToWallet: false,
+	}, nil
+}
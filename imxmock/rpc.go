@@ -0,0 +1,52 @@
+package imxmock
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// contractCode is returned for every address, imx.NewClient only checks the
+// registration and core contracts have some code deployed.
+const contractCode = "0x6080604052"
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// serveRPC answers the few Ethereum JSON-RPC calls the SDK makes while
+// connecting. L1 transactions (deposits, withdrawal completion) are not
+// supported.
+func (m *Mock) serveRPC(w http.ResponseWriter, r *http.Request) {
+	var req rpcRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, rpcResponse{JSONRPC: "2.0", Error: &rpcError{Code: -32700, Message: err.Error()}})
+		return
+	}
+
+	res := rpcResponse{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "eth_getCode":
+		res.Result = contractCode
+	case "eth_chainId":
+		res.Result = "0x" + ChainID.Text(16)
+	case "net_version":
+		res.Result = ChainID.String()
+	default:
+		res.Error = &rpcError{Code: -32601, Message: "method " + req.Method + " is not supported"}
+	}
+	writeJSON(w, http.StatusOK, res)
+}
@@ -0,0 +1,28 @@
+package jobs
+
+import (
+	"github.com/labstack/echo/v4"
+	"net/http"
+)
+
+// AdminList serves GET /jobs, optionally filtered with ?state=
+func AdminList(c echo.Context) error {
+	return c.JSON(http.StatusOK, List(c.QueryParam("state")))
+}
+
+// AdminGet serves GET /jobs/:id
+func AdminGet(c echo.Context) error {
+	job, ok := Get(c.Param("id"))
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "job " + c.Param("id") + " doesn't exist"})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// AdminRetry serves POST /jobs/:id/retry
+func AdminRetry(c echo.Context) error {
+	if err := Retry(c.Param("id")); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return AdminGet(c)
+}
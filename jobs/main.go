@@ -0,0 +1,348 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/alitto/pond"
+	"log"
+	"math/rand"
+	"nft-market/config"
+	"nft-market/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job states. A job starts out Pending, its handler moves it through the
+// intermediate states and eventually to Done, the queue moves it to Failed
+// once it ran out of attempts.
+const (
+	Pending    = "pending"
+	Polling    = "polling"
+	Finalizing = "finalizing"
+	Done       = "done"
+	Failed     = "failed"
+)
+
+// ErrRetryLater is returned by a handler when the job is waiting for
+// something outside of the market, e.g. a withdrawal to be confirmed. The job
+// is run again after a backoff delay without using up an attempt.
+var ErrRetryLater = errors.New("job is not ready yet")
+
+// Job is a unit of background work that survives restarts. It is persisted
+// after every step, together with the time it is due to run next.
+type Job struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	UserID    string          `json:"userid"`
+	Data      json.RawMessage `json:"data,omitempty"`
+	State     string          `json:"state"`
+	Attempts  int             `json:"attempts"`
+	Polls     int             `json:"polls"`
+	LastError string          `json:"last_error,omitempty"`
+	// FailedIn is the state a Failed job was in when it ran out of attempts
+	FailedIn string    `json:"failed_in,omitempty"`
+	NextRun  time.Time `json:"next_run"`
+	Created  time.Time `json:"created"`
+	Updated  time.Time `json:"updated"`
+}
+
+func (j *Job) finished() bool {
+	return j.State == Done || j.State == Failed
+}
+
+// Handler runs the next step of a job by looking at job.State and moving it
+// forward, setting it to Done when there is nothing left to do.
+type Handler func(job *Job) error
+
+// Queue runs jobs on a worker pool, keeping every job in storage so that
+// the ones not finished yet are picked up again on startup.
+type Queue struct {
+	cfg      config.Jobs
+	pool     *pond.WorkerPool
+	handlers map[string]Handler
+
+	mutex   sync.Mutex
+	jobs    map[string]*Job
+	running map[string]bool
+	wake    chan struct{}
+}
+
+func NewQueue(cfg config.Jobs, pool *pond.WorkerPool) *Queue {
+	return &Queue{
+		cfg:      cfg,
+		pool:     pool,
+		handlers: make(map[string]Handler),
+		jobs:     make(map[string]*Job),
+		running:  make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Register sets the handler of a job kind, it has to be called before Start.
+func (q *Queue) Register(kind string, handler Handler) {
+	q.handlers[kind] = handler
+}
+
+func (q *Queue) save(job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return storage.SetJob(job.ID, data)
+}
+
+// Load reads every job from storage, the unfinished ones will be run once
+// they are due.
+func (q *Queue) Load() error {
+	ids, err := storage.ListJobs()
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	for _, id := range ids {
+		data, err := storage.GetJob(id)
+		if err != nil {
+			return errors.New("failed to read job " + id)
+		}
+		job := new(Job)
+		if err = json.Unmarshal(data, job); err != nil {
+			return errors.New("invalid job " + id)
+		}
+		q.jobs[id] = job
+	}
+	return nil
+}
+
+// Enqueue stores a new job and schedules it right away. Job IDs are chosen by
+// the caller so that enqueueing the same work twice is a no-op.
+func (q *Queue) Enqueue(id string, kind string, userid string, data interface{}) error {
+	if _, ok := q.handlers[kind]; !ok {
+		return errors.New("unknown job kind " + kind)
+	}
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if _, ok := q.jobs[id]; ok {
+		return nil
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:      id,
+		Kind:    kind,
+		UserID:  userid,
+		Data:    raw,
+		State:   Pending,
+		NextRun: now,
+		Created: now,
+		Updated: now,
+	}
+	if err = q.save(job); err != nil {
+		return err
+	}
+	q.jobs[id] = job
+	q.notify()
+	return nil
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// backoff doubles RetryDelay n times, caps it at MaxRetryDelay and picks a
+// random delay in the upper half so that jobs scheduled together, e.g. on
+// startup, don't all hit ImmutableX at the same time.
+func (q *Queue) backoff(n int) time.Duration {
+	delay := time.Duration(q.cfg.RetryDelay)
+	for i := 0; i < n && delay < time.Duration(q.cfg.MaxRetryDelay); i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(q.cfg.MaxRetryDelay) {
+		delay = time.Duration(q.cfg.MaxRetryDelay)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+func (q *Queue) run(id string) {
+	q.mutex.Lock()
+	job := *q.jobs[id]
+	handler := q.handlers[job.Kind]
+	q.mutex.Unlock()
+
+	err := handler(&job)
+	now := time.Now()
+	job.Updated = now
+	switch {
+	case err == nil:
+		job.NextRun = now
+		job.LastError = ""
+	case errors.Is(err, ErrRetryLater):
+		job.NextRun = now.Add(q.backoff(job.Polls))
+		job.Polls++
+	default:
+		job.Attempts++
+		job.LastError = err.Error()
+		job.NextRun = now.Add(q.backoff(job.Attempts - 1))
+		if job.Attempts >= q.cfg.MaxAttempts {
+			job.FailedIn = job.State
+			job.State = Failed
+		}
+		log.Printf("job %v failed (attempt %v/%v): %v", job.ID, job.Attempts, q.cfg.MaxAttempts, err)
+	}
+
+	if err = q.save(&job); err != nil {
+		// keep going, the job is retried from its last saved state after a restart
+		log.Printf("failed to save job %v: %v", job.ID, err)
+	}
+
+	q.mutex.Lock()
+	*q.jobs[id] = job
+	delete(q.running, id)
+	q.mutex.Unlock()
+	q.notify()
+}
+
+// schedule submits the due jobs and returns how long until the next one.
+func (q *Queue) schedule() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	now := time.Now()
+	next := time.Duration(q.cfg.MaxRetryDelay)
+	for id, job := range q.jobs {
+		if job.finished() || q.running[id] {
+			continue
+		}
+		if _, ok := q.handlers[job.Kind]; !ok {
+			continue
+		}
+		if wait := job.NextRun.Sub(now); wait > 0 {
+			if wait < next {
+				next = wait
+			}
+			continue
+		}
+		id := id
+		// the mutex is held, so don't block on a full pool, the job is picked
+		// up again on the next round
+		if !q.pool.TrySubmit(func() { q.run(id) }) {
+			if time.Second < next {
+				next = time.Second
+			}
+			continue
+		}
+		q.running[id] = true
+	}
+	return next
+}
+
+// Start runs due jobs in the background until the process exits.
+func (q *Queue) Start() {
+	go func() {
+		timer := time.NewTimer(0)
+		for {
+			select {
+			case <-timer.C:
+			case <-q.wake:
+				if !timer.Stop() {
+					select {
+					case <-timer.C:
+					default:
+					}
+				}
+			}
+			timer.Reset(q.schedule())
+		}
+	}()
+}
+
+// List returns a copy of the jobs in the given state, or all jobs if state is
+// empty, oldest first.
+func (q *Queue) List(state string) []Job {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	list := make([]Job, 0, len(q.jobs))
+	for _, job := range q.jobs {
+		if state == "" || job.State == state {
+			list = append(list, *job)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	return list
+}
+
+func (q *Queue) Get(id string) (Job, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Retry gives a failed job a fresh set of attempts, continuing from the step
+// it failed in.
+func (q *Queue) Retry(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return errors.New("job " + id + " doesn't exist")
+	}
+	if job.State != Failed {
+		return errors.New("job " + id + " has not failed")
+	}
+
+	retry := *job
+	retry.State = retry.FailedIn
+	retry.FailedIn = ""
+	retry.Attempts = 0
+	retry.NextRun = time.Now()
+	retry.Updated = retry.NextRun
+	if err := q.save(&retry); err != nil {
+		return err
+	}
+	*job = retry
+	q.notify()
+	return nil
+}
+
+var queue *Queue
+
+// SetQueue selects the queue used by the package level functions.
+// It is expected to be called once on startup, before serving any requests.
+func SetQueue(q *Queue) {
+	queue = q
+}
+
+func Enqueue(id string, kind string, userid string, data interface{}) error {
+	return queue.Enqueue(id, kind, userid, data)
+}
+
+func List(state string) []Job {
+	return queue.List(state)
+}
+
+func Get(id string) (Job, bool) {
+	return queue.Get(id)
+}
+
+func Retry(id string) error {
+	return queue.Retry(id)
+}
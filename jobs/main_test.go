@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"errors"
+	"nft-market/config"
+	"nft-market/storage"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		maxDelay time.Duration
+		n        int
+		// the backoff is picked from the upper half of want
+		want time.Duration
+	}{
+		{"first", time.Second, time.Minute, 0, time.Second},
+		{"doubled", time.Second, time.Minute, 1, 2 * time.Second},
+		{"doubled again", time.Second, time.Minute, 5, 32 * time.Second},
+		{"capped", time.Second, time.Minute, 6, time.Minute},
+		{"no overflow", time.Second, time.Minute, 1000, time.Minute},
+		{"delay over max", 2 * time.Minute, time.Minute, 0, time.Minute},
+		{"no delay", 0, time.Minute, 3, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := NewQueue(config.Jobs{RetryDelay: config.Duration(test.delay), MaxRetryDelay: config.Duration(test.maxDelay)}, nil)
+			for i := 0; i < 100; i++ {
+				if got := q.backoff(test.n); got < test.want/2 || got > test.want {
+					t.Fatalf("got %v, want %v to %v", got, test.want/2, test.want)
+				}
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	cfg := config.Jobs{MaxAttempts: 3, RetryDelay: config.Duration(time.Second), MaxRetryDelay: config.Duration(time.Minute)}
+	errFailed := errors.New("failed")
+
+	tests := []struct {
+		name string
+		job  Job
+		err  error
+		want Job
+		// wait is the backoff NextRun is scheduled after, from its upper half
+		wait time.Duration
+	}{
+		{"step", Job{State: Pending}, nil, Job{State: Polling}, 0},
+		{"step clears error", Job{State: Pending, LastError: "failed"}, nil, Job{State: Polling}, 0},
+		{"first poll", Job{State: Polling}, ErrRetryLater, Job{State: Polling, Polls: 1}, time.Second},
+		{"later poll", Job{State: Polling, Polls: 3, Attempts: 1}, ErrRetryLater, Job{State: Polling, Polls: 4, Attempts: 1}, 8 * time.Second},
+		{"first failure", Job{State: Pending}, errFailed, Job{State: Pending, Attempts: 1, LastError: "failed"}, time.Second},
+		{"second failure", Job{State: Pending, Attempts: 1, Polls: 4}, errFailed, Job{State: Pending, Attempts: 2, Polls: 4, LastError: "failed"}, 2 * time.Second},
+		{"out of attempts", Job{State: Finalizing, Attempts: 2}, errFailed, Job{State: Failed, FailedIn: Finalizing, Attempts: 3, LastError: "failed"}, 4 * time.Second},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			storage.SetBackend(storage.NewFSBackend(t.TempDir() + "/"))
+			q := NewQueue(cfg, nil)
+			q.Register("test", func(job *Job) error {
+				if job.State == Pending && test.err == nil {
+					job.State = Polling
+				}
+				return test.err
+			})
+			job := test.job
+			job.ID = "job"
+			job.Kind = "test"
+			q.jobs[job.ID] = &job
+			q.running[job.ID] = true
+
+			before := time.Now()
+			q.run(job.ID)
+			after := time.Now()
+
+			got, _ := q.Get(job.ID)
+			if got.State != test.want.State || got.FailedIn != test.want.FailedIn || got.Attempts != test.want.Attempts || got.Polls != test.want.Polls || got.LastError != test.want.LastError {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+			if got.NextRun.Before(before.Add(test.wait/2)) || got.NextRun.After(after.Add(test.wait)) {
+				t.Errorf("next run in %v, want %v to %v", got.NextRun.Sub(before), test.wait/2, test.wait)
+			}
+			if q.running[job.ID] {
+				t.Error("job is still marked running")
+			}
+
+			// the job is saved as it is kept in memory
+			saved := NewQueue(cfg, nil)
+			if err := saved.Load(); err != nil {
+				t.Fatalf("failed to load jobs: %v", err)
+			}
+			if job, _ := saved.Get(job.ID); job.State != got.State || job.Attempts != got.Attempts || job.Polls != got.Polls || !job.NextRun.Equal(got.NextRun) {
+				t.Errorf("saved %+v, want %+v", job, got)
+			}
+		})
+	}
+}
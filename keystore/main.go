@@ -0,0 +1,246 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"github.com/immutable/imx-core-sdk-golang/imx/signers/ethereum"
+	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
+	"math/big"
+	"nft-market/storage"
+	"strings"
+)
+
+const MasterKeyEnv = "NFT_MARKET_MASTER_KEY"
+
+const envelopeVersion = 1
+
+// envelope is what gets persisted instead of a raw key: the key is encrypted
+// with a random data key, and only the data key is encrypted with the master
+// key, so rotating the master key only requires rewrapping data keys.
+type envelope struct {
+	Version    int    `json:"version"`
+	WrappedKey string `json:"wrapped_key"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+var masterKey []byte
+
+// Init sets the master key used to seal and open user keys. The key is an
+// AES-256 key given as 64 hex characters.
+func Init(hexKey string) error {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil || len(key) != 32 {
+		return errors.New("master key must be 32 bytes encoded as hex")
+	}
+	masterKey = key
+	return nil
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
+
+func aesSeal(key []byte, plaintext []byte, aad []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func aesOpen(key []byte, nonce []byte, ciphertext []byte, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid nonce")
+	}
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+// seal encrypts the key bound to the given label (user ID and key name), so
+// that a sealed key copied to another user or slot fails to open.
+func seal(label string, key []byte) ([]byte, error) {
+	if masterKey == nil {
+		return nil, errors.New("keystore is not initialized")
+	}
+
+	dataKey := make([]byte, 32)
+	defer zero(dataKey)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	wrapNonce, wrapped, err := aesSeal(masterKey, dataKey, []byte(label))
+	if err != nil {
+		return nil, err
+	}
+	nonce, ciphertext, err := aesSeal(dataKey, key, []byte(label))
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(envelope{
+		Version:    envelopeVersion,
+		WrappedKey: hex.EncodeToString(append(wrapNonce, wrapped...)),
+		Nonce:      hex.EncodeToString(nonce),
+		Ciphertext: hex.EncodeToString(ciphertext),
+	})
+}
+
+func open(label string, sealed []byte) ([]byte, error) {
+	if masterKey == nil {
+		return nil, errors.New("keystore is not initialized")
+	}
+
+	var env envelope
+	if err := json.Unmarshal(sealed, &env); err != nil || env.Version != envelopeVersion {
+		return nil, errors.New("key is not sealed")
+	}
+
+	wrapped, err := hex.DecodeString(env.WrappedKey)
+	if err != nil || len(wrapped) < 12 {
+		return nil, errors.New("invalid wrapped key")
+	}
+	nonce, err := hex.DecodeString(env.Nonce)
+	if err != nil {
+		return nil, errors.New("invalid nonce")
+	}
+	ciphertext, err := hex.DecodeString(env.Ciphertext)
+	if err != nil {
+		return nil, errors.New("invalid ciphertext")
+	}
+
+	dataKey, err := aesOpen(masterKey, wrapped[:12], wrapped[12:], []byte(label))
+	if err != nil {
+		return nil, errors.New("failed to unwrap data key")
+	}
+	defer zero(dataKey)
+
+	key, err := aesOpen(dataKey, nonce, ciphertext, []byte(label))
+	if err != nil {
+		return nil, errors.New("failed to decrypt key")
+	}
+	return key, nil
+}
+
+func isSealed(value []byte) bool {
+	var env envelope
+	return json.Unmarshal(value, &env) == nil && env.Version == envelopeVersion
+}
+
+// keyLabel tells whether label is that of a user key, which only the
+// signers of this package open.
+func keyLabel(label string) bool {
+	return strings.HasSuffix(label, "/private_key") || strings.HasSuffix(label, "/stark_private_key")
+}
+
+// SealSecret seals a secret that isn't a user key, e.g. of an API key, bound
+// to label. The caller stores the result.
+func SealSecret(label string, secret []byte) ([]byte, error) {
+	if keyLabel(label) {
+		return nil, errors.New("label is reserved for user keys")
+	}
+	return seal(label, secret)
+}
+
+// OpenSecret decrypts a secret sealed by SealSecret with the same label. It
+// refuses the labels of user keys, so that whoever may open secrets, e.g.
+// over the signing daemon's socket, still can't read keys.
+func OpenSecret(label string, sealed []byte) ([]byte, error) {
+	if keyLabel(label) {
+		return nil, errors.New("label is reserved for user keys")
+	}
+	return open(label, sealed)
+}
+
+// SetUserPrivateKey seals the hex encoded Ethereum private key of the user.
+func SetUserPrivateKey(userid string, key []byte) error {
+	sealed, err := seal(userid+"/private_key", key)
+	if err != nil {
+		return err
+	}
+	return storage.SetUserSealedPrivateKey(userid, sealed)
+}
+
+// SetUserStarkPrivateKey seals the hex encoded Stark private key of the user.
+func SetUserStarkPrivateKey(userid string, key []byte) error {
+	sealed, err := seal(userid+"/stark_private_key", key)
+	if err != nil {
+		return err
+	}
+	return storage.SetUserSealedStarkPrivateKey(userid, sealed)
+}
+
+// L1Signer decrypts the user's Ethereum key and returns a signer holding it,
+// the decrypted key never leaves this package.
+func L1Signer(userid string, chainID *big.Int) (imx.L1Signer, error) {
+	sealed, err := storage.GetUserSealedPrivateKey(userid)
+	if err != nil {
+		return nil, errors.New("failed to get user private key")
+	}
+	key, err := open(userid+"/private_key", sealed)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	return ethereum.NewSigner(string(key), chainID)
+}
+
+// L2Signer decrypts the user's Stark key and returns a signer holding it.
+func L2Signer(userid string) (imx.L2Signer, error) {
+	sealed, err := storage.GetUserSealedStarkPrivateKey(userid)
+	if err != nil {
+		return nil, errors.New("failed to get user stark private key")
+	}
+	key, err := open(userid+"/stark_private_key", sealed)
+	if err != nil {
+		return nil, err
+	}
+	defer zero(key)
+
+	starkPrivateKey, ok := new(big.Int).SetString(string(key), 16)
+	if !ok {
+		return nil, errors.New("invalid stark private key")
+	}
+	return stark.NewSigner(starkPrivateKey)
+}
+
+// MigrateUser seals user keys that were stored in plaintext by older versions.
+func MigrateUser(userid string) error {
+	if key, err := storage.GetUserSealedPrivateKey(userid); err == nil && !isSealed(key) {
+		if err = SetUserPrivateKey(userid, key); err != nil {
+			return err
+		}
+		zero(key)
+	}
+	if key, err := storage.GetUserSealedStarkPrivateKey(userid); err == nil && !isSealed(key) {
+		if err = SetUserStarkPrivateKey(userid, key); err != nil {
+			return err
+		}
+		zero(key)
+	}
+	return nil
+}
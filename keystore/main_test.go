@@ -0,0 +1,161 @@
+package keystore
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const (
+	testMasterKey  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	otherMasterKey = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func TestInit(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		ok   bool
+	}{
+		{"valid", testMasterKey, true},
+		{"upper case", strings.ToUpper(testMasterKey), true},
+		{"short", testMasterKey[:62], false},
+		{"long", testMasterKey + "20", false},
+		{"not hex", strings.Repeat("zz", 32), false},
+		{"empty", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Init(test.key); (err == nil) != test.ok {
+				t.Errorf("got error %v", err)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	if err := Init(testMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	key := []byte("4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318")
+	sealed, err := seal("user/private_key", key)
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	if bytes.Contains(sealed, key) {
+		t.Fatal("sealed key contains the plaintext")
+	}
+	if !isSealed(sealed) || isSealed(key) {
+		t.Fatal("isSealed can't tell sealed from plaintext keys")
+	}
+
+	// every seal uses a new data key and nonces
+	again, _ := seal("user/private_key", key)
+	if bytes.Equal(sealed, again) {
+		t.Error("sealing twice gave the same envelope")
+	}
+
+	// edit changes the envelope before it is opened
+	edit := func(change func(env *envelope)) []byte {
+		var env envelope
+		if err := json.Unmarshal(sealed, &env); err != nil {
+			t.Fatal(err)
+		}
+		change(&env)
+		value, _ := json.Marshal(env)
+		return value
+	}
+	// flip changes the last hex digit of value
+	flip := func(value string) string {
+		last := "0"
+		if strings.HasSuffix(value, "0") {
+			last = "1"
+		}
+		return value[:len(value)-1] + last
+	}
+
+	tests := []struct {
+		name      string
+		masterKey string
+		label     string
+		sealed    []byte
+		err       string
+	}{
+		{"valid", testMasterKey, "user/private_key", sealed, ""},
+		{"other label", testMasterKey, "user/stark_private_key", sealed, "failed to unwrap data key"},
+		{"other user", testMasterKey, "other/private_key", sealed, "failed to unwrap data key"},
+		{"other master key", otherMasterKey, "user/private_key", sealed, "failed to unwrap data key"},
+		{"plaintext", testMasterKey, "user/private_key", key, "key is not sealed"},
+		{"version", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Version = 2 }), "key is not sealed"},
+		{"wrapped key", testMasterKey, "user/private_key", edit(func(env *envelope) { env.WrappedKey = flip(env.WrappedKey) }), "failed to unwrap data key"},
+		{"short wrapped key", testMasterKey, "user/private_key", edit(func(env *envelope) { env.WrappedKey = env.WrappedKey[:22] }), "invalid wrapped key"},
+		{"wrapped key hex", testMasterKey, "user/private_key", edit(func(env *envelope) { env.WrappedKey = "x" + env.WrappedKey }), "invalid wrapped key"},
+		{"nonce", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Nonce = flip(env.Nonce) }), "failed to decrypt key"},
+		{"nonce size", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Nonce += "00" }), "failed to decrypt key"},
+		{"nonce hex", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Nonce = "x" }), "invalid nonce"},
+		{"ciphertext", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Ciphertext = flip(env.Ciphertext) }), "failed to decrypt key"},
+		{"ciphertext hex", testMasterKey, "user/private_key", edit(func(env *envelope) { env.Ciphertext = "x" }), "invalid ciphertext"},
+		{"swapped data key", testMasterKey, "user/private_key", edit(func(env *envelope) {
+			var other envelope
+			_ = json.Unmarshal(again, &other)
+			env.WrappedKey = other.WrappedKey
+		}), "failed to decrypt key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := Init(test.masterKey); err != nil {
+				t.Fatal(err)
+			}
+			got, err := open(test.label, test.sealed)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if !bytes.Equal(got, key) {
+				t.Errorf("got %s, want %s", got, key)
+			}
+		})
+	}
+}
+
+func TestSecret(t *testing.T) {
+	if err := Init(testMasterKey); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealSecret("apikey/0123456789abcdef", []byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	if got, err := OpenSecret("apikey/0123456789abcdef", sealed); err != nil || string(got) != "secret" {
+		t.Errorf("got %s, %v, want secret", got, err)
+	}
+
+	// user keys can't be sealed or opened as secrets
+	key, _ := seal("user/private_key", []byte("key"))
+	for _, label := range []string{"user/private_key", "user/stark_private_key"} {
+		if _, err = SealSecret(label, []byte("key")); err == nil || err.Error() != "label is reserved for user keys" {
+			t.Errorf("sealing %v got error %v", label, err)
+		}
+		if _, err = OpenSecret(label, key); err == nil || err.Error() != "label is reserved for user keys" {
+			t.Errorf("opening %v got error %v", label, err)
+		}
+	}
+}
+
+func TestNotInitialized(t *testing.T) {
+	masterKey = nil
+	if _, err := seal("user/private_key", []byte("key")); err == nil {
+		t.Error("sealed without master key")
+	}
+	if _, err := open("user/private_key", []byte("{}")); err == nil {
+		t.Error("opened without master key")
+	}
+}
@@ -1,18 +1,120 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"github.com/alitto/pond"
 	"github.com/labstack/echo/v4"
 	"log"
+	"nft-market/auth"
+	"nft-market/config"
+	"nft-market/currency"
+	"nft-market/jobs"
+	"nft-market/keystore"
 	"nft-market/nftcollection"
+	"nft-market/nftimx"
 	"nft-market/nfttoken"
 	"nft-market/nftuser"
+	"nft-market/signer"
+	"nft-market/siwe"
 	"nft-market/storage"
+	"nft-market/wallet"
 	"os"
 )
 
+// legacyMigration names the migration of what older versions left behind,
+// it runs once on the first start and is retried until it succeeds
+const legacyMigration = "legacy"
+
+// migrateLegacy seals keys left in plaintext by older versions, moves the
+// collections they wrote below legacyPath and queues the withdrawals they
+// left in progress without a job.
+func migrateLegacy(legacyPath string) error {
+	users, err := storage.ListUsers()
+	if err != nil {
+		return errors.New("failed to read storage")
+	}
+
+	failed := false
+	for _, userid := range users {
+		if err := signer.MigrateKeys(userid); err != nil {
+			return fmt.Errorf("failed to seal keys of user %v: %v", userid, err)
+		}
+		if legacyPath != "" {
+			if _, err := storage.MigrateUserCollections(legacyPath, userid); err != nil {
+				log.Printf("failed to migrate collections of user %v: %v", userid, err)
+				failed = true
+			}
+		}
+		withdrawal, err := storage.MigrateUserWithdraw(userid)
+		if err == nil && withdrawal != nil {
+			err = nftuser.UserWithdrawFinalize(userid, withdrawal.ID)
+		}
+		if err != nil {
+			log.Printf("failed to queue withdraw of user %v: %v", userid, err)
+			failed = true
+		}
+	}
+	if failed {
+		log.Printf("storage migration incomplete, retrying on next start")
+		return nil
+	}
+	log.Printf("migrated storage of %v users", len(users))
+	return storage.SetMigrated(legacyMigration)
+}
+
 func main() {
-	nftuser.WorkerPool = pond.New(100, 1000)
+	cfg, err := config.Load(os.Args[0], os.Args[1:])
+	if err != nil {
+		log.Panicf("failed to load configuration: %v", err)
+		return
+	}
+
+	closeStorage, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Panicf("failed to open storage: %v", err)
+		return
+	}
+	defer closeStorage()
+
+	switch cfg.Signer.Provider {
+	case "keystore":
+		if err = keystore.Init(os.Getenv(keystore.MasterKeyEnv)); err != nil {
+			log.Panicf("failed to initialize keystore from %v: %v", keystore.MasterKeyEnv, err)
+			return
+		}
+		if err = wallet.Init(os.Getenv(wallet.SeedEnv)); err != nil {
+			log.Panicf("failed to initialize wallet seed from %v: %v", wallet.SeedEnv, err)
+			return
+		}
+		signer.SetProvider(signer.NewKeystoreProvider(nftimx.ChainID(cfg.Marketplace)))
+	case "daemon":
+		// signerd holds the master key and the wallet seed, and creates the
+		// keys of new users
+		signer.SetProvider(signer.NewDaemonProvider(cfg.Signer.Socket))
+	}
+
+	currency.Configure(cfg.Marketplace.Currencies)
+	auth.Configure(cfg.Auth)
+	siwe.Configure(cfg.SIWE, nftimx.ChainID(cfg.Marketplace))
+
+	switch cfg.Marketplace.Provider {
+	case "imx":
+		m, err := nftimx.NewIMXMarketplace(cfg.Marketplace)
+		if err != nil {
+			log.Panicf("failed to create marketplace: %v", err)
+			return
+		}
+		nftimx.SetMarketplace(m)
+	case "fake":
+		log.Printf("WARNING: using the fake marketplace, mints, sales and transfers only live in memory and are lost on restart")
+		nftimx.SetMarketplace(nftimx.NewFakeMarketplace())
+	}
+
+	queue := jobs.NewQueue(cfg.Jobs, pond.New(cfg.Workers.Max, cfg.Workers.Capacity))
+	queue.Register(nftuser.WithdrawJob, nftuser.WithdrawFinalizeJob)
+	jobs.SetQueue(queue)
+
 	if !storage.StorageExists() {
 		err := storage.StorageCreate()
 		if err != nil {
@@ -20,26 +122,47 @@ func main() {
 			return
 		}
 	}
-	entries, err := os.ReadDir(storage.Prefix + storage.UserDir)
-	if err != nil {
-		log.Panic("failed to read storage")
+	if err = queue.Load(); err != nil {
+		log.Panicf("failed to load jobs: %v", err)
 		return
 	}
-
-	// retrieve list of withdrawals in progress and run finalize
-	for _, user := range entries {
-		userid := user.Name()
-		if !user.IsDir() {
-			continue
+	if !storage.Migrated(legacyMigration) {
+		if err = migrateLegacy(cfg.Storage.LegacyPath); err != nil {
+			log.Panicf("failed to migrate storage: %v", err)
+			return
 		}
-		if storage.UserWithdrawInProgress(userid) {
-			nftuser.UserWithdrawFinalize(userid)
+	}
+	queue.Start()
+
+	if cfg.AdminListen != "" {
+		if cfg.AdminToken == "" {
+			log.Printf("WARNING: admin API on %v has no admin token, anyone on this host can manage jobs and API keys", cfg.AdminListen)
 		}
+		admin := echo.New()
+		admin.Use(auth.AdminMiddleware(cfg.AdminToken))
+		admin.GET("/jobs", jobs.AdminList)
+		admin.GET("/jobs/:id", jobs.AdminGet)
+		admin.POST("/jobs/:id/retry", jobs.AdminRetry)
+		admin.GET("/users/:id/keys", auth.AdminList)
+		admin.POST("/users/:id/keys", auth.AdminCreate)
+		admin.POST("/users/:id/keys/:key/revoke", auth.AdminRevoke)
+		go func() {
+			admin.Logger.Fatal(admin.Start(cfg.AdminListen))
+		}()
 	}
 
 	e := echo.New()
-	e.POST("/user", nftuser.User)
-	e.POST("/collection", nftcollection.Collection)
-	e.POST("/token", nfttoken.Token)
-	e.Logger.Fatal(e.Start(":8080"))
+	e.POST("/register", nftuser.Register)
+	if siwe.Enabled() {
+		e.POST("/login/nonce", nftuser.LoginNonce)
+		e.POST("/login", nftuser.Login)
+	}
+	e.POST("/user", nftuser.User, auth.Middleware(auth.ScopeUser))
+	e.POST("/keys", nftuser.Keys, auth.Middleware(auth.ScopeKeys))
+	e.POST("/collection", nftcollection.Collection, auth.Middleware(auth.ScopeCollection))
+	e.POST("/token", nfttoken.Token, auth.Middleware(auth.ScopeToken))
+	if cfg.TLS.CertFile != "" {
+		e.Logger.Fatal(e.StartTLS(cfg.Listen, cfg.TLS.CertFile, cfg.TLS.KeyFile))
+	}
+	e.Logger.Fatal(e.Start(cfg.Listen))
 }
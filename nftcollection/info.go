@@ -0,0 +1,104 @@
+package nftcollection
+
+import (
+	"math/big"
+	"nft-market/currency"
+	"nft-market/storage"
+	"nft-market/validation"
+	"sort"
+)
+
+type collectionInfoRequest struct {
+	ID string `json:"id"`
+}
+
+type collectionTokenInfo struct {
+	ID       string `json:"id"`
+	Metadata string `json:"metadata"`
+	Owner    string `json:"owner,omitempty"`
+	Minted   bool   `json:"minted"`
+	MintID   string `json:"mint_id,omitempty"`
+	// SellingID and the price are set while the token is on sale, Price is
+	// in base units of the currency, PriceDecimal in whole tokens
+	SellingID    string `json:"selling_id,omitempty"`
+	Currency     string `json:"currency,omitempty"`
+	Price        string `json:"price,omitempty"`
+	PriceDecimal string `json:"price_decimal,omitempty"`
+}
+
+func verifyCollectionInfoRequest(req *collectionInfoRequest) error {
+	var v validation.Validator
+	if v.Required("id", req.ID) {
+		v.Check("id", validation.CollectionID(req.ID))
+	}
+	return v.Err()
+}
+
+func collectionTokenInfoFrom(tokenid string) collectionTokenInfo {
+	info := collectionTokenInfo{ID: tokenid}
+	if metadata, err := storage.GetTokenMetadata(tokenid); err == nil {
+		info.Metadata = string(metadata)
+	}
+	if owner, err := storage.GetTokenOwner(tokenid); err == nil {
+		info.Owner = string(owner)
+	}
+	if mintID, err := storage.GetTokenMintedID(tokenid); err == nil {
+		info.Minted = true
+		info.MintID = string(mintID)
+	}
+	if sellingID, err := storage.GetTokenSellingID(tokenid); err == nil {
+		info.SellingID = string(sellingID)
+		// tokens put on sale by older versions have no currency and are in ETH
+		key, _ := storage.GetTokenSellingCurrency(tokenid)
+		cur, err := currency.Lookup(string(key))
+		if err == nil {
+			info.Currency = cur.Symbol
+		}
+		if price, err := storage.GetTokenSellingPrice(tokenid); err == nil {
+			info.Price = string(price)
+			if cur != nil {
+				info.PriceDecimal = cur.FormatString(info.Price)
+			}
+		}
+	}
+	return info
+}
+
+// tokenIDLess orders token IDs numerically, they are allocated from a counter
+func tokenIDLess(a string, b string) bool {
+	x, okx := new(big.Int).SetString(a, 16)
+	y, oky := new(big.Int).SetString(b, 16)
+	if !okx || !oky {
+		return a < b
+	}
+	return x.Cmp(y) < 0
+}
+
+func collectionInfo(userid string, req *collectionInfoRequest, res *collectionInfoResponse) error {
+	if err := verifyCollectionInfoRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	collection, err := storage.GetUserCollection(userid, req.ID)
+	if err != nil {
+		res.Error = "collection " + req.ID + " doesn't exist"
+		return err
+	}
+	tokens, err := storage.ListCollectionTokens(req.ID)
+	if err != nil {
+		res.Error = "failed to list collection tokens"
+		return err
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokenIDLess(tokens[i], tokens[j])
+	})
+
+	*res = collectionInfoFrom(collection)
+	res.Tokens = make([]collectionTokenInfo, 0, len(tokens))
+	for _, tokenid := range tokens {
+		res.Tokens = append(res.Tokens, collectionTokenInfoFrom(tokenid))
+	}
+	return nil
+}
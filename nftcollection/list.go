@@ -0,0 +1,196 @@
+package nftcollection
+
+import (
+	"encoding/json"
+	"errors"
+	"nft-market/storage"
+	"nft-market/validation"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	collectionListDefaultLimit = 50
+	collectionListMaxLimit     = 500
+)
+
+// Without Limit and Cursor every matching collection is listed as a bare
+// array, as older versions did. With either, pages of at most Limit
+// collections, collectionListDefaultLimit by default, are listed in an
+// object with the cursor of the next page.
+type collectionListRequest struct {
+	// Matching filters by a case insensitive substring of the name or
+	// description, or by a glob pattern if it contains any of *?[
+	Matching string `json:"matching"`
+	// Sort is one of "created" (default), "name" or "tokens"
+	Sort       string `json:"sort"`
+	Descending bool   `json:"descending"`
+	Limit      int    `json:"limit"`
+	// Cursor is the cursor returned with the previous page
+	Cursor string `json:"cursor"`
+}
+
+type collectionListResponse struct {
+	Collections []collectionInfoResponse `json:"collections"`
+	// Cursor fetches the next page, empty on the last one
+	Cursor string `json:"cursor,omitempty"`
+	Error  string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+
+	// array marshals the collections as a bare array, see
+	// collectionListRequest
+	array bool
+}
+
+// MarshalJSON keeps the bare array of older versions for requests without
+// paging, errors are always reported in an object.
+func (res collectionListResponse) MarshalJSON() ([]byte, error) {
+	if res.array && res.Error == "" {
+		return json.Marshal(res.Collections)
+	}
+	type page collectionListResponse
+	return json.Marshal(page(res))
+}
+
+func verifyCollectionListRequest(req *collectionListRequest) error {
+	var v validation.Validator
+	switch req.Sort {
+	case "", "created", "name", "tokens":
+	default:
+		v.Fail("sort", "is not one of created, name or tokens")
+	}
+	if req.Limit < 0 || req.Limit > collectionListMaxLimit {
+		v.Fail("limit", "is out of range")
+	}
+	if _, err := path.Match(req.Matching, ""); err != nil {
+		v.Fail("matching", "is not a valid pattern")
+	}
+	return v.Err()
+}
+
+func collectionMatches(collection *storage.Collection, matching string) bool {
+	if matching == "" {
+		return true
+	}
+	matching = strings.ToLower(matching)
+	name := strings.ToLower(collection.Name)
+	description := strings.ToLower(collection.Description)
+	if strings.ContainsAny(matching, "*?[") {
+		nameMatch, _ := path.Match(matching, name)
+		descriptionMatch, _ := path.Match(matching, description)
+		return nameMatch || descriptionMatch
+	}
+	return strings.Contains(name, matching) || strings.Contains(description, matching)
+}
+
+// collectionLess orders by the requested key, ties are broken by ID so that
+// the order, and with it the cursor, is stable
+func collectionLess(a *storage.Collection, b *storage.Collection, key string) bool {
+	switch key {
+	case "name":
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+	case "tokens":
+		if a.Tokens != b.Tokens {
+			return a.Tokens < b.Tokens
+		}
+	default:
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+	}
+	return a.ID < b.ID
+}
+
+func collectionInfoFrom(collection *storage.Collection) collectionInfoResponse {
+	info := collectionInfoResponse{
+		ID:              collection.ID,
+		ContractAddress: collection.ContractAddress,
+		Name:            collection.Name,
+		Description:     collection.Description,
+		TokenCount:      collection.Tokens,
+		Version:         collection.Version,
+		ProjectID:       collection.ProjectID,
+		IconURL:         collection.IconURL,
+		ImageURL:        collection.ImageURL,
+		MetadataAPIURL:  collection.MetadataAPIURL,
+		MetadataSchema:  collection.MetadataSchema,
+	}
+	if !collection.Created.IsZero() {
+		info.Created = collection.Created.UTC().Format(time.RFC3339)
+	}
+	return info
+}
+
+func collectionList(userid string, req *collectionListRequest, res *collectionListResponse) error {
+	if err := verifyCollectionListRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	collections, err := storage.ListUserCollections(userid)
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+	return collectionPage(collections, req, res)
+}
+
+// collectionPage filters and sorts collections and fills res with the page
+// following req.Cursor
+func collectionPage(collections []storage.Collection, req *collectionListRequest, res *collectionListResponse) error {
+	var matching []storage.Collection
+	for i := range collections {
+		if collectionMatches(&collections[i], req.Matching) {
+			matching = append(matching, collections[i])
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool {
+		if req.Descending {
+			return collectionLess(&matching[j], &matching[i], req.Sort)
+		}
+		return collectionLess(&matching[i], &matching[j], req.Sort)
+	})
+
+	start := 0
+	if req.Cursor != "" {
+		start = -1
+		for i := range matching {
+			if matching[i].ID == req.Cursor {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			res.Error = "invalid cursor"
+			return errors.New(res.Error)
+		}
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = collectionListDefaultLimit
+	}
+	end := start + limit
+	if req.Limit == 0 && req.Cursor == "" {
+		res.array = true
+		end = len(matching)
+	}
+	if end > len(matching) {
+		end = len(matching)
+	}
+
+	res.Collections = make([]collectionInfoResponse, 0, end-start)
+	for i := start; i < end; i++ {
+		res.Collections = append(res.Collections, collectionInfoFrom(&matching[i]))
+	}
+	if end < len(matching) {
+		res.Cursor = matching[end-1].ID
+	}
+	return nil
+}
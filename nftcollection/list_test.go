@@ -0,0 +1,117 @@
+package nftcollection
+
+import (
+	"encoding/json"
+	"nft-market/storage"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCollectionPage(t *testing.T) {
+	day := time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC)
+	// a and c share a name, b and c a creation time, a, b and d a token count
+	collections := []storage.Collection{
+		{ID: "d", Name: "Zebras", Description: "stripes", Created: day.Add(3 * time.Hour), Tokens: 2},
+		{ID: "c", Name: "Apes", Description: "bored", Created: day.Add(time.Hour), Tokens: 5},
+		{ID: "a", Name: "Apes", Description: "cool", Created: day, Tokens: 2},
+		{ID: "b", Name: "Birds", Description: "tweets", Created: day.Add(time.Hour), Tokens: 2},
+	}
+
+	tests := []struct {
+		name string
+		req  collectionListRequest
+		// pages are the collection IDs of every page, fetched by following
+		// the cursor until it is empty
+		pages [][]string
+	}{
+		{"created", collectionListRequest{}, [][]string{{"a", "b", "c", "d"}}},
+		{"created pages", collectionListRequest{Limit: 1}, [][]string{{"a"}, {"b"}, {"c"}, {"d"}}},
+		{"created descending", collectionListRequest{Descending: true, Limit: 3}, [][]string{{"d", "c", "b"}, {"a"}}},
+		{"name ties", collectionListRequest{Sort: "name", Limit: 1}, [][]string{{"a"}, {"c"}, {"b"}, {"d"}}},
+		{"name descending", collectionListRequest{Sort: "name", Descending: true, Limit: 2}, [][]string{{"d", "b"}, {"c", "a"}}},
+		{"tokens ties", collectionListRequest{Sort: "tokens", Limit: 2}, [][]string{{"a", "b"}, {"d", "c"}}},
+		{"tokens descending", collectionListRequest{Sort: "tokens", Descending: true, Limit: 2}, [][]string{{"c", "d"}, {"b", "a"}}},
+		{"exact last page", collectionListRequest{Limit: 2}, [][]string{{"a", "b"}, {"c", "d"}}},
+		{"matching", collectionListRequest{Matching: "APES", Limit: 1}, [][]string{{"a"}, {"c"}}},
+		{"matching pattern", collectionListRequest{Matching: "*s", Sort: "name", Descending: true}, [][]string{{"d", "b", "c", "a"}}},
+		{"matching nothing", collectionListRequest{Matching: "cats"}, [][]string{{}}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := test.req
+			var pages [][]string
+			for {
+				var res collectionListResponse
+				if err := collectionPage(collections, &req, &res); err != nil {
+					t.Fatalf("page %v failed: %v", len(pages)+1, err)
+				}
+				ids := []string{}
+				for _, collection := range res.Collections {
+					ids = append(ids, collection.ID)
+				}
+				pages = append(pages, ids)
+				if res.Cursor == "" || len(pages) > len(collections) {
+					break
+				}
+				req.Cursor = res.Cursor
+			}
+			if !reflect.DeepEqual(pages, test.pages) {
+				t.Errorf("got pages %v, want %v", pages, test.pages)
+			}
+		})
+	}
+}
+
+func TestCollectionPageInvalidCursor(t *testing.T) {
+	collections := []storage.Collection{{ID: "a", Name: "Apes"}, {ID: "b", Name: "Birds"}}
+
+	tests := []struct {
+		name string
+		req  collectionListRequest
+	}{
+		{"unknown", collectionListRequest{Cursor: "z"}},
+		{"filtered out", collectionListRequest{Cursor: "b", Matching: "apes"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res collectionListResponse
+			if err := collectionPage(collections, &test.req, &res); err == nil || res.Error != "invalid cursor" {
+				t.Errorf("got error %v, want invalid cursor", err)
+			}
+		})
+	}
+}
+
+func TestCollectionListJSON(t *testing.T) {
+	collections := []storage.Collection{{ID: "a", Name: "Apes"}, {ID: "b", Name: "Birds"}}
+
+	tests := []struct {
+		name string
+		req  collectionListRequest
+		want string
+	}{
+		{"no paging", collectionListRequest{}, `[{"id":"a","name":"Apes"},{"id":"b","name":"Birds"}]`},
+		{"no paging nothing", collectionListRequest{Matching: "cats"}, `[]`},
+		{"limit", collectionListRequest{Limit: 1}, `{"collections":[{"id":"a","name":"Apes"}],"cursor":"a"}`},
+		{"cursor", collectionListRequest{Cursor: "a"}, `{"collections":[{"id":"b","name":"Birds"}]}`},
+		{"invalid cursor", collectionListRequest{Cursor: "z"}, `{"collections":null,"error":"invalid cursor"}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res collectionListResponse
+			_ = collectionPage(collections, &test.req, &res)
+			// the handler marshals a pointer to the response
+			got, err := json.Marshal(&res)
+			if err != nil {
+				t.Fatalf("failed to marshal: %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
@@ -1,149 +1,204 @@
 package nftcollection
 
 import (
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx"
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
+	"nft-market/auth"
+	"nft-market/nftimx"
 	"nft-market/nftuser"
+	"nft-market/signer"
 	"nft-market/storage"
-	"os"
+	"nft-market/validation"
+	"strings"
 )
 
+// collectionProjectRequest describes the ImmutableX project to create for
+// users who don't have one yet
+type collectionProjectRequest struct {
+	Name         string `json:"name"`
+	CompanyName  string `json:"company_name"`
+	ContactEmail string `json:"contact_email"`
+}
+
 type collectionCreateRequest struct {
 	ContractAddress string `json:"contract_address"`
 	Name            string `json:"name"`
 	Description     string `json:"description"`
-}
-
-type collectionUpdateRequest struct {
-	ID          string `json:"id"`
-	Name        string `json:"name"`
-	Description string `json:"description"`
-}
-
-type collectionListRequest struct {
-	Matching string `json:"matching"`
-}
-
-type collectionInfoRequest struct {
-	ID string `json:"id"`
+	IconURL         string `json:"icon_url"`
+	ImageURL        string `json:"image_url"`
+	MetadataAPIURL  string `json:"metadata_api_url"`
+	// ProjectID is the ImmutableX project to create the collection in,
+	// defaults to the user's project, created from Project if there is none
+	ProjectID      int32                     `json:"project_id"`
+	Project        *collectionProjectRequest `json:"project,omitempty"`
+	MetadataSchema []storage.MetadataField   `json:"metadata_schema,omitempty"`
 }
 
 type collectionRequest struct {
-	UserID string                   `json:"userid"`
-	Create *collectionCreateRequest `json:"create,omitempty"`
-	Update *collectionUpdateRequest `json:"update,omitempty"`
-	List   *collectionListRequest   `json:"list,omitempty"`
-	Info   *collectionInfoRequest   `json:"info,omitempty"`
+	UserID  string                    `json:"userid"`
+	Create  *collectionCreateRequest  `json:"create,omitempty"`
+	Update  *collectionUpdateRequest  `json:"update,omitempty"`
+	List    *collectionListRequest    `json:"list,omitempty"`
+	Info    *collectionInfoRequest    `json:"info,omitempty"`
+	History *collectionHistoryRequest `json:"history,omitempty"`
 }
 
 type collectionCreateResponse struct {
-	ID    string `json:"id,omitempty"`
-	Error string `json:"error,omitempty"`
-}
-
-type collectionUpdateResponse struct {
-	Error string `json:"error,omitempty"`
-}
-
-type collectionTokenInfo struct {
-	ID       string `json:"id"`
-	Metadata string `json:"metadata"`
+	ID        string `json:"id,omitempty"`
+	ProjectID int32  `json:"project_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
 type collectionInfoResponse struct {
-	ID          string                `json:"id,omitempty"`
-	Name        string                `json:"name,omitempty"`
-	Description string                `json:"description,omitempty"`
-	Tokens      []collectionTokenInfo `json:"tokens,omitempty"`
-	Error       string                `json:"error,omitempty"`
+	ID              string                  `json:"id,omitempty"`
+	ContractAddress string                  `json:"contract_address,omitempty"`
+	Name            string                  `json:"name,omitempty"`
+	Description     string                  `json:"description,omitempty"`
+	Created         string                  `json:"created,omitempty"`
+	TokenCount      int                     `json:"token_count,omitempty"`
+	Version         int                     `json:"version,omitempty"`
+	ProjectID       int32                   `json:"project_id,omitempty"`
+	IconURL         string                  `json:"icon_url,omitempty"`
+	ImageURL        string                  `json:"image_url,omitempty"`
+	MetadataAPIURL  string                  `json:"metadata_api_url,omitempty"`
+	MetadataSchema  []storage.MetadataField `json:"metadata_schema,omitempty"`
+	Tokens          []collectionTokenInfo   `json:"tokens,omitempty"`
+	Error           string                  `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
 type collectionResponse struct {
-	Create *collectionCreateResponse `json:"create,omitempty"`
-	Update *collectionUpdateResponse `json:"update,omitempty"`
-	List   []collectionInfoResponse  `json:"list,omitempty"`
-	Info   *collectionInfoResponse   `json:"info,omitempty"`
+	Create  *collectionCreateResponse  `json:"create,omitempty"`
+	Update  *collectionUpdateResponse  `json:"update,omitempty"`
+	List    *collectionListResponse    `json:"list,omitempty"`
+	Info    *collectionInfoResponse    `json:"info,omitempty"`
+	History *collectionHistoryResponse `json:"history,omitempty"`
 }
 
 func verifyCollectionCreateRequest(req *collectionCreateRequest) error {
-	// TODO: verify formatting
-	if req.ContractAddress == "" || req.Name == "" || req.Description == "" {
-		return errors.New("collection contract address, name or description missing")
+	var v validation.Validator
+	if v.Required("contract_address", req.ContractAddress) {
+		v.Check("contract_address", validation.Address(req.ContractAddress))
 	}
-	return nil
+	v.Required("name", req.Name)
+	v.Required("description", req.Description)
+	if req.ProjectID < 0 {
+		v.Fail("project_id", "is not a project ID")
+	}
+	if req.Project != nil {
+		v.Required("project.name", req.Project.Name)
+		v.Required("project.company_name", req.Project.CompanyName)
+		if v.Required("project.contact_email", req.Project.ContactEmail) {
+			v.Check("project.contact_email", validation.Email(req.Project.ContactEmail))
+		}
+	}
+	v.Check("metadata_schema", verifyMetadataSchema(nil, req.MetadataSchema))
+	return v.Err()
+}
+
+// collectionProject returns the ImmutableX project to create the collection
+// in, creating the user's project if needed
+func collectionProject(userid string, l1signer imx.L1Signer, req *collectionCreateRequest) (int32, error) {
+	projectID, err := storage.GetUserProjectID(userid)
+	if err != nil {
+		return 0, errors.New("failed to get user project")
+	}
+	if req.ProjectID != 0 {
+		if projectID == 0 {
+			err = storage.SetUserProjectID(userid, req.ProjectID)
+		}
+		return req.ProjectID, err
+	}
+	if projectID != 0 {
+		return projectID, nil
+	}
+
+	if req.Project == nil {
+		return 0, errors.New("user has no IMX project, project details missing")
+	}
+	projectID, err = nftimx.CreateProject(l1signer, req.Project.Name, req.Project.CompanyName, req.Project.ContactEmail)
+	if err != nil {
+		return 0, errors.New("failed to create project in IMX")
+	}
+	if err = storage.SetUserProjectID(userid, projectID); err != nil {
+		return 0, err
+	}
+	return projectID, nil
 }
 
 func collectionCreate(userid string, req *collectionCreateRequest, res *collectionCreateResponse) error {
 	if err := verifyCollectionCreateRequest(req); err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
-	h := sha256.New()
-	h.Write([]byte(userid + req.ContractAddress + req.Name + req.Description))
-	collectionID := hex.EncodeToString(h.Sum(nil))
-	collectionPath := userid + "/collections/" + collectionID
-
-	if storage.CollectionExists(userid, collectionID) {
-		res.Error = "collection " + collectionID + " already exists"
-		return errors.New(res.Error)
-	}
-
-	privateKey, err := storage.GetUserPrivateKey(userid)
+	collections, err := storage.ListUserCollections(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = err.Error()
 		return err
 	}
+	for _, collection := range collections {
+		if strings.EqualFold(collection.ContractAddress, req.ContractAddress) {
+			res.Error = "collection " + collection.ID + " already exists for contract " + req.ContractAddress
+			return errors.New(res.Error)
+		}
+	}
+
 	publicKey, err := storage.GetUserPublicKey(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = "failed to get user public key"
 		return err
 	}
-	log.Printf("public key: '%v', private key: '%v'\n", string(publicKey), string(privateKey))
-
-	/*
-		// TODO: create collection on immutablex here
-		// use only one admin contract and project?
-		// but then need to keep track of minted token id, which also requires user to handle token id in IPFS metadata
-		// also then we have to first reserve a token, give user the ID so that user could create metadata and then mint
-		ctx, cfg, imxClient := nftimx.Connect()
-		l1signer, err := ethereum.NewSigner(string(privateKey), cfg.ChainID)
-		imxCreateCollectionRequest := api.NewCreateCollectionRequest(
-			req.ContractAddress, req.Name,
-			string(publicKey),4169)
-		imxCreateCollectionResponse, err := imxClient.CreateCollection(ctx, l1signer, imxCreateCollectionRequest)
-		imxCollection, _ := json.MarshalIndent(imxCreateCollectionResponse, "", "    ")
-		log.Printf("Created new collection, response: ", string(imxCollection))
-	*/
-
-	err = os.MkdirAll(collectionPath, os.ModePerm)
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to create collection"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
 
-	err = os.WriteFile(collectionPath+"/contract_address", []byte(req.ContractAddress), 0644)
+	projectID, err := collectionProject(userid, l1signer, req)
 	if err != nil {
-		_ = os.RemoveAll(collectionPath)
-		res.Error = "failed to create collection contract"
+		res.Error = err.Error()
 		return err
 	}
+	res.ProjectID = projectID
+
+	collection := &storage.Collection{
+		ContractAddress: req.ContractAddress,
+		Name:            req.Name,
+		Description:     req.Description,
+		ProjectID:       projectID,
+		IconURL:         req.IconURL,
+		ImageURL:        req.ImageURL,
+		MetadataAPIURL:  req.MetadataAPIURL,
+		MetadataSchema:  req.MetadataSchema,
+	}
 
-	err = os.WriteFile(collectionPath+"/name", []byte(req.Name), 0644)
+	// the stored key is the uncompressed public key without its 0x04 prefix.
+	// If storing the collection below fails it can simply be created again,
+	// ImmutableX accepts what exists in the project already.
+	err = nftimx.CreateCollection(l1signer, "0x04"+string(publicKey), collection)
 	if err != nil {
-		_ = os.RemoveAll(collectionPath)
-		res.Error = "failed to create collection name"
+		res.Error = "failed to create collection in IMX"
 		return err
 	}
+	if len(req.MetadataSchema) > 0 {
+		err = nftimx.AddMetadataSchema(l1signer, req.ContractAddress, req.MetadataSchema)
+		if err != nil {
+			res.Error = "failed to add metadata schema to collection in IMX"
+			return err
+		}
+	}
 
-	err = os.WriteFile(collectionPath+"/description", []byte(req.Description), 0644)
+	collectionID, err := storage.CreateCollection(userid, collection)
 	if err != nil {
-		_ = os.RemoveAll(collectionPath)
-		res.Error = "failed to create collection description"
+		res.Error = err.Error()
 		return err
 	}
 
@@ -158,6 +213,12 @@ func Collection(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	userid, err := auth.Identify(c, req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	req.UserID = userid
+
 	if err := nftuser.VerifyUserID(req.UserID); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
@@ -168,8 +229,9 @@ func Collection(c echo.Context) error {
 
 	var resCreate *collectionCreateResponse = nil
 	var resUpdate *collectionUpdateResponse = nil
-	var resList []collectionInfoResponse = nil
+	var resList *collectionListResponse = nil
 	var resInfo *collectionInfoResponse = nil
+	var resHistory *collectionHistoryResponse = nil
 
 	if req.Create != nil {
 		resCreate = new(collectionCreateResponse)
@@ -178,46 +240,42 @@ func Collection(c echo.Context) error {
 
 	if req.Update != nil {
 		resUpdate = new(collectionUpdateResponse)
-		resUpdate.Error = "all good"
+		err := collectionUpdate(req.UserID, req.Update, resUpdate)
+		if err != nil {
+			log.Printf("error updating collection: %v", err)
+		}
 	}
 
 	if req.List != nil {
-		collectionInfo := collectionInfoResponse{
-			ID:          "id1",
-			Name:        "name 1",
-			Description: "description 1",
-		}
-		resList = append(resList, collectionInfo)
-
-		collectionInfo = collectionInfoResponse{
-			ID:          "id2",
-			Name:        "name 2",
-			Description: "description 2",
+		resList = new(collectionListResponse)
+		err := collectionList(req.UserID, req.List, resList)
+		if err != nil {
+			log.Printf("error listing collections: %v", err)
 		}
-		resList = append(resList, collectionInfo)
 	}
 
 	if req.Info != nil {
 		resInfo = new(collectionInfoResponse)
+		err := collectionInfo(req.UserID, req.Info, resInfo)
+		if err != nil {
+			log.Printf("error getting collection info: %v", err)
+		}
+	}
 
-		if req.Info.ID != "" {
-			resInfo = &collectionInfoResponse{
-				ID:          req.Info.ID,
-				Name:        "name for " + req.Info.ID,
-				Description: "description for " + req.Info.ID,
-			}
-		} else {
-			resInfo = &collectionInfoResponse{
-				Error: "wrong collection ID",
-			}
+	if req.History != nil {
+		resHistory = new(collectionHistoryResponse)
+		err := collectionHistory(req.UserID, req.History, resHistory)
+		if err != nil {
+			log.Printf("error getting collection history: %v", err)
 		}
 	}
 
 	res := collectionResponse{
-		Create: resCreate,
-		Update: resUpdate,
-		List:   resList,
-		Info:   resInfo,
+		Create:  resCreate,
+		Update:  resUpdate,
+		List:    resList,
+		Info:    resInfo,
+		History: resHistory,
 	}
 
 	pretty := c.QueryParam("pretty") == "true"
@@ -0,0 +1,111 @@
+package nftcollection
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"nft-market/storage"
+	"nft-market/validation"
+	"sort"
+)
+
+// Metadata property types of ImmutableX, see
+// https://docs.x.immutable.com/docs/asset-metadata#property-type-mapping
+const (
+	MetadataEnum       = "enum"
+	MetadataText       = "text"
+	MetadataBoolean    = "boolean"
+	MetadataDiscrete   = "discrete"
+	MetadataContinuous = "continuous"
+)
+
+// verifyMetadataSchema checks the fields to be added to a collection's
+// metadata schema, IMX only allows adding fields that don't exist yet.
+// Fields without a type are set to text, as IMX does.
+func verifyMetadataSchema(existing []storage.MetadataField, added []storage.MetadataField) error {
+	names := make(map[string]bool)
+	for _, field := range existing {
+		names[field.Name] = true
+	}
+	for i := range added {
+		field := &added[i]
+		if field.Name == "" {
+			return errors.New("has a field without name")
+		}
+		if names[field.Name] {
+			return errors.New("field " + field.Name + " already exists")
+		}
+		names[field.Name] = true
+
+		switch field.Type {
+		case "":
+			field.Type = MetadataText
+		case MetadataEnum, MetadataText, MetadataBoolean, MetadataDiscrete, MetadataContinuous:
+		default:
+			return errors.New("field " + field.Name + " has unknown type " + field.Type)
+		}
+		if field.Filterable && field.Type == MetadataText {
+			return errors.New("field " + field.Name + " is text, which can't be filterable")
+		}
+	}
+	return nil
+}
+
+func metadataValueError(field storage.MetadataField, value interface{}) string {
+	switch field.Type {
+	case MetadataEnum, MetadataText:
+		if _, ok := value.(string); !ok {
+			return "has to be a string"
+		}
+	case MetadataBoolean:
+		if _, ok := value.(bool); !ok {
+			return "has to be a boolean"
+		}
+	case MetadataDiscrete:
+		number, ok := value.(float64)
+		if !ok || number != math.Trunc(number) {
+			return "has to be an integer"
+		}
+	case MetadataContinuous:
+		if _, ok := value.(float64); !ok {
+			return "has to be a number"
+		}
+	}
+	return ""
+}
+
+// ValidateMetadata checks token metadata against the schema of its
+// collection: it has to be a JSON object with only the fields declared in
+// the schema, each of the declared type. Fields can be left out. Metadata
+// of collections without a schema isn't checked.
+func ValidateMetadata(schema []storage.MetadataField, metadata string) []validation.FieldError {
+	if len(schema) == 0 {
+		return nil
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal([]byte(metadata), &values); err != nil || values == nil {
+		return []validation.FieldError{{Field: "metadata", Error: "has to be a JSON object"}}
+	}
+
+	fields := make(map[string]storage.MetadataField)
+	for _, field := range schema {
+		fields[field.Name] = field
+	}
+
+	var errs []validation.FieldError
+	for name, value := range values {
+		field, ok := fields[name]
+		if !ok {
+			errs = append(errs, validation.FieldError{Field: name, Error: "is not in the collection metadata schema"})
+			continue
+		}
+		if msg := metadataValueError(field, value); msg != "" {
+			errs = append(errs, validation.FieldError{Field: name, Error: msg})
+		}
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+	return errs
+}
@@ -0,0 +1,102 @@
+package nftcollection
+
+import (
+	"nft-market/storage"
+	"nft-market/validation"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyMetadataSchema(t *testing.T) {
+	existing := []storage.MetadataField{{Name: "level", Type: MetadataDiscrete}}
+
+	tests := []struct {
+		name  string
+		added []storage.MetadataField
+		err   string
+		// types are the types of the added fields after verification
+		types []string
+	}{
+		{"empty", nil, "", nil},
+		{"every type", []storage.MetadataField{
+			{Name: "rarity", Type: MetadataEnum, Filterable: true},
+			{Name: "story", Type: MetadataText},
+			{Name: "shiny", Type: MetadataBoolean, Filterable: true},
+			{Name: "power", Type: MetadataDiscrete, Filterable: true},
+			{Name: "weight", Type: MetadataContinuous},
+		}, "", []string{MetadataEnum, MetadataText, MetadataBoolean, MetadataDiscrete, MetadataContinuous}},
+		{"text by default", []storage.MetadataField{{Name: "story"}}, "", []string{MetadataText}},
+		{"no name", []storage.MetadataField{{Type: MetadataText}}, "has a field without name", nil},
+		{"existing", []storage.MetadataField{{Name: "level", Type: MetadataDiscrete}}, "field level already exists", nil},
+		{"twice", []storage.MetadataField{{Name: "a"}, {Name: "a"}}, "field a already exists", nil},
+		{"unknown type", []storage.MetadataField{{Name: "a", Type: "date"}}, "field a has unknown type date", nil},
+		{"filterable text", []storage.MetadataField{{Name: "a", Filterable: true}}, "field a is text, which can't be filterable", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyMetadataSchema(existing, test.added)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			for i, field := range test.added {
+				if field.Type != test.types[i] {
+					t.Errorf("field %v has type %v, want %v", field.Name, field.Type, test.types[i])
+				}
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	schema := []storage.MetadataField{
+		{Name: "rarity", Type: MetadataEnum},
+		{Name: "story", Type: MetadataText},
+		{Name: "shiny", Type: MetadataBoolean},
+		{Name: "power", Type: MetadataDiscrete},
+		{Name: "weight", Type: MetadataContinuous},
+	}
+
+	tests := []struct {
+		name     string
+		schema   []storage.MetadataField
+		metadata string
+		errs     []validation.FieldError
+	}{
+		{"no schema", nil, "not even JSON", nil},
+		{"valid", schema, `{"rarity": "rare", "story": "once", "shiny": true, "power": 3, "weight": 1.5}`, nil},
+		{"fields left out", schema, `{"power": 3}`, nil},
+		{"empty object", schema, `{}`, nil},
+		{"integral continuous", schema, `{"weight": 2}`, nil},
+		{"not JSON", schema, `rare`, []validation.FieldError{{Field: "metadata", Error: "has to be a JSON object"}}},
+		{"array", schema, `["rare"]`, []validation.FieldError{{Field: "metadata", Error: "has to be a JSON object"}}},
+		{"null", schema, `null`, []validation.FieldError{{Field: "metadata", Error: "has to be a JSON object"}}},
+		{"unknown field", schema, `{"colour": "red"}`, []validation.FieldError{{Field: "colour", Error: "is not in the collection metadata schema"}}},
+		{"enum number", schema, `{"rarity": 1}`, []validation.FieldError{{Field: "rarity", Error: "has to be a string"}}},
+		{"text boolean", schema, `{"story": false}`, []validation.FieldError{{Field: "story", Error: "has to be a string"}}},
+		{"boolean string", schema, `{"shiny": "true"}`, []validation.FieldError{{Field: "shiny", Error: "has to be a boolean"}}},
+		{"discrete fraction", schema, `{"power": 2.5}`, []validation.FieldError{{Field: "power", Error: "has to be an integer"}}},
+		{"discrete string", schema, `{"power": "2"}`, []validation.FieldError{{Field: "power", Error: "has to be an integer"}}},
+		{"continuous string", schema, `{"weight": "1.5"}`, []validation.FieldError{{Field: "weight", Error: "has to be a number"}}},
+		{"sorted errors", schema, `{"weight": null, "colour": 1, "power": 0.5}`, []validation.FieldError{
+			{Field: "colour", Error: "is not in the collection metadata schema"},
+			{Field: "power", Error: "has to be an integer"},
+			{Field: "weight", Error: "has to be a number"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			errs := ValidateMetadata(test.schema, test.metadata)
+			if !reflect.DeepEqual(errs, test.errs) {
+				t.Errorf("got %v, want %v", errs, test.errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package nftcollection
+
+import (
+	"errors"
+	"nft-market/nftimx"
+	"nft-market/signer"
+	"nft-market/storage"
+	"nft-market/validation"
+	"strconv"
+	"time"
+)
+
+// collectionUpdateRequest changes the fields that are set, Version has to be
+// the collection version the change is based on as returned by info, list or
+// a previous update. MetadataSchema lists fields to add to the schema.
+type collectionUpdateRequest struct {
+	ID             string                  `json:"id"`
+	Version        int                     `json:"version"`
+	Name           *string                 `json:"name,omitempty"`
+	Description    *string                 `json:"description,omitempty"`
+	IconURL        *string                 `json:"icon_url,omitempty"`
+	ImageURL       *string                 `json:"image_url,omitempty"`
+	MetadataAPIURL *string                 `json:"metadata_api_url,omitempty"`
+	MetadataSchema []storage.MetadataField `json:"metadata_schema,omitempty"`
+}
+
+type collectionUpdateResponse struct {
+	// Version is the collection version after the update
+	Version int    `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+type collectionHistoryRequest struct {
+	ID string `json:"id"`
+}
+
+type collectionHistoryEntry struct {
+	Version int                             `json:"version"`
+	UserID  string                          `json:"userid"`
+	Time    string                          `json:"time"`
+	Fields  []storage.CollectionFieldChange `json:"fields"`
+}
+
+type collectionHistoryResponse struct {
+	History []collectionHistoryEntry `json:"history"`
+	Error   string                   `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+func verifyCollectionUpdateRequest(req *collectionUpdateRequest) error {
+	var v validation.Validator
+	if v.Required("id", req.ID) {
+		v.Check("id", validation.CollectionID(req.ID))
+	}
+	if req.Version < 1 {
+		v.Fail("version", "is missing")
+	}
+	if req.Name == nil && req.Description == nil && req.IconURL == nil && req.ImageURL == nil &&
+		req.MetadataAPIURL == nil && len(req.MetadataSchema) == 0 {
+		v.Fail("update", "has nothing to update")
+	}
+	if req.Name != nil && *req.Name == "" {
+		v.Fail("name", "can't be empty")
+	}
+	if req.Description != nil && *req.Description == "" {
+		v.Fail("description", "can't be empty")
+	}
+	return v.Err()
+}
+
+func verifyCollectionHistoryRequest(req *collectionHistoryRequest) error {
+	var v validation.Validator
+	if v.Required("id", req.ID) {
+		v.Check("id", validation.CollectionID(req.ID))
+	}
+	return v.Err()
+}
+
+// collectionUpdate only finds collections of the user, so only the owner
+// can change a collection. Collections registered with IMX are updated
+// there first, the version is checked beforehand so that a stale update
+// doesn't reach IMX, and again when storing the change.
+func collectionUpdate(userid string, req *collectionUpdateRequest, res *collectionUpdateResponse) error {
+	if err := verifyCollectionUpdateRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	collection, err := storage.GetUserCollection(userid, req.ID)
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+	if collection.Version != req.Version {
+		res.Error = "collection " + req.ID + " has been changed since version " + strconv.Itoa(req.Version)
+		return storage.ErrVersionConflict
+	}
+	var v validation.Validator
+	v.Check("metadata_schema", verifyMetadataSchema(collection.MetadataSchema, req.MetadataSchema))
+	if err = v.Err(); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	update := storage.CollectionUpdate{
+		Name:           req.Name,
+		Description:    req.Description,
+		IconURL:        req.IconURL,
+		ImageURL:       req.ImageURL,
+		MetadataAPIURL: req.MetadataAPIURL,
+	}
+	if len(req.MetadataSchema) > 0 {
+		update.MetadataSchema = append(append([]storage.MetadataField{}, collection.MetadataSchema...), req.MetadataSchema...)
+	}
+
+	// collections created before they were registered with IMX only exist here
+	if collection.ProjectID != 0 {
+		if err = collectionUpdateIMX(userid, collection, req); err != nil {
+			res.Error = err.Error()
+			return err
+		}
+	}
+
+	collection, err = storage.UpdateCollection(userid, req.ID, req.Version, update, userid)
+	if errors.Is(err, storage.ErrVersionConflict) {
+		res.Error = "collection " + req.ID + " has been changed since version " + strconv.Itoa(req.Version)
+		return err
+	}
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+
+	res.Version = collection.Version
+	return nil
+}
+
+func collectionUpdateIMX(userid string, collection *storage.Collection, req *collectionUpdateRequest) error {
+	l1signer, err := signer.L1Signer(userid)
+	if err != nil {
+		return errors.New(signer.Failure(err, "failed to get user signer"))
+	}
+
+	if req.Name != nil || req.Description != nil || req.IconURL != nil || req.ImageURL != nil || req.MetadataAPIURL != nil {
+		updated := *collection
+		for _, field := range []struct {
+			value  *string
+			target *string
+		}{
+			{req.Name, &updated.Name},
+			{req.Description, &updated.Description},
+			{req.IconURL, &updated.IconURL},
+			{req.ImageURL, &updated.ImageURL},
+			{req.MetadataAPIURL, &updated.MetadataAPIURL},
+		} {
+			if field.value != nil {
+				*field.target = *field.value
+			}
+		}
+		if err = nftimx.UpdateCollection(l1signer, &updated); err != nil {
+			return errors.New("failed to update collection in IMX")
+		}
+	}
+
+	if len(req.MetadataSchema) > 0 {
+		if err = nftimx.AddMetadataSchema(l1signer, collection.ContractAddress, req.MetadataSchema); err != nil {
+			return errors.New("failed to add metadata schema to collection in IMX")
+		}
+	}
+	return nil
+}
+
+func collectionHistory(userid string, req *collectionHistoryRequest, res *collectionHistoryResponse) error {
+	if err := verifyCollectionHistoryRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	history, err := storage.GetCollectionHistory(userid, req.ID)
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+
+	res.History = make([]collectionHistoryEntry, 0, len(history))
+	for _, change := range history {
+		res.History = append(res.History, collectionHistoryEntry{
+			Version: change.Version,
+			UserID:  change.UserID,
+			Time:    change.Time.UTC().Format(time.RFC3339),
+			Fields:  change.Fields,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package nftimx
+
+import (
+	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"math/big"
+	"nft-market/currency"
+	"nft-market/storage"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	fakeOrderActive    = "active"
+	fakeOrderFilled    = "filled"
+	fakeOrderCancelled = "cancelled"
+
+	fakeWithdrawalIncluded  = "included"
+	fakeWithdrawalConfirmed = "confirmed"
+	fakeWithdrawalWithdrawn = "withdrawn"
+)
+
+type fakeOrder struct {
+	seller  string
+	asset   string
+	token   string
+	amount  *big.Int
+	status  string
+	buyer   string
+	tradeID int32
+}
+
+type fakeCollection struct {
+	owner  string
+	fields map[string]bool
+}
+
+type fakeWithdrawal struct {
+	owner    string
+	token    string
+	amount   *big.Int
+	status   string
+	prepared time.Time
+}
+
+// FakeMarketplace is an in-memory stand-in for ImmutableX. It keeps ETH and
+// ERC-20 balances per L1 address, asset ownership, orders, trades, transfers
+// and withdrawals, and enforces the same rules IMX does (owning the asset to
+// sell or transfer it, transferring to registered users only, having the
+// funds to buy or withdraw, owning the project of a collection), so the
+// whole market can be exercised offline. Withdrawals become "confirmed"
+// ConfirmAfter after they have been prepared.
+type FakeMarketplace struct {
+	ConfirmAfter time.Duration
+
+	mutex       sync.Mutex
+	lastID      int32
+	registered  map[string]string
+	balances    map[string]*big.Int
+	owners      map[string]string
+	orders      map[int32]*fakeOrder
+	withdrawals map[int32]*fakeWithdrawal
+	projects    map[int32]string
+	collections map[string]*fakeCollection
+}
+
+func NewFakeMarketplace() *FakeMarketplace {
+	return &FakeMarketplace{
+		registered:  make(map[string]string),
+		balances:    make(map[string]*big.Int),
+		owners:      make(map[string]string),
+		orders:      make(map[int32]*fakeOrder),
+		withdrawals: make(map[int32]*fakeWithdrawal),
+		projects:    make(map[int32]string),
+		collections: make(map[string]*fakeCollection),
+	}
+}
+
+func fakeAsset(contractAddress string, tokenID string) string {
+	return contractAddress + "/" + tokenID
+}
+
+// nextID hands out IDs for every kind of object, the same way IMX IDs are
+// unique across the exchange. Must be called with the mutex held.
+func (m *FakeMarketplace) nextID() int32 {
+	m.lastID++
+	return m.lastID
+}
+
+// Must be called with the mutex held. Token is the currency key, see
+// currency.Currency.Key.
+func (m *FakeMarketplace) balance(address string, token string) *big.Int {
+	key := address + "/" + token
+	balance, ok := m.balances[key]
+	if !ok {
+		balance = new(big.Int)
+		m.balances[key] = balance
+	}
+	return balance
+}
+
+func parseFakeID(id string) (int32, error) {
+	value, err := strconv.ParseInt(id, 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid ID " + id)
+	}
+	return int32(value), nil
+}
+
+// Balance returns the balance of an L1 address in base units of cur.
+func (m *FakeMarketplace) Balance(address string, cur *currency.Currency) *big.Int {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return new(big.Int).Set(m.balance(address, cur.Key()))
+}
+
+// Owner returns the L1 address currently holding the asset.
+func (m *FakeMarketplace) Owner(contractAddress string, tokenID string) string {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.owners[fakeAsset(contractAddress, tokenID)]
+}
+
+func (m *FakeMarketplace) Register(l1signer imx.L1Signer, l2signer imx.L2Signer, email string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if _, ok := m.registered[l1signer.GetAddress()]; ok {
+		return "", errors.New("user " + l1signer.GetAddress() + " is already registered")
+	}
+	m.registered[l1signer.GetAddress()] = l2signer.GetAddress()
+	return "0x" + strconv.FormatInt(int64(m.nextID()), 16), nil
+}
+
+func (m *FakeMarketplace) Registered(address string) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, ok := m.registered[address]
+	return ok, nil
+}
+
+func (m *FakeMarketplace) Mint(l1signer imx.L1Signer, userAddress string, contractAddress string, tokenID string, tokenMetadata string) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	asset := fakeAsset(contractAddress, tokenID)
+	if _, ok := m.owners[asset]; ok {
+		return "", errors.New("token " + asset + " is already minted")
+	}
+	m.owners[asset] = userAddress
+	return tokenID, nil
+}
+
+func (m *FakeMarketplace) Sell(l1signer imx.L1Signer, l2signer imx.L2Signer, userAddress string, contractAddress string, tokenID string, cur *currency.Currency, amount *big.Int) (int32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	asset := fakeAsset(contractAddress, tokenID)
+	if m.owners[asset] != userAddress {
+		return 0, errors.New("token " + asset + " is not owned by " + userAddress)
+	}
+	for _, order := range m.orders {
+		if order.asset == asset && order.status == fakeOrderActive {
+			return 0, errors.New("token " + asset + " is already on sale")
+		}
+	}
+
+	id := m.nextID()
+	m.orders[id] = &fakeOrder{
+		seller: userAddress,
+		asset:  asset,
+		token:  cur.Key(),
+		amount: new(big.Int).Set(amount),
+		status: fakeOrderActive,
+	}
+	return id, nil
+}
+
+func (m *FakeMarketplace) CancelSale(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	id, err := parseFakeID(saleID)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	order, ok := m.orders[id]
+	if !ok || order.status != fakeOrderActive {
+		return 0, errors.New("order " + saleID + " is not active")
+	}
+	if order.seller != l1signer.GetAddress() {
+		return 0, errors.New("order " + saleID + " doesn't belong to " + l1signer.GetAddress())
+	}
+
+	order.status = fakeOrderCancelled
+	return id, nil
+}
+
+func (m *FakeMarketplace) Buy(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	id, err := parseFakeID(saleID)
+	if err != nil {
+		return 0, err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	order, ok := m.orders[id]
+	if !ok || order.status != fakeOrderActive {
+		return 0, errors.New("order " + saleID + " is not active")
+	}
+	buyer := l1signer.GetAddress()
+	if buyer == order.seller {
+		return 0, errors.New("can't buy own order " + saleID)
+	}
+	paid, received := m.balance(buyer, order.token), m.balance(order.seller, order.token)
+	if paid.Cmp(order.amount) < 0 {
+		return 0, errors.New("insufficient balance")
+	}
+
+	paid.Sub(paid, order.amount)
+	received.Add(received, order.amount)
+	m.owners[order.asset] = buyer
+	order.status = fakeOrderFilled
+	order.buyer = buyer
+	order.tradeID = m.nextID()
+	return order.tradeID, nil
+}
+
+// Must be called with the mutex held.
+func (m *FakeMarketplace) checkTransfer(sender string, asset string) error {
+	if m.owners[asset] != sender {
+		return errors.New("token " + asset + " is not owned by " + sender)
+	}
+	for _, order := range m.orders {
+		if order.asset == asset && order.status == fakeOrderActive {
+			return errors.New("token " + asset + " is on sale")
+		}
+	}
+	return nil
+}
+
+func (m *FakeMarketplace) Transfer(l1signer imx.L1Signer, l2signer imx.L2Signer, receiver string, contractAddress string, tokenID string) (int32, error) {
+	ids, err := m.BatchTransfer(l1signer, l2signer, []NFTTransfer{{Receiver: receiver, ContractAddress: contractAddress, TokenID: tokenID}})
+	if err != nil {
+		return 0, err
+	}
+	return ids[0], nil
+}
+
+func (m *FakeMarketplace) BatchTransfer(l1signer imx.L1Signer, l2signer imx.L2Signer, transfers []NFTTransfer) ([]int32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	sender := l1signer.GetAddress()
+	assets := make(map[string]bool)
+	for _, transfer := range transfers {
+		asset := fakeAsset(transfer.ContractAddress, transfer.TokenID)
+		if assets[asset] {
+			return nil, errors.New("token " + asset + " is transferred twice")
+		}
+		assets[asset] = true
+		if err := m.checkTransfer(sender, asset); err != nil {
+			return nil, err
+		}
+		if _, ok := m.registered[transfer.Receiver]; !ok {
+			return nil, errors.New("user " + transfer.Receiver + " is not registered")
+		}
+	}
+
+	ids := make([]int32, 0, len(transfers))
+	for _, transfer := range transfers {
+		m.owners[fakeAsset(transfer.ContractAddress, transfer.TokenID)] = transfer.Receiver
+		ids = append(ids, m.nextID())
+	}
+	return ids, nil
+}
+
+func (m *FakeMarketplace) Deposit(l1signer imx.L1Signer, cur *currency.Currency, amount *big.Int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	balance := m.balance(l1signer.GetAddress(), cur.Key())
+	balance.Add(balance, amount)
+	return nil
+}
+
+func (m *FakeMarketplace) WithdrawPrepare(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency, amount *big.Int) (int32, error) {
+	value := new(big.Int).Set(amount)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	address := l1signer.GetAddress()
+	balance := m.balance(address, cur.Key())
+	if balance.Cmp(value) < 0 {
+		return 0, errors.New("insufficient balance")
+	}
+
+	balance.Sub(balance, value)
+	id := m.nextID()
+	m.withdrawals[id] = &fakeWithdrawal{
+		owner:    address,
+		token:    cur.Key(),
+		amount:   value,
+		status:   fakeWithdrawalIncluded,
+		prepared: time.Now(),
+	}
+	return id, nil
+}
+
+// Must be called with the mutex held.
+func (m *FakeMarketplace) withdrawalState(withdrawal *fakeWithdrawal) string {
+	if withdrawal.status == fakeWithdrawalIncluded && time.Since(withdrawal.prepared) >= m.ConfirmAfter {
+		withdrawal.status = fakeWithdrawalConfirmed
+	}
+	return withdrawal.status
+}
+
+func (m *FakeMarketplace) WithdrawGetState(withdrawID int32) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	withdrawal, ok := m.withdrawals[withdrawID]
+	if !ok {
+		return "", errors.New("withdrawal " + strconv.FormatInt(int64(withdrawID), 10) + " doesn't exist")
+	}
+	return m.withdrawalState(withdrawal), nil
+}
+
+// WithdrawFinalize completes every confirmed withdrawal of the currency of
+// the user, as completing a withdrawal on IMX does.
+func (m *FakeMarketplace) WithdrawFinalize(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency) (string, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	finalized := false
+	for _, withdrawal := range m.withdrawals {
+		if withdrawal.owner != l1signer.GetAddress() || withdrawal.token != cur.Key() || m.withdrawalState(withdrawal) != fakeWithdrawalConfirmed {
+			continue
+		}
+		withdrawal.status = fakeWithdrawalWithdrawn
+		finalized = true
+	}
+
+	if !finalized {
+		return "", errors.New("no confirmed withdrawals to finalize")
+	}
+	return "0x" + strconv.FormatInt(int64(m.nextID()), 16), nil
+}
+
+func (m *FakeMarketplace) CreateProject(l1signer imx.L1Signer, name string, companyName string, contactEmail string) (int32, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if name == "" || companyName == "" || contactEmail == "" {
+		return 0, errors.New("project name, company name and contact email are required")
+	}
+	id := m.nextID()
+	m.projects[id] = l1signer.GetAddress()
+	return id, nil
+}
+
+func (m *FakeMarketplace) CreateCollection(l1signer imx.L1Signer, ownerPublicKey string, collection *storage.Collection) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if owner, ok := m.projects[collection.ProjectID]; !ok || owner != l1signer.GetAddress() {
+		return errors.New("project " + strconv.FormatInt(int64(collection.ProjectID), 10) + " doesn't exist")
+	}
+	address := strings.ToLower(collection.ContractAddress)
+	if existing, ok := m.collections[address]; ok {
+		if existing.owner == l1signer.GetAddress() {
+			return nil
+		}
+		return errors.New("collection " + collection.ContractAddress + " already exists")
+	}
+	m.collections[address] = &fakeCollection{
+		owner:  l1signer.GetAddress(),
+		fields: make(map[string]bool),
+	}
+	return nil
+}
+
+func (m *FakeMarketplace) collection(l1signer imx.L1Signer, contractAddress string) (*fakeCollection, error) {
+	collection, ok := m.collections[strings.ToLower(contractAddress)]
+	if !ok || collection.owner != l1signer.GetAddress() {
+		return nil, errors.New("collection " + contractAddress + " doesn't exist")
+	}
+	return collection, nil
+}
+
+func (m *FakeMarketplace) UpdateCollection(l1signer imx.L1Signer, collection *storage.Collection) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	_, err := m.collection(l1signer, collection.ContractAddress)
+	return err
+}
+
+func (m *FakeMarketplace) AddMetadataSchema(l1signer imx.L1Signer, contractAddress string, fields []storage.MetadataField) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	collection, err := m.collection(l1signer, contractAddress)
+	if err != nil {
+		return err
+	}
+	existing := 0
+	for _, field := range fields {
+		if collection.fields[field.Name] {
+			existing++
+		}
+	}
+	if existing == len(fields) {
+		return nil
+	}
+	for _, field := range fields {
+		if collection.fields[field.Name] {
+			return errors.New("metadata field " + field.Name + " already exists")
+		}
+	}
+	for _, field := range fields {
+		collection.fields[field.Name] = true
+	}
+	return nil
+}
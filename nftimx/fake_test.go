@@ -0,0 +1,260 @@
+package nftimx
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+	"math/big"
+	"nft-market/config"
+	"nft-market/currency"
+	"nft-market/imxmock"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testSigner and testL2Signer sign for the address they hold, the fake
+// marketplace only looks at the address
+type testSigner string
+
+func (s testSigner) SignMessage(message string) ([]byte, error) {
+	return []byte(message), nil
+}
+
+func (s testSigner) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	return tx, nil
+}
+
+func (s testSigner) GetAddress() string {
+	return string(s)
+}
+
+type testL2Signer string
+
+func (s testL2Signer) SignMessage(message string) (string, error) {
+	return message, nil
+}
+
+func (s testL2Signer) GetAddress() string {
+	return string(s)
+}
+
+const (
+	seller   = testSigner("0x1111111111111111111111111111111111111111")
+	buyer    = testSigner("0x2222222222222222222222222222222222222222")
+	contract = "0x3333333333333333333333333333333333333333"
+)
+
+var (
+	sellerL2 = testL2Signer("0x01")
+	buyerL2  = testL2Signer("0x02")
+	usdc     = &currency.Currency{Symbol: "USDC", Address: "0x4444444444444444444444444444444444444444", Decimals: 6}
+)
+
+func TestMockEnvironment(t *testing.T) {
+	env := Environment(config.Marketplace{APIURL: "http://localhost:8081"})
+	if env.BaseAPIPath != "http://localhost:8081" || env.EthereumRPC != "http://localhost:8081/rpc/" {
+		t.Errorf("got API %v and RPC %v", env.BaseAPIPath, env.EthereumRPC)
+	}
+	if env.ChainID.Cmp(imxmock.ChainID) != 0 {
+		t.Errorf("got chain %v, the mock answers %v", env.ChainID, imxmock.ChainID)
+	}
+}
+
+func TestFakeBuy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cur     *currency.Currency
+		deposit *currency.Currency
+		amount  int64
+		cancel  bool
+		buyer   testSigner
+		err     string
+		owner   testSigner
+		// balances of the buyer and seller after buying, in the currency of
+		// the sale
+		buyerBalance  int64
+		sellerBalance int64
+	}{
+		{"paid", currency.ETH, currency.ETH, 150, false, buyer, "", buyer, 50, 100},
+		{"exact amount", currency.ETH, currency.ETH, 100, false, buyer, "", buyer, 0, 100},
+		{"ERC-20", usdc, usdc, 150, false, buyer, "", buyer, 50, 100},
+		{"insufficient balance", currency.ETH, currency.ETH, 99, false, buyer, "insufficient balance", seller, 99, 0},
+		{"other currency", usdc, currency.ETH, 150, false, buyer, "insufficient balance", seller, 0, 0},
+		{"own order", currency.ETH, currency.ETH, 150, false, seller, "can't buy own order 1", seller, 150, 0},
+		{"cancelled", currency.ETH, currency.ETH, 150, true, buyer, "order 1 is not active", seller, 150, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := NewFakeMarketplace()
+			if _, err := m.Mint(seller, seller.GetAddress(), contract, "7", ""); err != nil {
+				t.Fatal(err)
+			}
+			_ = m.Deposit(test.buyer, test.deposit, big.NewInt(test.amount))
+
+			id, err := m.Sell(seller, sellerL2, seller.GetAddress(), contract, "7", test.cur, big.NewInt(100))
+			if err != nil {
+				t.Fatalf("failed to sell: %v", err)
+			}
+			saleID := strconv.FormatInt(int64(id), 10)
+			if test.cancel {
+				if _, err = m.CancelSale(seller, sellerL2, saleID); err != nil {
+					t.Fatalf("failed to cancel: %v", err)
+				}
+			}
+
+			_, err = m.Buy(test.buyer, buyerL2, saleID)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if owner := m.Owner(contract, "7"); owner != test.owner.GetAddress() {
+				t.Errorf("got owner %v, want %v", owner, test.owner)
+			}
+			if got := m.Balance(test.buyer.GetAddress(), test.cur); got.Int64() != test.buyerBalance {
+				t.Errorf("got buyer balance %v, want %v", got, test.buyerBalance)
+			}
+			if test.buyer != seller {
+				if got := m.Balance(seller.GetAddress(), test.cur); got.Int64() != test.sellerBalance {
+					t.Errorf("got seller balance %v, want %v", got, test.sellerBalance)
+				}
+			}
+
+			// a filled or cancelled order can't be bought again
+			if _, err = m.Buy(test.buyer, buyerL2, saleID); test.err == "" && err == nil {
+				t.Error("bought the same order twice")
+			}
+		})
+	}
+}
+
+func TestFakeCancelSale(t *testing.T) {
+	m := NewFakeMarketplace()
+	if _, err := m.Mint(seller, seller.GetAddress(), contract, "7", ""); err != nil {
+		t.Fatal(err)
+	}
+	id, err := m.Sell(seller, sellerL2, seller.GetAddress(), contract, "7", currency.ETH, big.NewInt(100))
+	if err != nil {
+		t.Fatalf("failed to sell: %v", err)
+	}
+	saleID := strconv.FormatInt(int64(id), 10)
+
+	// the steps run in order on the same marketplace
+	tests := []struct {
+		name   string
+		signer testSigner
+		saleID string
+		err    string
+	}{
+		{"not an ID", seller, "x", "invalid ID x"},
+		{"unknown", seller, "99", "order 99 is not active"},
+		{"other user", buyer, saleID, "order " + saleID + " doesn't belong to " + buyer.GetAddress()},
+		{"seller", seller, saleID, ""},
+		{"twice", seller, saleID, "order " + saleID + " is not active"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := m.CancelSale(test.signer, sellerL2, test.saleID)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if got != id {
+				t.Errorf("got order %v, want %v", got, id)
+			}
+		})
+	}
+
+	// the token stays with the seller and can be put on sale again
+	if owner := m.Owner(contract, "7"); owner != seller.GetAddress() {
+		t.Errorf("got owner %v, want %v", owner, seller)
+	}
+	if _, err = m.Sell(seller, sellerL2, seller.GetAddress(), contract, "7", currency.ETH, big.NewInt(100)); err != nil {
+		t.Errorf("failed to sell again: %v", err)
+	}
+}
+
+func TestFakeWithdraw(t *testing.T) {
+	m := NewFakeMarketplace()
+	m.ConfirmAfter = time.Hour
+	_ = m.Deposit(seller, currency.ETH, big.NewInt(100))
+	_ = m.Deposit(seller, usdc, big.NewInt(100))
+
+	if _, err := m.WithdrawPrepare(seller, sellerL2, currency.ETH, big.NewInt(101)); err == nil || err.Error() != "insufficient balance" {
+		t.Fatalf("got error %v, want insufficient balance", err)
+	}
+	id, err := m.WithdrawPrepare(seller, sellerL2, currency.ETH, big.NewInt(60))
+	if err != nil {
+		t.Fatalf("failed to prepare withdrawal: %v", err)
+	}
+	if got := m.Balance(seller.GetAddress(), currency.ETH); got.Int64() != 40 {
+		t.Errorf("got balance %v, want 40", got)
+	}
+
+	// the steps run in order on the same withdrawal
+	tests := []struct {
+		name   string
+		step   func() error
+		status string
+		err    string
+	}{
+		{"prepared", nil, fakeWithdrawalIncluded, ""},
+		{"finalize before confirmation", func() error {
+			_, err := m.WithdrawFinalize(seller, sellerL2, currency.ETH)
+			return err
+		}, fakeWithdrawalIncluded, "no confirmed withdrawals to finalize"},
+		{"confirmed", func() error {
+			m.ConfirmAfter = 0
+			return nil
+		}, fakeWithdrawalConfirmed, ""},
+		{"finalize other currency", func() error {
+			_, err := m.WithdrawFinalize(seller, sellerL2, usdc)
+			return err
+		}, fakeWithdrawalConfirmed, "no confirmed withdrawals to finalize"},
+		{"finalize other user", func() error {
+			_, err := m.WithdrawFinalize(buyer, buyerL2, currency.ETH)
+			return err
+		}, fakeWithdrawalConfirmed, "no confirmed withdrawals to finalize"},
+		{"finalize", func() error {
+			_, err := m.WithdrawFinalize(seller, sellerL2, currency.ETH)
+			return err
+		}, fakeWithdrawalWithdrawn, ""},
+		{"finalize twice", func() error {
+			_, err := m.WithdrawFinalize(seller, sellerL2, currency.ETH)
+			return err
+		}, fakeWithdrawalWithdrawn, "no confirmed withdrawals to finalize"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var err error
+			if test.step != nil {
+				err = test.step()
+			}
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			status, err := m.WithdrawGetState(id)
+			if err != nil || status != test.status {
+				t.Errorf("got status %v, %v, want %v", status, err, test.status)
+			}
+		})
+	}
+
+	if _, err = m.WithdrawGetState(id + 100); err == nil {
+		t.Error("got the state of a withdrawal that doesn't exist")
+	}
+}
@@ -0,0 +1,395 @@
+package nftimx
+
+import (
+	"context"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"github.com/immutable/imx-core-sdk-golang/imx/api"
+	"log"
+	"math/big"
+	"net/http"
+	"nft-market/config"
+	"nft-market/currency"
+	"nft-market/storage"
+	"strconv"
+)
+
+// IMXMarketplace talks to ImmutableX through the official SDK client.
+type IMXMarketplace struct {
+	ctx     context.Context
+	client  *imx.Client
+	royalty float32
+}
+
+func NewIMXMarketplace(cfg config.Marketplace) (*IMXMarketplace, error) {
+	ctx, _, client := Connect(cfg)
+	if client == nil {
+		return nil, errors.New("failed to connect to ImmutableX")
+	}
+	return &IMXMarketplace{ctx: ctx, client: client, royalty: float32(cfg.RoyaltyPercentage)}, nil
+}
+
+func signableToken(cur *currency.Currency) api.SignableToken {
+	if cur.IsETH() {
+		return imx.SignableETHToken()
+	}
+	return imx.SignableERC20Token(cur.Decimals, cur.Address)
+}
+
+func (m *IMXMarketplace) Register(l1signer imx.L1Signer, l2signer imx.L2Signer, email string) (string, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	imxres, err := imxClient.RegisterOffchain(ctx, l1signer, l2signer, email)
+	if err != nil {
+		log.Printf("failed to register user in ImmutableX: %v\n", err)
+		return "", err
+	}
+
+	return imxres.TxHash, nil
+}
+
+func (m *IMXMarketplace) Registered(address string) (bool, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	_, err := imxClient.GetUsers(ctx, address)
+	var imxErr *imx.IMXError
+	if errors.As(err, &imxErr) && imxErr.HTTPStatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("failed to get user from ImmutableX: %v\n", err)
+		return false, err
+	}
+	return true, nil
+}
+
+func (m *IMXMarketplace) Mint(l1signer imx.L1Signer, userAddress string, contractAddress string, tokenID string, tokenMetadata string) (string, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	royaltyPercentage := m.royalty
+	var newToken = imx.UnsignedMintRequest{
+		ContractAddress: contractAddress,
+		Royalties: []imx.MintFee{
+			{
+				Percentage: royaltyPercentage,
+				Recipient:  userAddress,
+			},
+		},
+		Users: []imx.User{
+			{
+				User: userAddress,
+				Tokens: []imx.MintableTokenData{
+					{
+						ID: tokenID,
+						Royalties: []imx.MintFee{
+							{
+								Percentage: royaltyPercentage,
+								Recipient:  userAddress,
+							},
+						},
+						Blueprint: &tokenMetadata,
+					},
+				},
+			},
+		},
+	}
+
+	req := make([]imx.UnsignedMintRequest, 1)
+	req[0] = newToken
+
+	imxres, err := imxClient.Mint(ctx, l1signer, req)
+	if err != nil {
+		log.Printf("error in IMX Mint: %v\n", err)
+		return "", err
+	}
+
+	res := imxres.GetResults()
+	if len(res) == 0 {
+		return "", errors.New("IMX Mint returned no results")
+	}
+	return res[0].TokenId, nil
+}
+
+func (m *IMXMarketplace) Sell(l1signer imx.L1Signer, l2signer imx.L2Signer, userAddress string, contractAddress string, tokenID string, cur *currency.Currency, amount *big.Int) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	sellToken := imx.SignableERC721Token(tokenID, contractAddress)
+	buyToken := signableToken(cur)
+	createOrderRequest := &api.GetSignableOrderRequest{
+		AmountBuy:  amount.String(),
+		AmountSell: "1",
+		Fees:       nil,
+		TokenBuy:   buyToken,
+		TokenSell:  sellToken,
+		User:       userAddress,
+	}
+	createOrderRequest.SetExpirationTimestamp(0)
+
+	createOrderResponse, err := imxClient.CreateOrder(ctx, l1signer, l2signer, createOrderRequest)
+	if err != nil {
+		log.Printf("error in IMX CreateOrder: %v", err)
+		return 0, err
+	}
+
+	log.Printf("CreateOrder ID: %v", createOrderResponse.OrderId)
+	return createOrderResponse.OrderId, nil
+}
+
+func (m *IMXMarketplace) CancelSale(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	id, _ := strconv.ParseInt(saleID, 10, 32)
+	cancelOrderRequest := api.GetSignableCancelOrderRequest{
+		OrderId: int32(id),
+	}
+
+	cancelOrderResponse, err := imxClient.CancelOrder(ctx, l1signer, l2signer, cancelOrderRequest)
+	if err != nil {
+		log.Printf("error in IMX CancelOrder: %v", err)
+		return 0, err
+	}
+
+	log.Printf("cancelled selling for ID: %v", cancelOrderResponse.OrderId)
+	return cancelOrderResponse.OrderId, nil
+}
+
+func (m *IMXMarketplace) Buy(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	id, _ := strconv.ParseInt(saleID, 10, 64)
+	tradeRequest := api.GetSignableTradeRequest{
+		Fees:    nil,
+		OrderId: int32(id),
+	}
+	tradeRequest.SetExpirationTimestamp(0)
+	tradeResponse, err := imxClient.CreateTrade(ctx, l1signer, l2signer, tradeRequest)
+	if err != nil {
+		log.Printf("error in IMX CreateTrade: %v", err)
+		return 0, err
+	}
+
+	log.Printf("trade ID: %v", tradeResponse.TradeId)
+	return tradeResponse.TradeId, nil
+}
+
+func (m *IMXMarketplace) Transfer(l1signer imx.L1Signer, l2signer imx.L2Signer, receiver string, contractAddress string, tokenID string) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	request := api.GetSignableTransferRequestV1{
+		Amount:   "1",
+		Sender:   l1signer.GetAddress(),
+		Token:    imx.SignableERC721Token(tokenID, contractAddress),
+		Receiver: receiver,
+	}
+
+	response, err := imxClient.Transfer(ctx, l1signer, l2signer, request)
+	if err != nil {
+		log.Printf("error calling transfer workflow: %v", err)
+		return 0, err
+	}
+
+	log.Printf("transfer ID: %v", response.TransferId)
+	return response.TransferId, nil
+}
+
+func (m *IMXMarketplace) BatchTransfer(l1signer imx.L1Signer, l2signer imx.L2Signer, transfers []NFTTransfer) ([]int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	request := api.GetSignableTransferRequest{
+		SenderEtherKey:   l1signer.GetAddress(),
+		SignableRequests: make([]api.SignableTransferDetails, 0, len(transfers)),
+	}
+	for _, transfer := range transfers {
+		request.SignableRequests = append(request.SignableRequests, api.SignableTransferDetails{
+			Amount:   "1",
+			Receiver: transfer.Receiver,
+			Token:    imx.SignableERC721Token(transfer.TokenID, transfer.ContractAddress),
+		})
+	}
+
+	response, err := imxClient.BatchNftTransfer(ctx, l1signer, l2signer, request)
+	if err != nil {
+		log.Printf("error calling batch transfer workflow: %v", err)
+		return nil, err
+	}
+
+	log.Printf("transfer IDs: %v", response.TransferIds)
+	return response.TransferIds, nil
+}
+
+func (m *IMXMarketplace) Deposit(l1signer imx.L1Signer, cur *currency.Currency, amount *big.Int) error {
+	ctx, imxClient := m.ctx, m.client
+
+	// imx.NewETHDeposit and imx.NewERC20Deposit only take uint64 amounts
+	var deposit interface {
+		Deposit(ctx context.Context, c *imx.Client, l1signer imx.L1Signer, overrides *bind.TransactOpts) (*types.Transaction, error)
+	}
+	if cur.IsETH() {
+		deposit = &imx.ETHDeposit{Amount: amount.String()}
+	} else {
+		deposit = &imx.ERC20Deposit{Amount: amount.String(), TokenAddress: cur.Address}
+	}
+	transaction, err := deposit.Deposit(ctx, imxClient, l1signer, nil)
+	if err != nil {
+		log.Printf("%s deposit failure: %v", cur.Symbol, err)
+		return err
+	}
+
+	log.Printf("%s deposit transaction hash: %v", cur.Symbol, transaction.Hash())
+	return nil
+}
+
+func (m *IMXMarketplace) WithdrawPrepare(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency, amount *big.Int) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	withdrawRequest := api.GetSignableWithdrawalRequest{
+		Amount: amount.String(),
+		Token:  signableToken(cur),
+	}
+
+	response, err := imxClient.PrepareWithdrawal(ctx, l1signer, l2signer, withdrawRequest)
+	if err != nil {
+		log.Printf("error calling PrepareWithdrawal in IMX: %v", err)
+		return 0, err
+	}
+
+	return response.WithdrawalId, nil
+}
+
+func (m *IMXMarketplace) WithdrawGetState(withdrawID int32) (string, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	withdrawState, err := imxClient.GetWithdrawal(ctx, strconv.FormatInt(int64(withdrawID), 10))
+	if err != nil {
+		log.Printf("error calling GetWithdrawal in IMX: %v", err)
+		return "", err
+	}
+
+	if withdrawState.WithdrawnToWallet {
+		return "withdrawn", nil
+	}
+	return withdrawState.RollupStatus, nil
+}
+
+// NOTE: this should be called only after WithdrawGetState function returns "confirmed" (as per IMX documentation)
+func (m *IMXMarketplace) WithdrawFinalize(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency) (string, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	var withdrawal interface {
+		CompleteWithdrawal(ctx context.Context, c *imx.Client, l1signer imx.L1Signer, starkKeyHex string, overrides *bind.TransactOpts) (*types.Transaction, error)
+	}
+	if cur.IsETH() {
+		withdrawal = imx.NewEthWithdrawal()
+	} else {
+		withdrawal = imx.NewERC20Withdrawal(cur.Address)
+	}
+	transaction, err := withdrawal.CompleteWithdrawal(ctx, imxClient, l1signer, l2signer.GetAddress(), nil)
+	if err != nil {
+		log.Printf("error calling CompleteWithdrawal of %s in IMX: %v", cur.Symbol, err)
+		return "", err
+	}
+
+	log.Printf("%s withdraw transaction hash: %v", cur.Symbol, transaction.Hash())
+	return transaction.Hash().Hex(), nil
+}
+
+func (m *IMXMarketplace) CreateProject(l1signer imx.L1Signer, name string, companyName string, contactEmail string) (int32, error) {
+	ctx, imxClient := m.ctx, m.client
+
+	response, err := imxClient.CreateProject(ctx, l1signer, name, companyName, contactEmail)
+	if err != nil {
+		log.Printf("error in IMX CreateProject: %v", err)
+		return 0, err
+	}
+
+	log.Printf("project ID: %v", response.Id)
+	return response.Id, nil
+}
+
+// optional returns nil for empty strings, which IMX would store as is
+func optional(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func (m *IMXMarketplace) CreateCollection(l1signer imx.L1Signer, ownerPublicKey string, collection *storage.Collection) error {
+	ctx, imxClient := m.ctx, m.client
+
+	request := api.NewCreateCollectionRequest(collection.ContractAddress, collection.Name, ownerPublicKey, collection.ProjectID)
+	request.Description = optional(collection.Description)
+	request.IconUrl = optional(collection.IconURL)
+	request.CollectionImageUrl = optional(collection.ImageURL)
+	request.MetadataApiUrl = optional(collection.MetadataAPIURL)
+
+	_, err := imxClient.CreateCollection(ctx, l1signer, request)
+	if err != nil {
+		existing, getErr := imxClient.GetCollection(ctx, collection.ContractAddress)
+		if getErr == nil && existing.ProjectId == collection.ProjectID {
+			log.Printf("IMX collection %v exists in project %v already", collection.ContractAddress, collection.ProjectID)
+			return nil
+		}
+		log.Printf("error in IMX CreateCollection: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (m *IMXMarketplace) UpdateCollection(l1signer imx.L1Signer, collection *storage.Collection) error {
+	ctx, imxClient := m.ctx, m.client
+
+	request := api.NewUpdateCollectionRequest()
+	request.Name = &collection.Name
+	request.Description = &collection.Description
+	request.IconUrl = &collection.IconURL
+	request.CollectionImageUrl = &collection.ImageURL
+	request.MetadataApiUrl = &collection.MetadataAPIURL
+
+	_, err := imxClient.UpdateCollection(ctx, l1signer, collection.ContractAddress, request)
+	if err != nil {
+		log.Printf("error in IMX UpdateCollection: %v", err)
+		return err
+	}
+	return nil
+}
+
+func (m *IMXMarketplace) AddMetadataSchema(l1signer imx.L1Signer, contractAddress string, fields []storage.MetadataField) error {
+	ctx, imxClient := m.ctx, m.client
+
+	schema := make([]api.MetadataSchemaRequest, 0, len(fields))
+	for _, field := range fields {
+		field := field
+		schema = append(schema, api.MetadataSchemaRequest{
+			Name:       field.Name,
+			Type:       &field.Type,
+			Filterable: &field.Filterable,
+		})
+	}
+
+	_, err := imxClient.AddMetadataSchemaToCollection(ctx, l1signer, contractAddress, *api.NewAddMetadataSchemaToCollectionRequest(schema))
+	if err != nil {
+		if existing, getErr := imxClient.GetMetadataSchema(ctx, contractAddress); getErr == nil && schemaHasFields(existing, fields) {
+			log.Printf("IMX collection %v has the metadata fields already", contractAddress)
+			return nil
+		}
+		log.Printf("error in IMX AddMetadataSchemaToCollection: %v", err)
+		return err
+	}
+	return nil
+}
+
+func schemaHasFields(schema []api.MetadataSchemaProperty, fields []storage.MetadataField) bool {
+	names := make(map[string]bool)
+	for _, property := range schema {
+		names[property.Name] = true
+	}
+	for _, field := range fields {
+		if !names[field.Name] {
+			return false
+		}
+	}
+	return true
+}
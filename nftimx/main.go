@@ -4,331 +4,185 @@ import (
 	"context"
 	"github.com/immutable/imx-core-sdk-golang/imx"
 	"github.com/immutable/imx-core-sdk-golang/imx/api"
-	"github.com/immutable/imx-core-sdk-golang/imx/signers/ethereum"
-	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
 	"log"
 	"math/big"
-	"strconv"
+	"nft-market/config"
+	"nft-market/currency"
+	"nft-market/storage"
 )
 
-func Connect() (context.Context, imx.Config, *imx.Client) {
-	ctx := context.TODO()
-	imxConfig := api.NewConfiguration()
-	cfg := imx.Config{
-		APIConfig:     imxConfig,
-		AlchemyAPIKey: "WmzAboIrYGOEDnuUJnxQ8ucuu3jfoR_q",
-		Environment:   imx.Sandbox,
-	}
-
-	imxClient, err := imx.NewClient(&cfg)
-	if err != nil {
-		log.Printf("failed to create imx client: %v\n", err)
-		return nil, cfg, nil
+// MockChainID is the chain of the mock API of package imxmock, it answers
+// the Ethereum JSON-RPC calls made by imx.NewClient for this chain.
+var MockChainID = big.NewInt(1337)
+
+// MockEnvironment points an imx.Client at a mock API listening on baseURL,
+// both for the REST API and for the Ethereum JSON-RPC calls. The contract
+// addresses only have to look valid, the mock answers eth_getCode for any.
+func MockEnvironment(baseURL string) imx.Environment {
+	return imx.Environment{
+		BaseAPIPath:                 baseURL,
+		EthereumRPC:                 baseURL + "/rpc/",
+		RegistrationContractAddress: "0x1C97Ada273C9A52253f463042f29117090Cd7D83",
+		CoreContractAddress:         "0x7917eDb51ecD6CdB3F9854c3cc593F33de10c623",
+		ChainID:                     MockChainID,
 	}
-
-	return ctx, cfg, imxClient
 }
 
-func Register(userPrivateKey string, l2signer *stark.Signer, email string) string {
-	return ""
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return ""
+// Environment returns the ImmutableX environment selected by cfg, or the
+// mock API at cfg.APIURL when set.
+func Environment(cfg config.Marketplace) imx.Environment {
+	if cfg.APIURL != "" {
+		return MockEnvironment(cfg.APIURL)
 	}
-
-	imxres, err := imxClient.RegisterOffchain(ctx, l1signer, l2signer, email)
-	if err != nil {
-		log.Printf("failed to register user in ImmutableX: %v\n", err)
-		return ""
+	if cfg.Environment == "mainnet" {
+		return imx.Mainnet
 	}
-
-	return imxres.TxHash
+	return imx.Sandbox
 }
 
-func Mint(userPrivateKey string, userAddress string, contractAddress string, tokenID string, tokenMetadata string) string {
-	return "0"
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return ""
-	}
-
-	var royaltyPercentage float32 = 10
-	var newToken = imx.UnsignedMintRequest{
-		ContractAddress: contractAddress,
-		Royalties: []imx.MintFee{
-			{
-				Percentage: royaltyPercentage,
-				Recipient:  userAddress,
-			},
-		},
-		Users: []imx.User{
-			{
-				User: userAddress,
-				Tokens: []imx.MintableTokenData{
-					{
-						ID: tokenID,
-						Royalties: []imx.MintFee{
-							{
-								Percentage: royaltyPercentage,
-								Recipient:  userAddress,
-							},
-						},
-						Blueprint: &tokenMetadata,
-					},
-				},
-			},
-		},
-	}
-
-	req := make([]imx.UnsignedMintRequest, 1)
-	req[0] = newToken
-
-	imxres, err := imxClient.Mint(ctx, l1signer, req)
-	if err != nil {
-		log.Printf("error in IMX Mint: %v\n", err)
-		return ""
-	}
-
-	res := imxres.GetResults()
-	return res[0].TokenId
+// ChainID is the Ethereum chain the L1 signers have to sign transactions for.
+func ChainID(cfg config.Marketplace) *big.Int {
+	return Environment(cfg).ChainID
 }
 
-func Sell(userPrivateKey string, userAddress string, starkPrivateKeyStr string, contractAddress string, tokenID string, amount imx.Wei) (int32, error) {
-	return 0, nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return 0, err
-	}
-
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return 0, err
-	}
-
-	sellToken := imx.SignableERC721Token(tokenID, contractAddress)
-	buyToken := imx.SignableETHToken()
-	createOrderRequest := &api.GetSignableOrderRequest{
-		AmountBuy:  strconv.FormatUint(amount, 10),
-		AmountSell: "1",
-		Fees:       nil,
-		TokenBuy:   buyToken,
-		TokenSell:  sellToken,
-		User:       userAddress,
+func Connect(cfg config.Marketplace) (context.Context, imx.Config, *imx.Client) {
+	ctx := context.TODO()
+	imxConfig := api.NewConfiguration()
+	imxCfg := imx.Config{
+		APIConfig:     imxConfig,
+		AlchemyAPIKey: cfg.AlchemyAPIKey,
+		Environment:   Environment(cfg),
 	}
-	createOrderRequest.SetExpirationTimestamp(0)
 
-	createOrderResponse, err := imxClient.CreateOrder(ctx, l1signer, l2signer, createOrderRequest)
+	imxClient, err := imx.NewClient(&imxCfg)
 	if err != nil {
-		log.Printf("error in IMX CreateOrder: %v", err)
-		return 0, err
+		log.Printf("failed to create imx client: %v\n", err)
+		return nil, imxCfg, nil
 	}
 
-	log.Printf("CreateOrder ID: %v", createOrderResponse.OrderId)
-	return createOrderResponse.OrderId, nil
+	return ctx, imxCfg, imxClient
 }
 
-func CancelSale(userPrivateKey string, starkPrivateKeyStr string, saleID string) (int32, error) {
-	return 0, nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return 0, err
-	}
-
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return 0, err
-	}
-
-	id, _ := strconv.ParseInt(saleID, 10, 32)
-	cancelOrderRequest := api.GetSignableCancelOrderRequest{
-		OrderId: int32(id),
-	}
-
-	cancelOrderResponse, err := imxClient.CancelOrder(ctx, l1signer, l2signer, cancelOrderRequest)
-	if err != nil {
-		log.Printf("error in IMX CancelOrder: %v", err)
-		return 0, err
-	}
-
-	log.Printf("cancelled selling for ID: %v", cancelOrderResponse.OrderId)
-	return cancelOrderResponse.OrderId, nil
+// NFTTransfer is one ERC-721 token of a batch transfer and its receiver.
+type NFTTransfer struct {
+	Receiver        string
+	ContractAddress string
+	TokenID         string
 }
 
-func Buy(userPrivateKey string, starkPrivateKeyStr string, saleID string) (int32, error) {
-	return 0, nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return 0, err
-	}
-
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return 0, err
-	}
-
-	id, _ := strconv.ParseInt(saleID, 10, 64)
-	tradeRequest := api.GetSignableTradeRequest{
-		Fees:    nil,
-		OrderId: int32(id),
-	}
-	tradeRequest.SetExpirationTimestamp(0)
-	tradeResponse, err := imxClient.CreateTrade(ctx, l1signer, l2signer, tradeRequest)
-	if err != nil {
-		log.Printf("error in IMX CreateTrade: %v", err)
-		return 0, err
-	}
-
-	log.Printf("trade ID: %v", tradeResponse.TradeId)
-	return tradeResponse.TradeId, nil
+// Marketplace is the L2 exchange the market registers collections with and
+// settles mints, orders, trades, transfers, deposits and withdrawals on.
+type Marketplace interface {
+	// Register registers the user's Stark key off-chain and returns the
+	// transaction hash
+	Register(l1signer imx.L1Signer, l2signer imx.L2Signer, email string) (string, error)
+	// Registered tells whether the L1 address is registered
+	Registered(address string) (bool, error)
+	Mint(l1signer imx.L1Signer, userAddress string, contractAddress string, tokenID string, tokenMetadata string) (string, error)
+	// Sell lists the token for amount of the currency cur
+	Sell(l1signer imx.L1Signer, l2signer imx.L2Signer, userAddress string, contractAddress string, tokenID string, cur *currency.Currency, amount *big.Int) (int32, error)
+	CancelSale(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error)
+	Buy(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error)
+	// Transfer moves the ERC-721 token to the L1 address receiver
+	Transfer(l1signer imx.L1Signer, l2signer imx.L2Signer, receiver string, contractAddress string, tokenID string) (int32, error)
+	// BatchTransfer moves several ERC-721 tokens in a single request, either
+	// all of them or none, and returns the transfer IDs in the same order
+	BatchTransfer(l1signer imx.L1Signer, l2signer imx.L2Signer, transfers []NFTTransfer) ([]int32, error)
+	// Deposit, WithdrawPrepare and Sell take amounts in base units of the
+	// currency, wei for ETH
+	Deposit(l1signer imx.L1Signer, cur *currency.Currency, amount *big.Int) error
+	WithdrawPrepare(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency, amount *big.Int) (int32, error)
+	// WithdrawGetState returns the rollup status of the withdrawal, or
+	// "withdrawn" once it has been completed on L1.
+	WithdrawGetState(withdrawID int32) (string, error)
+	// WithdrawFinalize completes all confirmed withdrawals of the currency
+	// cur of the user on L1 and returns the transaction hash.
+	// NOTE: this should be called only after WithdrawGetState function returns "confirmed" (as per IMX documentation)
+	WithdrawFinalize(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency) (string, error)
+	// CreateProject returns the ID of the new project, collections have to
+	// be created in a project
+	CreateProject(l1signer imx.L1Signer, name string, companyName string, contactEmail string) (int32, error)
+	// CreateCollection registers the collection's contract in its project,
+	// ownerPublicKey is the L1 public key of the contract owner. It succeeds
+	// if the collection exists in the project already, so that a creation
+	// that couldn't be stored locally can be retried.
+	CreateCollection(l1signer imx.L1Signer, ownerPublicKey string, collection *storage.Collection) error
+	// UpdateCollection sets the name, description and URLs of the collection
+	UpdateCollection(l1signer imx.L1Signer, collection *storage.Collection) error
+	// AddMetadataSchema adds fields to the metadata schema of the collection,
+	// existing fields can't be changed this way. Adding fields that all
+	// exist already succeeds, for retries as well.
+	AddMetadataSchema(l1signer imx.L1Signer, contractAddress string, fields []storage.MetadataField) error
 }
 
-func Transfer(userPrivateKey string, starkPrivateKeyStr string, receiver string) (int32, error) {
-	return 0, nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return 0, err
-	}
-
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return 0, err
-	}
-
-	request := api.GetSignableTransferRequestV1{
-		Amount:   "1",
-		Sender:   l1signer.GetAddress(),
-		Token:    imx.SignableETHToken(),
-		Receiver: receiver,
-	}
+var market Marketplace
 
-	response, err := imxClient.Transfer(ctx, l1signer, l2signer, request)
-	if err != nil {
-		log.Printf("error calling transfer workflow: %v", err)
-		return 0, err
-	}
+// SetMarketplace selects the marketplace used by the package level functions.
+// It is expected to be called once on startup, before serving any requests.
+func SetMarketplace(m Marketplace) {
+	market = m
+}
 
-	log.Printf("trade ID: %v", response.TransferId)
-	return response.TransferId, nil
+func Register(l1signer imx.L1Signer, l2signer imx.L2Signer, email string) (string, error) {
+	return market.Register(l1signer, l2signer, email)
 }
 
-func Deposit(userPrivateKey string, amount string) error {
-	return nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return err
-	}
+func Registered(address string) (bool, error) {
+	return market.Registered(address)
+}
 
-	ethAmountInWei, err := strconv.ParseUint(amount, 10, 64)
-	if err != nil {
-		log.Printf("error in converting ethAmountInWei from string to int: %v\n", err)
-		return err
-	}
+func Mint(l1signer imx.L1Signer, userAddress string, contractAddress string, tokenID string, tokenMetadata string) (string, error) {
+	return market.Mint(l1signer, userAddress, contractAddress, tokenID, tokenMetadata)
+}
 
-	transaction, err := imx.NewETHDeposit(ethAmountInWei).Deposit(ctx, imxClient, l1signer, nil)
-	if err != nil {
-		log.Printf("Eth deposit failure: %v", err)
-		return err
-	}
+func Sell(l1signer imx.L1Signer, l2signer imx.L2Signer, userAddress string, contractAddress string, tokenID string, cur *currency.Currency, amount *big.Int) (int32, error) {
+	return market.Sell(l1signer, l2signer, userAddress, contractAddress, tokenID, cur, amount)
+}
 
-	log.Println("Eth Deposit transaction hash:", transaction.Hash())
-	return nil
+func CancelSale(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	return market.CancelSale(l1signer, l2signer, saleID)
 }
 
-func WithdrawPrepare(userPrivateKey string, starkPrivateKeyStr string, amount string) (int32, error) {
-	return 0, nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return 0, err
-	}
+func Buy(l1signer imx.L1Signer, l2signer imx.L2Signer, saleID string) (int32, error) {
+	return market.Buy(l1signer, l2signer, saleID)
+}
 
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return 0, err
-	}
+func Transfer(l1signer imx.L1Signer, l2signer imx.L2Signer, receiver string, contractAddress string, tokenID string) (int32, error) {
+	return market.Transfer(l1signer, l2signer, receiver, contractAddress, tokenID)
+}
 
-	withdrawRequest := api.GetSignableWithdrawalRequest{
-		Amount: amount,
-		Token:  imx.SignableETHToken(),
-	}
+func BatchTransfer(l1signer imx.L1Signer, l2signer imx.L2Signer, transfers []NFTTransfer) ([]int32, error) {
+	return market.BatchTransfer(l1signer, l2signer, transfers)
+}
 
-	response, err := imxClient.PrepareWithdrawal(ctx, l1signer, l2signer, withdrawRequest)
-	if err != nil {
-		log.Printf("error calling PrepareWithdrawal in IMX: %v", err)
-		return 0, err
-	}
+func Deposit(l1signer imx.L1Signer, cur *currency.Currency, amount *big.Int) error {
+	return market.Deposit(l1signer, cur, amount)
+}
 
-	return response.WithdrawalId, nil
+func WithdrawPrepare(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency, amount *big.Int) (int32, error) {
+	return market.WithdrawPrepare(l1signer, l2signer, cur, amount)
 }
 
 func WithdrawGetState(withdrawID int32) (string, error) {
-	return "", nil
-	ctx, _, imxClient := Connect()
-
-	withdrawState, err := imxClient.GetWithdrawal(ctx, strconv.FormatInt(int64(withdrawID), 10))
-	if err != nil {
-		log.Printf("error calling GetWithdrawal in IMX: %v", err)
-		return "", err
-	}
+	return market.WithdrawGetState(withdrawID)
+}
 
-	return withdrawState.RollupStatus, nil
+func WithdrawFinalize(l1signer imx.L1Signer, l2signer imx.L2Signer, cur *currency.Currency) (string, error) {
+	return market.WithdrawFinalize(l1signer, l2signer, cur)
 }
 
-// NOTE: this should be called only after WithdrawGetState function returns "confirmed" (as per IMX documentation)
-func WithdrawFinalize(userPrivateKey string, starkPrivateKeyStr string) error {
-	return nil
-	ctx, cfg, imxClient := Connect()
-	l1signer, err := ethereum.NewSigner(userPrivateKey, cfg.ChainID)
-	if err != nil {
-		log.Printf("failed to create L1Signer: %v\n", err)
-		return err
-	}
+func CreateProject(l1signer imx.L1Signer, name string, companyName string, contactEmail string) (int32, error) {
+	return market.CreateProject(l1signer, name, companyName, contactEmail)
+}
 
-	starkPrivateKey := new(big.Int)
-	starkPrivateKey.SetString(starkPrivateKeyStr, 16)
-	l2signer, err := stark.NewSigner(starkPrivateKey)
-	if err != nil {
-		log.Printf("error in creating StarkSigner: %v\n", err)
-		return err
-	}
+func CreateCollection(l1signer imx.L1Signer, ownerPublicKey string, collection *storage.Collection) error {
+	return market.CreateCollection(l1signer, ownerPublicKey, collection)
+}
 
-	ethWithdrawal := imx.NewEthWithdrawal()
-	transaction, err := ethWithdrawal.CompleteWithdrawal(ctx, imxClient, l1signer, l2signer.GetAddress(), nil)
-	if err != nil {
-		log.Panicf("error calling CompleteEthWithdrawal in IMX: %v", err)
-		return err
-	}
+func UpdateCollection(l1signer imx.L1Signer, collection *storage.Collection) error {
+	return market.UpdateCollection(l1signer, collection)
+}
 
-	log.Println("Eth withdraw transaction hash:", transaction.Hash())
-	return nil
+func AddMetadataSchema(l1signer imx.L1Signer, contractAddress string, fields []storage.MetadataField) error {
+	return market.AddMetadataSchema(l1signer, contractAddress, fields)
 }
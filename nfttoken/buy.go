@@ -3,7 +3,11 @@ package nfttoken
 import (
 	"errors"
 	"nft-market/nftimx"
+	"nft-market/nftuser"
+	"nft-market/signer"
 	"nft-market/storage"
+	"nft-market/validation"
+	"strconv"
 )
 
 type tokenBuyRequest struct {
@@ -15,45 +19,54 @@ type tokenBuyResponse struct {
 	BuyID string   `json:"buy_id,omitempty"`
 	Error string   `json:"error,omitempty"`
 	List  []string `json:"list,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
+// without a token ID the request lists the tokens on sale
 func verifyTokenBuyRequest(req *tokenBuyRequest) error {
-	// TODO: verify formatting
-	if len(req.TokenID) > 64 {
-		return errors.New("invalid token ID")
-	}
-	return nil
+	var v validation.Validator
+	v.Optional("collection_id", req.CollectionID, validation.CollectionID)
+	v.Optional("token_id", req.TokenID, validation.TokenID)
+	return v.Err()
 }
 
 func tokenBuy(userid string, req *tokenBuyRequest, res *tokenBuyResponse) error {
 	if err := verifyTokenBuyRequest(req); err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
 	if req.TokenID == "" {
-		err := storage.GetTokenSellingList(userid, res.List)
+		list, err := storage.GetTokenSellingList(userid)
 		if err != nil {
 			res.Error = err.Error()
 			return err
 		}
+		res.List = list
 		return nil
 	}
 
+	if err := nftuser.VerifyRegistered(userid); err != nil {
+		res.Error = err.Error()
+		return err
+	}
+
 	if !storage.TokenSelling(userid, req.TokenID) {
 		// TODO: verify that token is selling by userid
 		res.Error = "token is not on sale"
 		return errors.New(res.Error)
 	}
 
-	privateKey, err := storage.GetUserPrivateKey(userid)
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
-	starkKey, err := storage.GetUserStarkPrivateKey(userid)
+	l2signer, err := signer.L2Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user stark signer")
 		return err
 	}
 	sellingID, err := storage.GetTokenSellingID(req.TokenID)
@@ -62,21 +75,27 @@ func tokenBuy(userid string, req *tokenBuyRequest, res *tokenBuyResponse) error
 		return err
 	}
 
+	seller, err := storage.GetTokenOwner(req.TokenID)
+	if err != nil {
+		res.Error = "failed to get token owner"
+		return err
+	}
+
 	err = storage.MoveToken(req.TokenID, userid)
 	if err != nil {
 		res.Error = "failed to transfer token to new owner"
 		return err
 	}
 
-	buyID, err := nftimx.Buy(string(privateKey), string(starkKey), string(sellingID))
+	buyID, err := nftimx.Buy(l1signer, l2signer, string(sellingID))
 	if err != nil {
 		// move token back to old owner
-		_ = storage.MoveToken(req.TokenID, userid)
+		_ = storage.MoveToken(req.TokenID, string(seller))
 		res.Error = "failed to create buy trade on IMX"
 		return err
 	}
 
-	tokenMarkSelling(userid, req.TokenID, "-1")
-	res.BuyID = string(buyID)
+	tokenMarkSelling(userid, req.TokenID, "-1", nil, "")
+	res.BuyID = strconv.FormatInt(int64(buyID), 10)
 	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
+	"nft-market/auth"
 	"nft-market/nftuser"
 	"nft-market/storage"
 )
@@ -34,6 +35,12 @@ func Token(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	userid, err := auth.Identify(c, req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	req.UserID = userid
+
 	if err := nftuser.VerifyUserID(req.UserID); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
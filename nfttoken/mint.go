@@ -4,9 +4,11 @@ import (
 	"errors"
 	"github.com/holiman/uint256"
 	"log"
+	"nft-market/nftcollection"
 	"nft-market/nftimx"
+	"nft-market/signer"
 	"nft-market/storage"
-	"os"
+	"nft-market/validation"
 )
 
 type tokenMintRequest struct {
@@ -19,22 +21,23 @@ type tokenMintResponse struct {
 	MintID  string `json:"mint_id,omitempty"`
 	TokenID string `json:"token_id,omitempty"`
 	Error   string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request, or the metadata fields
+	// not matching the collection schema
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
 func verifyTokenMintRequest(req *tokenMintRequest) error {
-	// TODO: verify formatting
-	if req.CollectionID == "" {
-		return errors.New("collection ID missing")
+	var v validation.Validator
+	if v.Required("collection_id", req.CollectionID) {
+		v.Check("collection_id", validation.CollectionID(req.CollectionID))
 	}
-	return nil
+	v.Optional("token_id", req.TokenID, validation.TokenID)
+	return v.Err()
 }
 
 func tokenReserved(userid string, tokenid string) bool {
 	// TODO: check if reserved for userid
-	if _, err := os.Stat(storage.Prefix + storage.TokenDir + tokenid); err != nil {
-		return false
-	}
-	return true
+	return storage.TokenExists(tokenid)
 }
 
 func tokenSaveReservation(userid string, collectionID string, tokenID *uint256.Int) error {
@@ -45,8 +48,6 @@ func tokenSaveReservation(userid string, collectionID string, tokenID *uint256.I
 		return errors.New("failed to reserve token")
 	}
 
-	// TODO: here parallel request from another user theoretically could hijack the reservation
-
 	err = storage.SetTokenOwner(res, userid)
 	if err != nil {
 		storage.RemoveToken(userid, collectionID, res)
@@ -59,23 +60,16 @@ func tokenSaveReservation(userid string, collectionID string, tokenID *uint256.I
 		return errors.New("failed to reserve token (writing collection id)")
 	}
 
-	err = storage.SetTokenIndex(tokenID)
-	if err != nil {
-		storage.RemoveToken(userid, collectionID, res)
-		return errors.New("failed to reserve token (writing index)")
-	}
-
 	return nil
 }
 
 func tokenReserve(userid string, collectionID string) (string, error) {
-	tokenID := uint256.NewInt(1)
-
-	if err := storage.GetTokenIndex(tokenID); err == nil {
-		tokenID = new(uint256.Int).Add(tokenID, uint256.NewInt(1))
+	tokenID, err := storage.NextTokenIndex()
+	if err != nil {
+		return "", errors.New("failed to allocate token ID")
 	}
 
-	if err := tokenSaveReservation(userid, collectionID, tokenID); err != nil {
+	if err = tokenSaveReservation(userid, collectionID, tokenID); err != nil {
 		return "", err
 	}
 
@@ -87,6 +81,7 @@ func tokenReserve(userid string, collectionID string) (string, error) {
 func tokenMint(userid string, req *tokenMintRequest, res *tokenMintResponse) error {
 	if err := verifyTokenMintRequest(req); err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
@@ -95,19 +90,14 @@ func tokenMint(userid string, req *tokenMintRequest, res *tokenMintResponse) err
 		return errors.New(res.Error)
 	}
 
-	if !storage.CollectionExists(userid, req.CollectionID) {
-		res.Error = "collection " + req.CollectionID + " doesn't exist"
-		return errors.New(res.Error)
-	}
-
-	collectionContractAddress, err := storage.GetUserCollectionContractAddress(userid, req.CollectionID)
+	collection, err := storage.GetUserCollection(userid, req.CollectionID)
 	if err != nil {
-		res.Error = "failed to read collection contract address"
+		res.Error = "collection " + req.CollectionID + " doesn't exist"
 		return err
 	}
-	privateKey, err := storage.GetUserPrivateKey(userid)
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
 	userAddress, err := storage.GetUserAddress(userid)
@@ -118,7 +108,12 @@ func tokenMint(userid string, req *tokenMintRequest, res *tokenMintResponse) err
 
 	if req.TokenID == "" {
 		log.Printf("reserving token\n")
-		res.TokenID, _ = tokenReserve(userid, req.CollectionID)
+		tokenID, err := tokenReserve(userid, req.CollectionID)
+		if err != nil {
+			res.Error = err.Error()
+			return err
+		}
+		res.TokenID = tokenID
 		return nil
 	}
 
@@ -127,9 +122,20 @@ func tokenMint(userid string, req *tokenMintRequest, res *tokenMintResponse) err
 		return err
 	}
 
+	if errs := nftcollection.ValidateMetadata(collection.MetadataSchema, req.Metadata); len(errs) > 0 {
+		res.Error = "metadata doesn't match the collection schema"
+		res.Errors = errs
+		return errors.New(res.Error)
+	}
+
 	// TODO: verify if token is reserved by userid
-	imxTokenID := nftimx.Mint(string(privateKey), string(userAddress), string(collectionContractAddress), req.TokenID, req.Metadata)
+	imxTokenID, err := nftimx.Mint(l1signer, string(userAddress), collection.ContractAddress, req.TokenID, req.Metadata)
+	if err != nil {
+		res.Error = "failed to mint token on IMX"
+		return err
+	}
 	_ = storage.SetTokenMintedID(userid, req.TokenID, imxTokenID)
+	_ = storage.SetTokenMetadata(req.TokenID, []byte(req.Metadata))
 	res.MintID = imxTokenID
 	return nil
 }
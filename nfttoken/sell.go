@@ -2,56 +2,80 @@ package nfttoken
 
 import (
 	"errors"
+	"nft-market/currency"
 	"nft-market/nftimx"
+	"nft-market/nftuser"
+	"nft-market/signer"
 	"nft-market/storage"
-	"os"
+	"nft-market/validation"
 	"strconv"
 )
 
 type tokenSellRequest struct {
 	CollectionID string `json:"collection_id"`
 	TokenID      string `json:"token_id"`
-	Price        string `json:"price"`
-	SellingID    string `json:"selling_id,omitempty"`
+	// Price is in the currency given by symbol or contract address, ETH by
+	// default: in base units of the currency without a unit, wei for ETH,
+	// else in the unit following it, e.g. "1.25 ETH" or "20 USDC"
+	Price     string `json:"price"`
+	Currency  string `json:"currency,omitempty"`
+	SellingID string `json:"selling_id,omitempty"`
 }
 
+// Price is in base units of the currency, PriceDecimal in whole tokens
 type tokenSellResponse struct {
-	SellID string `json:"sell_id,omitempty"`
-	Error  string `json:"error,omitempty"`
+	SellID       string `json:"sell_id,omitempty"`
+	Currency     string `json:"currency,omitempty"`
+	Price        string `json:"price,omitempty"`
+	PriceDecimal string `json:"price_decimal,omitempty"`
+	Error        string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
+// with a selling ID the request cancels that sell order
 func verifyTokenSellRequest(req *tokenSellRequest) error {
-	// TODO: verify formatting
+	var v validation.Validator
 	if req.SellingID != "" {
-		return nil
+		v.Check("selling_id", validation.ID(req.SellingID))
+		v.Optional("collection_id", req.CollectionID, validation.CollectionID)
+		v.Optional("token_id", req.TokenID, validation.TokenID)
+		return v.Err()
 	}
 
-	if req.CollectionID == "" {
-		return errors.New("collection ID missing")
+	if v.Required("collection_id", req.CollectionID) {
+		v.Check("collection_id", validation.CollectionID(req.CollectionID))
 	}
-	if req.TokenID == "" {
-		return errors.New("token ID missing")
+	if v.Required("token_id", req.TokenID) {
+		v.Check("token_id", validation.TokenID(req.TokenID))
 	}
-	if req.Price == "" {
-		return errors.New("price is missing")
+	cur, err := currency.Lookup(req.Currency)
+	v.Check("currency", err)
+	if v.Required("price", req.Price) && cur != nil {
+		v.Check("price", validation.Amount(cur, req.Price))
 	}
-
-	return nil
+	return v.Err()
 }
 
-func tokenMarkSelling(userid string, tokenid string, sellingID string) bool {
-	path := storage.TokenDir + tokenid + "/selling"
-
+func tokenMarkSelling(userid string, tokenid string, sellingID string, cur *currency.Currency, price string) bool {
 	if sellingID == "-1" {
 		// cancelling sell order
-		err := os.Remove(storage.Prefix + path)
+		err := storage.RemoveTokenSelling(tokenid)
 		if err != nil {
 			return false
 		}
 		return true
 	}
 
-	err := os.WriteFile(storage.Prefix+path, []byte(sellingID), 0644)
+	err := storage.SetTokenSellingID(tokenid, sellingID)
+	if err != nil {
+		return false
+	}
+	err = storage.SetTokenSellingPrice(tokenid, price)
+	if err != nil {
+		return false
+	}
+	err = storage.SetTokenSellingCurrency(tokenid, cur.Key())
 	if err != nil {
 		return false
 	}
@@ -61,12 +85,26 @@ func tokenMarkSelling(userid string, tokenid string, sellingID string) bool {
 
 func tokenSell(userid string, req *tokenSellRequest, res *tokenSellResponse) error {
 	if err := verifyTokenSellRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	if err := nftuser.VerifyRegistered(userid); err != nil {
 		res.Error = err.Error()
 		return err
 	}
 
-	if !storage.CollectionExists(userid, req.CollectionID) {
-		res.Error = "collection " + req.CollectionID + " doesn't exist"
+	// tokens bought or received from other users are sold too, so the
+	// collection isn't necessarily the user's
+	owner, err := storage.GetTokenOwner(req.TokenID)
+	if err != nil || string(owner) != userid {
+		res.Error = "token " + req.TokenID + " doesn't exist"
+		return errors.New(res.Error)
+	}
+	collectionID, err := storage.GetTokenCollection(req.TokenID)
+	if err != nil || (req.CollectionID != "" && string(collectionID) != req.CollectionID) {
+		res.Error = "token " + req.TokenID + " isn't in collection " + req.CollectionID
 		return errors.New(res.Error)
 	}
 
@@ -82,14 +120,9 @@ func tokenSell(userid string, req *tokenSellRequest, res *tokenSellResponse) err
 		return errors.New(res.Error)
 	}
 
-	collectionContractAddress, err := storage.GetUserCollectionContractAddress(userid, req.CollectionID)
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to read collection contract address"
-		return err
-	}
-	privateKey, err := storage.GetUserPrivateKey(userid)
-	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
 	userAddress, err := storage.GetUserAddress(userid)
@@ -97,9 +130,9 @@ func tokenSell(userid string, req *tokenSellRequest, res *tokenSellResponse) err
 		res.Error = "failed to get user address"
 		return err
 	}
-	starkKey, err := storage.GetUserStarkPrivateKey(userid)
+	l2signer, err := signer.L2Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user stark signer")
 		return err
 	}
 	imxTokenID, err := storage.GetTokenMintedID(req.TokenID)
@@ -109,25 +142,42 @@ func tokenSell(userid string, req *tokenSellRequest, res *tokenSellResponse) err
 	}
 
 	if req.SellingID != "" {
-		sellID, err := nftimx.CancelSale(string(privateKey), string(starkKey), req.SellingID)
+		sellID, err := nftimx.CancelSale(l1signer, l2signer, req.SellingID)
 		if err != nil {
 			res.Error = "failed to cancel sell order on IMX"
 			return err
 		}
 
-		tokenMarkSelling(userid, req.TokenID, "-1")
-		res.SellID = string(sellID)
+		tokenMarkSelling(userid, req.TokenID, "-1", nil, "")
+		res.SellID = strconv.FormatInt(int64(sellID), 10)
 		return nil
 	}
 
-	listingPriceInWei, _ := strconv.ParseUint(req.Price, 10, 64)
-	sellID, err := nftimx.Sell(string(privateKey), string(userAddress), string(starkKey), string(collectionContractAddress), string(imxTokenID), listingPriceInWei)
+	collection, err := storage.FindCollection(string(collectionID))
+	if err != nil {
+		res.Error = "collection " + string(collectionID) + " doesn't exist"
+		return err
+	}
+	cur, err := currency.Lookup(req.Currency)
+	if err != nil {
+		res.Error = "invalid currency"
+		return err
+	}
+	price, err := cur.Parse(req.Price)
+	if err != nil {
+		res.Error = "invalid price"
+		return err
+	}
+	sellID, err := nftimx.Sell(l1signer, l2signer, string(userAddress), collection.ContractAddress, string(imxTokenID), cur, price)
 	if err != nil {
 		res.Error = "failed to create sell order on IMX"
 		return err
 	}
 
-	tokenMarkSelling(userid, req.TokenID, string(sellID))
-	res.SellID = string(sellID)
+	res.SellID = strconv.FormatInt(int64(sellID), 10)
+	res.Currency = cur.Symbol
+	res.Price = price.String()
+	res.PriceDecimal = cur.Format(price)
+	tokenMarkSelling(userid, req.TokenID, res.SellID, cur, price.String())
 	return nil
 }
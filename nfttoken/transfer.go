@@ -3,71 +3,204 @@ package nfttoken
 import (
 	"errors"
 	"nft-market/nftimx"
+	"nft-market/nftuser"
+	"nft-market/signer"
 	"nft-market/storage"
+	"nft-market/validation"
+	"strconv"
 )
 
-type tokenTransferRequest struct {
+// tokenTransferItem moves a token to the market user with the L1 address To
+type tokenTransferItem struct {
 	CollectionID string `json:"collection_id"`
 	TokenID      string `json:"token_id"`
 	To           string `json:"to"`
 }
 
+// the request transfers either the single token it names or every token in
+// Batch. A batch is a single IMX request, all its tokens are transferred or
+// none.
+type tokenTransferRequest struct {
+	tokenTransferItem
+	Batch []tokenTransferItem `json:"batch,omitempty"`
+}
+
+// TransferIDs are in the order of the batch
 type tokenTransferResponse struct {
-	TransferID string `json:"transfer_id,omitempty"`
-	Error      string `json:"error,omitempty"`
+	TransferID  string   `json:"transfer_id,omitempty"`
+	TransferIDs []string `json:"transfer_ids,omitempty"`
+	Error       string   `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
-func verifyTokenTransferRequest(req *tokenTransferRequest) error {
-	// TODO: verify formatting, etc.
-	if req.CollectionID == "" {
-		return errors.New("collection ID missing")
+// pendingTransfer is a transfer checked against storage, ready to be sent
+type pendingTransfer struct {
+	tokenID  string
+	receiver string
+	nft      nftimx.NFTTransfer
+}
+
+func verifyTokenTransferItem(v *validation.Validator, prefix string, item *tokenTransferItem) {
+	if v.Required(prefix+"collection_id", item.CollectionID) {
+		v.Check(prefix+"collection_id", validation.CollectionID(item.CollectionID))
 	}
-	if req.TokenID == "" {
-		return errors.New("token ID missing")
+	if v.Required(prefix+"token_id", item.TokenID) {
+		v.Check(prefix+"token_id", validation.TokenID(item.TokenID))
 	}
-	if req.To == "" {
-		return errors.New("receiver address is missing")
+	if v.Required(prefix+"to", item.To) {
+		v.Check(prefix+"to", validation.Address(item.To))
 	}
+}
 
-	return nil
+func verifyTokenTransferRequest(req *tokenTransferRequest) error {
+	var v validation.Validator
+	if len(req.Batch) == 0 {
+		verifyTokenTransferItem(&v, "", &req.tokenTransferItem)
+		return v.Err()
+	}
+
+	if req.CollectionID != "" || req.TokenID != "" || req.To != "" {
+		v.Fail("batch", "can't be combined with a single token")
+	}
+	tokens := make(map[string]bool)
+	for i := range req.Batch {
+		prefix := "batch[" + strconv.Itoa(i) + "]."
+		verifyTokenTransferItem(&v, prefix, &req.Batch[i])
+		if tokens[req.Batch[i].TokenID] {
+			v.Fail(prefix+"token_id", "is transferred twice")
+		}
+		tokens[req.Batch[i].TokenID] = true
+	}
+	return v.Err()
 }
 
-func tokenTransfer(userid string, req *tokenTransferRequest, res *tokenTransferResponse) error {
-	if err := verifyTokenTransferRequest(req); err != nil {
-		res.Error = err.Error()
-		return err
+func prepareTokenTransfer(userid string, item *tokenTransferItem) (*pendingTransfer, error) {
+	owner, err := storage.GetTokenOwner(item.TokenID)
+	if err != nil || string(owner) != userid {
+		return nil, errors.New("token " + item.TokenID + " doesn't exist")
+	}
+	collectionID, err := storage.GetTokenCollection(item.TokenID)
+	if err != nil || string(collectionID) != item.CollectionID {
+		return nil, errors.New("token " + item.TokenID + " isn't in collection " + item.CollectionID)
+	}
+	imxTokenID, err := storage.GetTokenMintedID(item.TokenID)
+	if err != nil {
+		return nil, errors.New("token " + item.TokenID + " isn't minted")
+	}
+	if storage.TokenSelling(userid, item.TokenID) {
+		return nil, errors.New("token " + item.TokenID + " is on sale, cancel first")
+	}
+	collection, err := storage.FindCollection(item.CollectionID)
+	if err != nil {
+		return nil, err
 	}
 
-	if storage.TokenSelling(userid, req.TokenID) {
-		res.Error = "token is on sale, cancel first"
-		return errors.New(res.Error)
+	receiver, err := storage.FindUserByAddress(item.To)
+	if err != nil {
+		return nil, err
+	}
+	if receiver == userid {
+		return nil, errors.New("token " + item.TokenID + " is already owned by " + item.To)
 	}
+	receiverAddress, err := storage.GetUserAddress(receiver)
+	if err != nil {
+		return nil, errors.New("failed to get receiver address")
+	}
+
+	return &pendingTransfer{
+		tokenID:  item.TokenID,
+		receiver: receiver,
+		nft: nftimx.NFTTransfer{
+			Receiver:        string(receiverAddress),
+			ContractAddress: collection.ContractAddress,
+			TokenID:         string(imxTokenID),
+		},
+	}, nil
+}
 
-	privateKey, err := storage.GetUserPrivateKey(userid)
+func tokenTransferIMX(userid string, transfers []*pendingTransfer, batch bool, res *tokenTransferResponse) error {
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
-	starkKey, err := storage.GetUserStarkPrivateKey(userid)
+	l2signer, err := signer.L2Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user stark signer")
 		return err
 	}
 
-	err = storage.MoveToken(req.TokenID, req.To)
+	if !batch {
+		nft := transfers[0].nft
+		transferID, err := nftimx.Transfer(l1signer, l2signer, nft.Receiver, nft.ContractAddress, nft.TokenID)
+		if err != nil {
+			res.Error = "failed to create transfer on IMX"
+			return err
+		}
+		res.TransferID = strconv.FormatInt(int64(transferID), 10)
+		return nil
+	}
+
+	nfts := make([]nftimx.NFTTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		nfts = append(nfts, transfer.nft)
+	}
+	transferIDs, err := nftimx.BatchTransfer(l1signer, l2signer, nfts)
 	if err != nil {
-		res.Error = "failed to transfer token to new owner"
+		res.Error = "failed to create batch transfer on IMX"
 		return err
 	}
+	for _, transferID := range transferIDs {
+		res.TransferIDs = append(res.TransferIDs, strconv.FormatInt(int64(transferID), 10))
+	}
+	return nil
+}
 
-	transferID, err := nftimx.Transfer(string(privateKey), string(starkKey), req.To)
-	if err != nil {
-		// move token back to old owner
-		_ = storage.MoveToken(req.TokenID, userid)
-		res.Error = "failed to create buy trade on IMX"
+func tokenTransfer(userid string, req *tokenTransferRequest, res *tokenTransferResponse) error {
+	if err := verifyTokenTransferRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
-	res.TransferID = string(transferID)
+	if err := nftuser.VerifyRegistered(userid); err != nil {
+		res.Error = err.Error()
+		return err
+	}
+
+	items := req.Batch
+	if len(items) == 0 {
+		items = []tokenTransferItem{req.tokenTransferItem}
+	}
+	transfers := make([]*pendingTransfer, 0, len(items))
+	for i := range items {
+		transfer, err := prepareTokenTransfer(userid, &items[i])
+		if err != nil {
+			res.Error = err.Error()
+			return err
+		}
+		transfers = append(transfers, transfer)
+	}
+
+	var err error
+	moved := 0
+	for _, transfer := range transfers {
+		if err = storage.MoveToken(transfer.tokenID, transfer.receiver); err != nil {
+			res.Error = "failed to transfer token to new owner"
+			break
+		}
+		moved++
+	}
+	if err == nil {
+		err = tokenTransferIMX(userid, transfers, len(req.Batch) > 0, res)
+	}
+	if err != nil {
+		// move tokens back to old owner
+		for _, transfer := range transfers[:moved] {
+			_ = storage.MoveToken(transfer.tokenID, userid)
+		}
+		return err
+	}
 	return nil
 }
@@ -1,45 +1,75 @@
 package nftuser
 
 import (
-	"errors"
+	"nft-market/currency"
 	"nft-market/nftimx"
-	"nft-market/storage"
+	"nft-market/signer"
+	"nft-market/validation"
 )
 
+// Currency is the symbol or contract address of a configured currency,
+// ETH by default. Amounts without a unit are in base units of the currency,
+// wei for ETH, whatever it is. Amounts with a unit are decimal numbers of
+// it: an ETH unit for ETH, e.g. "0.5 ETH", the token symbol for ERC-20
+// currencies, e.g. "12.5 USDC".
 type userDepositRequest struct {
-	Amount string `json:"amount"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency,omitempty"`
 }
 
+// Amount is in base units of the currency, AmountDecimal in whole tokens
 type userDepositResponse struct {
-	TxID  string `json:"tx_id,omitempty"`
-	Error string `json:"error,omitempty"`
+	TxID          string `json:"tx_id,omitempty"`
+	Currency      string `json:"currency,omitempty"`
+	Amount        string `json:"amount,omitempty"`
+	AmountDecimal string `json:"amount_decimal,omitempty"`
+	Error         string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
 func verifyUserDepositRequest(req *userDepositRequest) error {
-	// TODO: verify formatting
-	if req.Amount == "" {
-		return errors.New("invalid deposit amount")
+	var v validation.Validator
+	cur, err := currency.Lookup(req.Currency)
+	v.Check("currency", err)
+	if v.Required("amount", req.Amount) && cur != nil {
+		v.Check("amount", validation.Amount(cur, req.Amount))
 	}
-	return nil
+	return v.Err()
 }
 
 func userDeposit(userid string, req *userDepositRequest, res *userDepositResponse) error {
 	if err := verifyUserDepositRequest(req); err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	l1signer, err := signer.L1Signer(userid)
+	if err != nil {
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
 
-	privateKey, err := storage.GetUserPrivateKey(userid)
+	cur, err := currency.Lookup(req.Currency)
+	if err != nil {
+		res.Error = "invalid deposit currency"
+		return err
+	}
+	amount, err := cur.Parse(req.Amount)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = "invalid deposit amount"
 		return err
 	}
 
-	err = nftimx.Deposit(string(privateKey), req.Amount)
+	err = nftimx.Deposit(l1signer, cur, amount)
 	if err != nil {
 		res.Error = "failed to perform IMX deposit operation"
 		return err
 	}
 
+	res.Currency = cur.Symbol
+	res.Amount = amount.String()
+	res.AmountDecimal = cur.Format(amount)
 	return nil
 }
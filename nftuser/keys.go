@@ -0,0 +1,126 @@
+package nftuser
+
+import (
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"nft-market/auth"
+	"nft-market/storage"
+	"nft-market/validation"
+)
+
+type keysRequest struct {
+	UserID string `json:"userid,omitempty"`
+	userKeysRequest
+}
+
+// with neither Create nor Revoke the request lists the user's keys. Keys can
+// only be created with scopes of the key making the request, which are the
+// default, and expire no later than it.
+type userKeysRequest struct {
+	Create *auth.KeyRequest `json:"create,omitempty"`
+	Revoke string           `json:"revoke,omitempty"`
+}
+
+// APIKey is only returned when the key is created, it can't be recovered
+type userKeysResponse struct {
+	APIKey string         `json:"api_key,omitempty"`
+	Key    *auth.KeyInfo  `json:"key,omitempty"`
+	List   []auth.KeyInfo `json:"list,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+func verifyUserKeysRequest(req *userKeysRequest, key *auth.APIKey) error {
+	var v validation.Validator
+	if req.Create != nil {
+		req.Create.Verify(&v, "create.")
+		if v.Err() == nil {
+			for _, scope := range req.Create.Scopes {
+				if !key.HasScope(scope) {
+					v.Fail("create.scopes", "has scope "+scope+" the request's key doesn't have")
+				}
+			}
+		}
+		if req.Revoke != "" {
+			v.Fail("revoke", "can't be combined with create")
+		}
+	}
+	return v.Err()
+}
+
+func userKeys(userid string, key *auth.APIKey, req *userKeysRequest, res *userKeysResponse) error {
+	if err := verifyUserKeysRequest(req, key); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	if req.Create != nil {
+		create := *req.Create
+		if len(create.Scopes) == 0 {
+			create.Scopes = key.Scopes
+		}
+		create.NotAfter = key.Expires
+		created, value, err := auth.CreateKey(userid, &create)
+		if err != nil {
+			res.Error = err.Error()
+			return err
+		}
+		info := created.Info()
+		res.APIKey = value
+		res.Key = &info
+		return nil
+	}
+
+	if req.Revoke != "" {
+		revoked, err := auth.RevokeKey(userid, req.Revoke)
+		if err != nil {
+			res.Error = err.Error()
+			return err
+		}
+		info := revoked.Info()
+		res.Key = &info
+		return nil
+	}
+
+	keys, err := auth.ListKeys(userid)
+	if err != nil {
+		res.Error = "failed to list API keys"
+		return err
+	}
+	for i := range keys {
+		res.List = append(res.List, keys[i].Info())
+	}
+	return nil
+}
+
+// Keys serves POST /keys, managing the API keys of the authenticated user
+func Keys(c echo.Context) error {
+	var req keysRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	userid, err := auth.Identify(c, req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+
+	if !storage.UserExists(userid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "user " + userid + " doesn't exist"})
+	}
+
+	var res userKeysResponse
+	if err = userKeys(userid, auth.Key(c), &req.userKeysRequest, &res); err != nil {
+		log.Printf("error managing API keys: %v", err)
+	}
+
+	pretty := c.QueryParam("pretty") == "true"
+	if pretty {
+		return c.JSONPretty(http.StatusOK, res, "    ")
+	} else {
+		return c.JSON(http.StatusOK, res)
+	}
+}
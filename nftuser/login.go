@@ -0,0 +1,276 @@
+package nftuser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/labstack/echo/v4"
+	"log"
+	"net/http"
+	"nft-market/auth"
+	"nft-market/siwe"
+	"nft-market/storage"
+	"nft-market/validation"
+	"strings"
+	"time"
+)
+
+const loginStatement = "Sign in to the NFT market."
+
+// with an address the response also carries the message to sign, built
+// from the nonce and the given URI
+type loginNonceRequest struct {
+	Address string `json:"address,omitempty"`
+	URI     string `json:"uri,omitempty"`
+}
+
+type loginNonceResponse struct {
+	Nonce   string    `json:"nonce"`
+	Domain  string    `json:"domain"`
+	ChainID int64     `json:"chain_id"`
+	Version string    `json:"version"`
+	Expires time.Time `json:"expires"`
+	Message string    `json:"message,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+// Message is an EIP-4361 message and Signature its personal_sign signature
+type loginRequest struct {
+	Message   string `json:"message"`
+	Signature string `json:"signature"`
+}
+
+// Created tells whether the user has been created by this sign in. Such
+// users are ReadOnly: the market holds none of their keys, so they can't
+// create collections, mint, trade, deposit or withdraw, only receive tokens
+// and look around. Linking the wallet to a registered user with a
+// link_wallet request to /user signs in as that user instead. APIKey is a
+// session key expiring after the configured session lifetime.
+type loginResponse struct {
+	UserID   string        `json:"userid,omitempty"`
+	Address  string        `json:"address,omitempty"`
+	Created  bool          `json:"created,omitempty"`
+	ReadOnly bool          `json:"read_only,omitempty"`
+	APIKey   string        `json:"api_key,omitempty"`
+	Key      *auth.KeyInfo `json:"key,omitempty"`
+	Error    string        `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+type userLinkWalletResponse struct {
+	Address string `json:"address,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
+}
+
+func verifyLoginNonceRequest(req *loginNonceRequest) error {
+	var v validation.Validator
+	v.Optional("address", req.Address, validation.Address)
+	if req.URI != "" && req.Address == "" {
+		v.Fail("uri", "needs an address")
+	}
+	return v.Err()
+}
+
+func loginNonce(req *loginNonceRequest, res *loginNonceResponse) error {
+	if err := verifyLoginNonceRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	nonce, expires, err := siwe.NewNonce()
+	if err != nil {
+		res.Error = "failed to create nonce"
+		return err
+	}
+	res.Nonce = nonce
+	res.Domain = siwe.Domain()
+	res.ChainID = siwe.ChainID()
+	res.Version = siwe.Version
+	res.Expires = expires
+
+	if req.Address != "" {
+		uri := req.URI
+		if uri == "" {
+			uri = "https://" + siwe.Domain()
+		}
+		message := siwe.Message{
+			Domain:         siwe.Domain(),
+			Address:        common.HexToAddress(req.Address).Hex(),
+			Statement:      loginStatement,
+			URI:            uri,
+			Version:        siwe.Version,
+			ChainID:        siwe.ChainID(),
+			Nonce:          nonce,
+			IssuedAt:       time.Now(),
+			ExpirationTime: expires,
+		}
+		res.Message = message.String()
+	}
+	return nil
+}
+
+func verifyLoginRequest(req *loginRequest) error {
+	var v validation.Validator
+	v.Required("message", req.Message)
+	v.Required("signature", req.Signature)
+	return v.Err()
+}
+
+// userCreateExternal creates a user for an address whose key stays in the
+// user's own wallet, so the user has no custodial keys. Such users own what
+// is transferred to their address, but requests that have to be signed with
+// their keys fail.
+func userCreateExternal(address string) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(strings.ToLower(address)))
+	userid := hex.EncodeToString(h.Sum(nil))
+
+	if storage.UserExists(userid) {
+		return "", errors.New("user " + userid + " already registered")
+	}
+	if err := storage.CreateUser(userid); err != nil {
+		return "", err
+	}
+	if err := storage.SetUserAddress(userid, []byte(address)); err != nil {
+		storage.RemoveUser(userid)
+		return failWith("failed to create user infrastructure (address)", err)
+	}
+	if err := storage.SetUserExternal(userid); err != nil {
+		storage.RemoveUser(userid)
+		return failWith("failed to create user infrastructure (external)", err)
+	}
+	return userid, nil
+}
+
+// findSignInUser returns the user signing in with address: the one who has
+// linked it, or else the one whose address it is.
+func findSignInUser(address string) (string, error) {
+	if userid, err := storage.FindUserByLinkedAddress(address); err == nil {
+		return userid, nil
+	}
+	return storage.FindUserByAddress(address)
+}
+
+func login(req *loginRequest, res *loginResponse) error {
+	if err := verifyLoginRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	message, err := siwe.Verify(req.Message, req.Signature, time.Now())
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+	res.Address = message.Address
+
+	userid, err := findSignInUser(message.Address)
+	if err != nil {
+		userid, err = userCreateExternal(message.Address)
+		if err != nil {
+			res.Error = err.Error()
+			return err
+		}
+		res.Created = true
+	}
+	res.UserID = userid
+	res.ReadOnly = storage.UserExternal(userid)
+
+	key, apiKey, err := auth.CreateKey(userid, &auth.KeyRequest{
+		Name:      "siwe session",
+		ExpiresIn: siwe.SessionTTL().String(),
+	})
+	if err != nil {
+		res.Error = "failed to create API key"
+		return err
+	}
+	info := key.Info()
+	res.APIKey = apiKey
+	res.Key = &info
+	return nil
+}
+
+// userLinkWallet links the external wallet that signed the message to the
+// user, who then signs in with it. The wallet can't be another user's, apart
+// from one created by signing in with it before, which is left as it is.
+func userLinkWallet(userid string, req *loginRequest, res *userLinkWalletResponse) error {
+	if err := verifyLoginRequest(req); err != nil {
+		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
+		return err
+	}
+
+	message, err := siwe.Verify(req.Message, req.Signature, time.Now())
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+	res.Address = message.Address
+
+	if storage.UserExternal(userid) {
+		res.Error = "users created on sign in can't link a wallet"
+		return errors.New(res.Error)
+	}
+	if other, err := storage.FindUserByLinkedAddress(message.Address); err == nil && other != userid {
+		res.Error = "wallet " + message.Address + " is linked to another user"
+		return errors.New(res.Error)
+	}
+	if other, err := storage.FindUserByAddress(message.Address); err == nil && other != userid && !storage.UserExternal(other) {
+		res.Error = "wallet " + message.Address + " is another user's address"
+		return errors.New(res.Error)
+	}
+
+	if err = storage.SetUserLinkedAddress(userid, []byte(message.Address)); err != nil {
+		res.Error = "failed to link wallet"
+		return err
+	}
+	return nil
+}
+
+// LoginNonce serves POST /login/nonce and Login POST /login, they don't
+// need an API key
+func LoginNonce(c echo.Context) error {
+	var req loginNonceRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var res loginNonceResponse
+	if err := loginNonce(&req, &res); err != nil {
+		log.Printf("error creating sign in nonce: %v", err)
+	}
+
+	pretty := c.QueryParam("pretty") == "true"
+	if pretty {
+		return c.JSONPretty(http.StatusOK, res, "    ")
+	} else {
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
+func Login(c echo.Context) error {
+	var req loginRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var res loginResponse
+	if err := login(&req, &res); err != nil {
+		log.Printf("error signing in: %v", err)
+	}
+
+	pretty := c.QueryParam("pretty") == "true"
+	if pretty {
+		return c.JSONPretty(http.StatusOK, res, "    ")
+	} else {
+		return c.JSON(http.StatusOK, res)
+	}
+}
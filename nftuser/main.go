@@ -5,6 +5,7 @@ import (
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
+	"nft-market/auth"
 	"nft-market/storage"
 )
 
@@ -13,12 +14,18 @@ type userRequest struct {
 	Register *userRegisterRequest `json:"register,omitempty"`
 	Deposit  *userDepositRequest  `json:"deposit,omitempty"`
 	Withdraw *userWithdrawRequest `json:"withdraw,omitempty"`
+	// IMXRegistration returns or retries the user's ImmutableX registration
+	IMXRegistration *userRegistrationRequest `json:"imx_registration,omitempty"`
+	// LinkWallet links an external wallet to sign in with, see /login
+	LinkWallet *loginRequest `json:"link_wallet,omitempty"`
 }
 
 type userResponse struct {
-	Register *userRegisterResponse `json:"register,omitempty"`
-	Deposit  *userDepositResponse  `json:"deposit,omitempty"`
-	Withdraw *userWithdrawResponse `json:"withdraw,omitempty"`
+	Register        *userRegisterResponse     `json:"register,omitempty"`
+	Deposit         *userDepositResponse      `json:"deposit,omitempty"`
+	Withdraw        *userWithdrawResponse     `json:"withdraw,omitempty"`
+	IMXRegistration *userRegistrationResponse `json:"imx_registration,omitempty"`
+	LinkWallet      *userLinkWalletResponse   `json:"link_wallet,omitempty"`
 }
 
 func VerifyUserID(userid string) error {
@@ -35,6 +42,12 @@ func User(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	userid, err := auth.Identify(c, req.UserID)
+	if err != nil {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	}
+	req.UserID = userid
+
 	if err := VerifyUserID(req.UserID); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
@@ -46,6 +59,8 @@ func User(c echo.Context) error {
 	var resRegister *userRegisterResponse = nil
 	var resDeposit *userDepositResponse = nil
 	var resWithdraw *userWithdrawResponse = nil
+	var resIMXRegistration *userRegistrationResponse = nil
+	var resLinkWallet *userLinkWalletResponse = nil
 
 	if req.Register != nil {
 		resRegister = new(userRegisterResponse)
@@ -71,10 +86,28 @@ func User(c echo.Context) error {
 		}
 	}
 
+	if req.IMXRegistration != nil {
+		resIMXRegistration = new(userRegistrationResponse)
+		err := userRegistration(req.UserID, req.IMXRegistration, resIMXRegistration)
+		if err != nil {
+			log.Printf("error registering user on ImmutableX: %v", err)
+		}
+	}
+
+	if req.LinkWallet != nil {
+		resLinkWallet = new(userLinkWalletResponse)
+		err := userLinkWallet(req.UserID, req.LinkWallet, resLinkWallet)
+		if err != nil {
+			log.Printf("error linking wallet: %v", err)
+		}
+	}
+
 	res := userResponse{
-		Register: resRegister,
-		Deposit:  resDeposit,
-		Withdraw: resWithdraw,
+		Register:        resRegister,
+		Deposit:         resDeposit,
+		Withdraw:        resWithdraw,
+		IMXRegistration: resIMXRegistration,
+		LinkWallet:      resLinkWallet,
 	}
 
 	pretty := c.QueryParam("pretty") == "true"
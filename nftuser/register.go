@@ -6,56 +6,183 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
+	"github.com/labstack/echo/v4"
 	"log"
+	"math/big"
+	"net/http"
+	"nft-market/auth"
+	"nft-market/signer"
 	"nft-market/storage"
-	"os"
+	"nft-market/validation"
+	"nft-market/wallet"
 )
 
+// the wallet is generated unless PrivateKey or Mnemonic import one, the key
+// of a mnemonic is derived along DerivationPath, the first Ethereum account
+// by default. PublicKey, if given, has to be the imported key's. The Stark
+// key is derived from the Ethereum key unless StarkPrivateKey is given,
+// StarkKey, if given, has to be the public key of either.
 type userRegisterRequest struct {
-	Email      string `json:"email"`
-	PublicKey  string `json:"public_key"`
-	PrivateKey string `json:"private_key"`
+	Email           string `json:"email"`
+	PublicKey       string `json:"public_key"`
+	PrivateKey      string `json:"private_key"`
+	Mnemonic        string `json:"mnemonic,omitempty"`
+	Passphrase      string `json:"passphrase,omitempty"`
+	DerivationPath  string `json:"derivation_path,omitempty"`
+	StarkPrivateKey string `json:"stark_private_key,omitempty"`
+	StarkKey        string `json:"stark_key,omitempty"`
 }
 
+// userWallet holds the keys a user is created with, nil to generate them
+type userWallet struct {
+	privateKey      *ecdsa.PrivateKey
+	starkPrivateKey *big.Int
+}
+
+// APIKey is the new user's first key, with every scope. A user is created
+// even if the registration on ImmutableX fails, it can be retried later.
 type userRegisterResponse struct {
-	UserID string `json:"userid"`
-	Error  string `json:"error,omitempty"`
+	UserID          string                    `json:"userid"`
+	APIKey          string                    `json:"api_key,omitempty"`
+	IMXRegistration *userRegistrationResponse `json:"imx_registration,omitempty"`
+	Error           string                    `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
-func verifyUserRegisterRequest(req *userRegisterRequest) error {
-	// TODO: check formats
-	//if req.Email == "" || req.PublicKey == "" || req.PrivateKey == "" {
-	if req.Email == "" {
-		return errors.New("please provide email")
+// verifyUserRegisterRequest returns the imported keys of a valid request
+func verifyUserRegisterRequest(req *userRegisterRequest) (*userWallet, error) {
+	var v validation.Validator
+	if v.Required("email", req.Email) {
+		v.Check("email", validation.Email(req.Email))
 	}
-	return nil
+
+	var w userWallet
+	var err error
+	if req.PrivateKey != "" {
+		w.privateKey, err = wallet.ParsePrivateKey(req.PrivateKey)
+		v.Check("private_key", err)
+		if req.Mnemonic != "" {
+			v.Fail("mnemonic", "can't be combined with private_key")
+		}
+	} else if req.Mnemonic != "" {
+		path, err := wallet.ParsePath(wallet.DefaultPath)
+		if req.DerivationPath != "" {
+			path, err = wallet.ParsePath(req.DerivationPath)
+			v.Check("derivation_path", err)
+		}
+		mnemonicErr := wallet.CheckMnemonic(req.Mnemonic)
+		v.Check("mnemonic", mnemonicErr)
+		if err == nil && mnemonicErr == nil {
+			w.privateKey, err = wallet.FromMnemonic(req.Mnemonic, req.Passphrase, path)
+			v.Check("mnemonic", err)
+		}
+	}
+	if req.Mnemonic == "" {
+		if req.DerivationPath != "" {
+			v.Fail("derivation_path", "needs a mnemonic")
+		}
+		if req.Passphrase != "" {
+			v.Fail("passphrase", "needs a mnemonic")
+		}
+	}
+
+	if req.PublicKey != "" {
+		if req.PrivateKey == "" && req.Mnemonic == "" {
+			v.Fail("public_key", "needs a private_key or mnemonic")
+		} else if w.privateKey != nil {
+			v.Check("public_key", wallet.MatchPublicKey(w.privateKey, req.PublicKey))
+		}
+	}
+
+	if req.StarkPrivateKey != "" {
+		w.starkPrivateKey, err = wallet.ParseStarkPrivateKey(req.StarkPrivateKey)
+		v.Check("stark_private_key", err)
+	}
+
+	if req.StarkKey != "" {
+		starkKeyErr := validation.StarkKey(req.StarkKey)
+		v.Check("stark_key", starkKeyErr)
+		if req.PrivateKey == "" && req.Mnemonic == "" && req.StarkPrivateKey == "" {
+			v.Fail("stark_key", "needs a private_key, mnemonic or stark_private_key")
+		} else if starkKeyErr == nil && v.Err() == nil {
+			starkPrivateKey := w.starkPrivateKey
+			if starkPrivateKey == nil {
+				starkPrivateKey, err = wallet.DeriveStarkKey(w.privateKey)
+			}
+			if err == nil {
+				err = wallet.MatchStarkKey(starkPrivateKey, req.StarkKey)
+			}
+			v.Check("stark_key", err)
+		}
+	}
+
+	if err = v.Err(); err != nil {
+		return nil, err
+	}
+	return &w, nil
 }
 
 func userRegister(req *userRegisterRequest, res *userRegisterResponse) error {
-	if err := verifyUserRegisterRequest(req); err != nil {
+	w, err := verifyUserRegisterRequest(req)
+	if err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
-	userid, err := userCreate(req)
+	userid, err := userCreate(req, w)
 	if err != nil {
 		res.Error = err.Error()
 		return err
 	}
-
 	res.UserID = userid
+
+	_, apiKey, err := auth.CreateKey(userid, &auth.KeyRequest{Name: "registration"})
+	if err != nil {
+		res.Error = "failed to create API key"
+		return err
+	}
+	res.APIKey = apiKey
+
+	res.IMXRegistration = new(userRegistrationResponse)
+	registration, err := registerIMX(userid, req.Email)
+	if registration != nil {
+		registrationInfo(registration, res.IMXRegistration)
+	}
+	if err != nil {
+		log.Printf("failed to register user %v on ImmutableX: %v", userid, err)
+	}
 	return nil
 }
 
+// Register serves POST /register, which doesn't need an API key
+func Register(c echo.Context) error {
+	var req userRegisterRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	var res userRegisterResponse
+	if err := userRegister(&req, &res); err != nil {
+		log.Printf("error registering user: %v", err)
+	}
+
+	pretty := c.QueryParam("pretty") == "true"
+	if pretty {
+		return c.JSONPretty(http.StatusOK, res, "    ")
+	} else {
+		return c.JSON(http.StatusOK, res)
+	}
+}
+
 func failWith(msg string, err error) (string, error) {
 	log.Printf(msg+": %v", err)
 	return "", errors.New(msg)
 }
 
-func userCreate(req *userRegisterRequest) (string, error) {
+func userCreate(req *userRegisterRequest, w *userWallet) (string, error) {
 	h := sha256.New()
 	//h.Write([]byte(req.Email + req.PublicKey + req.PrivateKey))
 	h.Write([]byte(req.Email))
@@ -70,67 +197,52 @@ func userCreate(req *userRegisterRequest) (string, error) {
 		return "", errors.New("user " + userid + " already registered")
 	}
 
-	err = os.MkdirAll(storage.Prefix+storage.UserDir+userid+"/collections/tokens", os.ModePerm)
-	if err != nil {
-		return "", errors.New("failed to create user infrastructure")
+	// keys are created where they are sealed, by the signer provider, the
+	// market only checks that an imported one isn't taken
+	keyReq := signer.KeyRequest{UserID: userid}
+	if w.privateKey != nil {
+		address := crypto.PubkeyToAddress(w.privateKey.PublicKey).Hex()
+		if _, err := storage.FindUserByAddress(address); err == nil {
+			return "", errors.New("address " + address + " already registered")
+		}
+		if _, err := storage.FindUserByLinkedAddress(address); err == nil {
+			return "", errors.New("address " + address + " already linked to a user")
+		}
+		keyReq.PrivateKey = hex.EncodeToString(crypto.FromECDSA(w.privateKey))
+	}
+	if w.starkPrivateKey != nil {
+		keyReq.StarkPrivateKey = fmt.Sprintf("%x", w.starkPrivateKey)
 	}
 
-	privateKey, err := crypto.GenerateKey()
+	err = storage.CreateUser(userid)
 	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("failed to create user wallet (private key)", err)
+		return "", err
 	}
-	privateKeyBytes := crypto.FromECDSA(privateKey)
-	privateKeyString := hexutil.Encode(privateKeyBytes)[2:]
-	err = os.WriteFile(storage.Prefix+storage.UserDir+userid+"/private_key", []byte(privateKeyString), 0644)
+
+	keys, err := signer.CreateKeys(keyReq)
 	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("failed to create user infrastructure (private key)", err)
+		storage.RemoveUser(userid)
+		return failWith("failed to create user infrastructure (keys)", err)
 	}
 
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("error casting public key to ECDSA", nil)
-	}
-	publicKeyBytes := crypto.FromECDSAPub(publicKeyECDSA)
-	err = os.WriteFile(storage.Prefix+storage.UserDir+userid+"/public_key", []byte(hexutil.Encode(publicKeyBytes)[4:]), 0644)
+	err = storage.SetUserPublicKey(userid, []byte(keys.PublicKey))
 	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
+		storage.RemoveUser(userid)
 		return failWith("failed to create user infrastructure (public key)", err)
 	}
 
-	address := crypto.PubkeyToAddress(*publicKeyECDSA).Hex()
-	err = os.WriteFile(storage.Prefix+storage.UserDir+userid+"/address", []byte(address), 0644)
+	err = storage.SetUserAddress(userid, []byte(keys.Address))
 	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
+		storage.RemoveUser(userid)
 		return failWith("failed to create user infrastructure (address)", err)
 	}
 
-	privateStarkKey, err := stark.GenerateKey()
-	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("failed to generate Stark Private Key", err)
-	}
-	err = os.WriteFile(storage.Prefix+storage.UserDir+userid+"/stark_private_key", []byte(fmt.Sprintf("%x", privateStarkKey)), 0644)
-	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("failed to create user infrastructure (stark private key)", err)
-	}
-	l2signer, err := stark.NewSigner(privateStarkKey)
-	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
-		return failWith("failed to create StarkSigner", err)
-	}
-	err = os.WriteFile(storage.Prefix+storage.UserDir+userid+"/stark_address", []byte(l2signer.GetAddress()), 0644)
+	err = storage.SetUserStarkAddress(userid, []byte(keys.StarkAddress))
 	if err != nil {
-		_ = os.RemoveAll(storage.Prefix + storage.UserDir + userid)
+		storage.RemoveUser(userid)
 		return failWith("failed to create user infrastructure (stark public key)", err)
 	}
 
-	// don't call imx for now
-	//imxauth := nftimx.Register(privateKeyString, l2signer, req.Email)
 	/*
 		// private key from testing1 account
 		adminl1signer, err := ethereum.NewSigner("", cfg.ChainID)
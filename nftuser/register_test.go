@@ -0,0 +1,93 @@
+package nftuser
+
+import (
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"nft-market/validation"
+	"nft-market/wallet"
+	"reflect"
+	"testing"
+)
+
+func TestVerifyUserRegisterRequest(t *testing.T) {
+	// the first two accounts of the Hardhat test mnemonic, and the Stark
+	// key ImmutableX derives for the first one
+	const (
+		mnemonic    = "test test test test test test test test test test test junk"
+		privateKey  = "0xac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80"
+		otherKey    = "0x59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d"
+		starkKey    = "0x078c3293f5ee6607bc48b68c03b86ef492b75afcb444a702cfd6fde74e658ce5"
+		starkSecret = "0x242bcc810538a112273bcf60d7bce0df08f9058746058b1a571c408159f07e1"
+	)
+	key, _ := wallet.ParsePrivateKey(privateKey)
+	other, _ := wallet.ParsePrivateKey(otherKey)
+	publicKey := hexutil.Encode(crypto.FromECDSAPub(&key.PublicKey))
+	compressed := hexutil.Encode(crypto.CompressPubkey(&key.PublicKey))
+	otherPublicKey := hexutil.Encode(crypto.FromECDSAPub(&other.PublicKey))
+	otherStarkKey := "0x0790436373c1d5b7a88ce4fd7ac96591a385b2b6392d1ea44a165f75115b82ac"
+
+	tests := []struct {
+		name string
+		req  userRegisterRequest
+		errs []validation.FieldError
+	}{
+		{"generated", userRegisterRequest{}, nil},
+		{"private key", userRegisterRequest{PrivateKey: privateKey}, nil},
+		{"public key", userRegisterRequest{PrivateKey: privateKey, PublicKey: publicKey}, nil},
+		{"public key without 04", userRegisterRequest{PrivateKey: privateKey, PublicKey: publicKey[4:]}, nil},
+		{"compressed public key", userRegisterRequest{PrivateKey: privateKey, PublicKey: compressed}, nil},
+		{"mismatched public key", userRegisterRequest{PrivateKey: privateKey, PublicKey: otherPublicKey}, []validation.FieldError{
+			{Field: "public_key", Error: "doesn't match the private key"},
+		}},
+		{"mnemonic public key", userRegisterRequest{Mnemonic: mnemonic, PublicKey: publicKey}, nil},
+		{"mnemonic path public key", userRegisterRequest{Mnemonic: mnemonic, DerivationPath: "m/44'/60'/0'/0/1", PublicKey: otherPublicKey}, nil},
+		{"mismatched mnemonic path", userRegisterRequest{Mnemonic: mnemonic, DerivationPath: "m/44'/60'/0'/0/1", PublicKey: publicKey}, []validation.FieldError{
+			{Field: "public_key", Error: "doesn't match the private key"},
+		}},
+		{"public key alone", userRegisterRequest{PublicKey: publicKey}, []validation.FieldError{
+			{Field: "public_key", Error: "needs a private_key or mnemonic"},
+		}},
+		{"not a public key", userRegisterRequest{PrivateKey: privateKey, PublicKey: "0x1234"}, []validation.FieldError{
+			{Field: "public_key", Error: "is not a public key"},
+		}},
+		{"derived Stark key", userRegisterRequest{PrivateKey: privateKey, StarkKey: starkKey}, nil},
+		{"mnemonic Stark key", userRegisterRequest{Mnemonic: mnemonic, StarkKey: starkKey}, nil},
+		{"mismatched derived Stark key", userRegisterRequest{PrivateKey: otherKey, StarkKey: starkKey}, []validation.FieldError{
+			{Field: "stark_key", Error: "doesn't match the Stark private key"},
+		}},
+		{"imported Stark key", userRegisterRequest{StarkPrivateKey: starkSecret, StarkKey: starkKey}, nil},
+		{"imported over derived Stark key", userRegisterRequest{PrivateKey: otherKey, StarkPrivateKey: starkSecret, StarkKey: starkKey}, nil},
+		{"mismatched imported Stark key", userRegisterRequest{PrivateKey: privateKey, StarkPrivateKey: starkSecret, StarkKey: otherStarkKey}, []validation.FieldError{
+			{Field: "stark_key", Error: "doesn't match the Stark private key"},
+		}},
+		{"Stark key alone", userRegisterRequest{StarkKey: starkKey}, []validation.FieldError{
+			{Field: "stark_key", Error: "needs a private_key, mnemonic or stark_private_key"},
+		}},
+		{"not a Stark key", userRegisterRequest{PrivateKey: privateKey, StarkKey: "078c32"}, []validation.FieldError{
+			{Field: "stark_key", Error: "is not a Stark key"},
+		}},
+		{"invalid private key", userRegisterRequest{PrivateKey: "0x1234", PublicKey: publicKey, StarkKey: starkKey}, []validation.FieldError{
+			{Field: "private_key", Error: "is not an Ethereum private key"},
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := test.req
+			req.Email = "user@example.com"
+			w, err := verifyUserRegisterRequest(&req)
+			if errs := validation.Fields(err); !reflect.DeepEqual(errs, test.errs) {
+				t.Fatalf("got errors %v, want %v", errs, test.errs)
+			}
+			if err != nil {
+				return
+			}
+			if (w.privateKey != nil) != (req.PrivateKey != "" || req.Mnemonic != "") {
+				t.Errorf("got private key %v for the request", w.privateKey != nil)
+			}
+			if (w.starkPrivateKey != nil) != (req.StarkPrivateKey != "") {
+				t.Errorf("got Stark private key %v for the request", w.starkPrivateKey != nil)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package nftuser
+
+import (
+	"errors"
+	"nft-market/nftimx"
+	"nft-market/signer"
+	"nft-market/storage"
+	"time"
+)
+
+// statuses of the off-chain registration on ImmutableX, users whose
+// registration has never been attempted are unregistered
+const (
+	RegistrationUnregistered = "unregistered"
+	RegistrationFailed       = "failed"
+	RegistrationRegistered   = "registered"
+)
+
+// Retry registers the user if the registration hasn't succeeded yet,
+// without it the request only returns the registration status
+type userRegistrationRequest struct {
+	Retry bool `json:"retry,omitempty"`
+}
+
+// LastError is why the last registration attempt failed
+type userRegistrationResponse struct {
+	Status    string     `json:"status"`
+	TxHash    string     `json:"tx_hash,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+	Updated   *time.Time `json:"updated,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+func getRegistration(userid string) (*storage.Registration, error) {
+	registration, err := storage.GetUserRegistration(userid)
+	if err != nil {
+		return nil, err
+	}
+	if registration == nil {
+		registration = &storage.Registration{Status: RegistrationUnregistered}
+	}
+	return registration, nil
+}
+
+// registerIMX registers the user off-chain on ImmutableX unless already
+// registered, a user that has registered elsewhere is only marked as such.
+// Failed attempts are recorded as well.
+func registerIMX(userid string, email string) (*storage.Registration, error) {
+	registration, err := getRegistration(userid)
+	if err != nil {
+		return nil, err
+	}
+	if registration.Status == RegistrationRegistered {
+		return registration, nil
+	}
+	if email != "" {
+		registration.Email = email
+	}
+
+	address, err := storage.GetUserAddress(userid)
+	if err != nil {
+		return registration, errors.New("failed to get user address")
+	}
+	registered, err := nftimx.Registered(string(address))
+	if err == nil && !registered {
+		registration.TxHash, err = registerIMXSigned(userid, registration.Email)
+	}
+
+	registration.Updated = time.Now()
+	if err != nil {
+		registration.Status = RegistrationFailed
+		registration.Error = err.Error()
+	} else {
+		registration.Status = RegistrationRegistered
+		registration.Error = ""
+	}
+	if saveErr := storage.SetUserRegistration(userid, registration); saveErr != nil {
+		return registration, saveErr
+	}
+	return registration, err
+}
+
+func registerIMXSigned(userid string, email string) (string, error) {
+	l1signer, err := signer.L1Signer(userid)
+	if err != nil {
+		return "", errors.New(signer.Failure(err, "failed to get user signer"))
+	}
+	l2signer, err := signer.L2Signer(userid)
+	if err != nil {
+		return "", errors.New(signer.Failure(err, "failed to get user stark signer"))
+	}
+	return nftimx.Register(l1signer, l2signer, email)
+}
+
+func registrationInfo(registration *storage.Registration, res *userRegistrationResponse) {
+	res.Status = registration.Status
+	res.TxHash = registration.TxHash
+	res.LastError = registration.Error
+	if !registration.Updated.IsZero() {
+		updated := registration.Updated
+		res.Updated = &updated
+	}
+}
+
+func userRegistration(userid string, req *userRegistrationRequest, res *userRegistrationResponse) error {
+	var registration *storage.Registration
+	var err error
+	if req.Retry {
+		registration, err = registerIMX(userid, "")
+	} else {
+		registration, err = getRegistration(userid)
+	}
+	if registration != nil {
+		registrationInfo(registration, res)
+	}
+	if err != nil {
+		res.Error = "failed to register user on ImmutableX"
+		return err
+	}
+	return nil
+}
+
+// VerifyRegistered checks that the user is registered on ImmutableX, which
+// selling, buying and transferring need.
+func VerifyRegistered(userid string) error {
+	registration, err := getRegistration(userid)
+	if err != nil {
+		return errors.New("failed to get user registration")
+	}
+	if registration.Status != RegistrationRegistered {
+		return errors.New("user " + userid + " isn't registered on ImmutableX, retry with an imx_registration request to /user")
+	}
+	return nil
+}
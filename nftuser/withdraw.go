@@ -1,114 +1,319 @@
 package nftuser
 
 import (
+	"encoding/json"
 	"errors"
-	"github.com/alitto/pond"
 	"log"
+	"nft-market/currency"
+	"nft-market/jobs"
 	"nft-market/nftimx"
+	"nft-market/signer"
 	"nft-market/storage"
+	"nft-market/validation"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// with no amount the request is a query: the withdrawal with the given ID,
+// or the list of all the user's withdrawals if there is no ID either. The
+// amount is in the currency given by symbol or contract address, ETH by
+// default, see userDepositRequest.
 type userWithdrawRequest struct {
-	Amount string `json:"amount"`
+	Amount   string `json:"amount"`
+	Currency string `json:"currency,omitempty"`
+	ID       int32  `json:"id,omitempty"`
+}
+
+// withdrawalInfo adds the amount in whole tokens to a withdrawal record
+type withdrawalInfo struct {
+	storage.Withdrawal
+	AmountDecimal string `json:"amount_decimal,omitempty"`
 }
 
 type userWithdrawResponse struct {
-	TxID  string `json:"tx_id,omitempty"`
-	Error string `json:"error,omitempty"`
+	TxID       string           `json:"tx_id,omitempty"`
+	WithdrawID int32            `json:"withdraw_id,omitempty"`
+	Withdrawal *withdrawalInfo  `json:"withdrawal,omitempty"`
+	List       []withdrawalInfo `json:"list,omitempty"`
+	Error      string           `json:"error,omitempty"`
+	// Errors lists the invalid fields of the request
+	Errors []validation.FieldError `json:"errors,omitempty"`
 }
 
 func verifyUserWithdrawRequest(req *userWithdrawRequest) error {
-	// TODO: verify formatting
-	if req.Amount == "" {
-		return errors.New("invalid withdraw amount")
+	var v validation.Validator
+	cur, err := currency.Lookup(req.Currency)
+	v.Check("currency", err)
+	if req.Amount != "" && cur != nil {
+		v.Check("amount", validation.Amount(cur, req.Amount))
 	}
-	return nil
+	if req.Amount == "" && req.Currency != "" {
+		v.Fail("currency", "needs an amount")
+	}
+	if req.ID < 0 {
+		v.Fail("id", "is not a withdrawal ID")
+	}
+	if req.Amount != "" && req.ID != 0 {
+		v.Fail("id", "can't be combined with an amount")
+	}
+	return v.Err()
 }
 
-var WorkerPool *pond.WorkerPool
+// WithdrawJob is the job kind finalizing a withdrawal, see WithdrawFinalizeJob
+const WithdrawJob = "withdraw"
+
+type withdrawJobData struct {
+	WithdrawID int32 `json:"withdraw_id"`
+}
 
-// NOTE: run withdraw finalizing in async go routine since it can take many hours to confirm (as per IMX documentation)
-func UserWithdrawFinalize(userid string) {
+// withdrawalMutex serializes updates of withdrawal records, finalizing one
+// withdrawal updates the other ones completed by the same L1 transaction
+var withdrawalMutex sync.Mutex
 
-	WorkerPool.Submit(
-		func() {
-			privateKey, err := storage.GetUserPrivateKey(userid)
-			if err != nil {
-				return
-			}
-			starkKey, err := storage.GetUserStarkPrivateKey(userid)
-			if err != nil {
-				return
-			}
+func updateWithdrawal(userid string, withdrawID int32, update func(withdrawal *storage.Withdrawal)) error {
+	withdrawalMutex.Lock()
+	defer withdrawalMutex.Unlock()
 
-			withdrawID, err := storage.GetUserWithdrawID(userid)
-			if err != nil {
-				log.Printf("failed to get withdraw ID")
-				return
-			}
-			// TODO: check IMX docs if withdraw ID could be negative
-			if withdrawID < 1 {
-				log.Printf("invalid withdraw ID")
-				return
+	withdrawal, err := storage.GetUserWithdrawal(userid, withdrawID)
+	if err != nil {
+		return errors.New("failed to get user withdrawal")
+	}
+	update(withdrawal)
+	withdrawal.Updated = time.Now()
+	return storage.SetUserWithdrawal(userid, withdrawal)
+}
+
+// UserWithdrawFinalize queues the finalization of a prepared withdrawal, it
+// can take many hours to confirm (as per IMX documentation)
+func UserWithdrawFinalize(userid string, withdrawID int32) error {
+	// TODO: check IMX docs if withdraw ID could be negative
+	if withdrawID < 1 {
+		return errors.New("invalid withdraw ID")
+	}
+	id := WithdrawJob + "-" + strconv.FormatInt(int64(withdrawID), 10)
+	return jobs.Enqueue(id, WithdrawJob, userid, withdrawJobData{WithdrawID: withdrawID})
+}
+
+// WithdrawFinalizeJob polls IMX until the withdrawal is confirmed and then
+// completes it on L1, keeping the user's withdrawal record up to date.
+func WithdrawFinalizeJob(job *jobs.Job) error {
+	var data withdrawJobData
+	if err := json.Unmarshal(job.Data, &data); err != nil {
+		return err
+	}
+
+	switch job.State {
+	case jobs.Pending:
+		job.State = jobs.Polling
+
+	case jobs.Polling:
+		withdrawState, err := nftimx.WithdrawGetState(data.WithdrawID)
+		if err != nil {
+			return err
+		}
+		err = updateWithdrawal(job.UserID, data.WithdrawID, func(withdrawal *storage.Withdrawal) {
+			withdrawal.RollupStatus = withdrawState
+			// finalized by this job on an attempt that failed after
+			// recording the transaction
+			if withdrawState == "withdrawn" && withdrawal.TxHash != "" && withdrawal.Finalized == nil {
+				now := time.Now()
+				withdrawal.Finalized = &now
 			}
+		})
+		if err != nil {
+			return err
+		}
+
+		switch withdrawState {
+		case "confirmed":
+			job.State = jobs.Finalizing
+		case "withdrawn":
+			// completed together with another withdrawal of the user
+			job.State = jobs.Done
+		default:
+			return jobs.ErrRetryLater
+		}
+
+	case jobs.Finalizing:
+		withdrawState, err := nftimx.WithdrawGetState(data.WithdrawID)
+		if err != nil {
+			return err
+		}
+		if withdrawState == "withdrawn" {
+			job.State = jobs.Polling
+			return nil
+		}
+
+		prepared, err := storage.GetUserWithdrawal(job.UserID, data.WithdrawID)
+		if err != nil {
+			return errors.New("failed to get user withdrawal")
+		}
+		cur, err := withdrawalCurrency(prepared)
+		if err != nil {
+			return errors.New("withdrawal currency " + prepared.TokenAddress + " " + err.Error())
+		}
+
+		l1signer, err := signer.L1Signer(job.UserID)
+		if err != nil {
+			return errors.New("failed to get user signer")
+		}
+		l2signer, err := signer.L2Signer(job.UserID)
+		if err != nil {
+			return errors.New("failed to get user stark signer")
+		}
+
+		txHash, err := nftimx.WithdrawFinalize(l1signer, l2signer, cur)
+		if err != nil {
+			return err
+		}
+		// recorded on its own first, the transaction can't be sent again
+		// if anything below fails
+		err = updateWithdrawal(job.UserID, data.WithdrawID, func(withdrawal *storage.Withdrawal) {
+			withdrawal.TxHash = txHash
+		})
+		if err != nil {
+			log.Printf("failed to record withdrawal %v finalization transaction %v: %v", data.WithdrawID, txHash, err)
+			return err
+		}
 
-			for {
-				withdrawState, _ := nftimx.WithdrawGetState(withdrawID)
-				if withdrawState == "confirmed" {
-					break
+		// the transaction completes every confirmed withdrawal of the user
+		// in the same currency, record it on all the ones that are withdrawn
+		// now
+		withdrawals, err := storage.ListUserWithdrawals(job.UserID)
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		for _, withdrawal := range withdrawals {
+			if withdrawal.Finalized != nil || !strings.EqualFold(withdrawal.TokenAddress, cur.Address) {
+				continue
+			}
+			if withdrawal.ID != data.WithdrawID {
+				state, err := nftimx.WithdrawGetState(withdrawal.ID)
+				if err != nil || state != "withdrawn" {
+					continue
 				}
-				// TODO: use exponential backoff? (could be problematic when starting up with a lot of withdrawals)
-				time.Sleep(time.Hour)
 			}
-
-			err = nftimx.WithdrawFinalize(string(privateKey), string(starkKey))
+			err = updateWithdrawal(job.UserID, withdrawal.ID, func(withdrawal *storage.Withdrawal) {
+				withdrawal.RollupStatus = "withdrawn"
+				withdrawal.TxHash = txHash
+				withdrawal.Finalized = &now
+			})
 			if err != nil {
-				return
+				return err
 			}
+		}
+
+		job.State = jobs.Done
+	}
+	return nil
+}
 
-			storage.UserWithdrawFinalize(userid)
+// withdrawalCurrency returns the currency the withdrawal is made in, older
+// withdrawals have no token address and are in ETH
+func withdrawalCurrency(withdrawal *storage.Withdrawal) (*currency.Currency, error) {
+	return currency.Lookup(withdrawal.TokenAddress)
+}
 
-			return
-		},
-	)
+func withdrawalInfoFrom(withdrawal *storage.Withdrawal) withdrawalInfo {
+	info := withdrawalInfo{Withdrawal: *withdrawal}
+	if cur, err := withdrawalCurrency(withdrawal); err == nil {
+		info.AmountDecimal = cur.FormatString(withdrawal.Amount)
+	}
+	return info
+}
+
+func userWithdrawQuery(userid string, req *userWithdrawRequest, res *userWithdrawResponse) error {
+	if req.ID != 0 {
+		withdrawal, err := storage.GetUserWithdrawal(userid, req.ID)
+		if err != nil {
+			res.Error = "withdrawal doesn't exist"
+			return err
+		}
+		info := withdrawalInfoFrom(withdrawal)
+		res.Withdrawal = &info
+		return nil
+	}
+
+	list, err := storage.ListUserWithdrawals(userid)
+	if err != nil {
+		res.Error = err.Error()
+		return err
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Created.Before(list[j].Created)
+	})
+	res.List = make([]withdrawalInfo, 0, len(list))
+	for i := range list {
+		res.List = append(res.List, withdrawalInfoFrom(&list[i]))
+	}
+	return nil
 }
 
 func userWithdraw(userid string, req *userWithdrawRequest, res *userWithdrawResponse) error {
 	if err := verifyUserWithdrawRequest(req); err != nil {
 		res.Error = err.Error()
+		res.Errors = validation.Fields(err)
 		return err
 	}
 
-	if storage.UserWithdrawInProgress(userid) {
-		res.Error = "withdraw operation is already in progress"
-		return errors.New(res.Error)
+	if req.Amount == "" {
+		return userWithdrawQuery(userid, req, res)
 	}
 
-	privateKey, err := storage.GetUserPrivateKey(userid)
+	l1signer, err := signer.L1Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user signer")
 		return err
 	}
-	starkKey, err := storage.GetUserStarkPrivateKey(userid)
+	l2signer, err := signer.L2Signer(userid)
 	if err != nil {
-		res.Error = "failed to get user private key"
+		res.Error = signer.Failure(err, "failed to get user stark signer")
 		return err
 	}
 
-	withdrawID, err := nftimx.WithdrawPrepare(string(privateKey), string(starkKey), req.Amount)
+	cur, err := currency.Lookup(req.Currency)
 	if err != nil {
-		res.Error = "failed to prepare withdraw operation in IMX"
+		res.Error = "invalid withdraw currency"
+		return err
+	}
+	amount, err := cur.Parse(req.Amount)
+	if err != nil {
+		res.Error = "invalid withdraw amount"
 		return err
 	}
 
-	err = storage.SetUserWithdrawID(userid, withdrawID)
+	withdrawID, err := nftimx.WithdrawPrepare(l1signer, l2signer, cur, amount)
 	if err != nil {
-		res.Error = "failed to set user withdraw ID"
+		res.Error = "failed to prepare withdraw operation in IMX"
 		return err
 	}
+	res.WithdrawID = withdrawID
 
-	UserWithdrawFinalize(userid)
+	now := time.Now()
+	withdrawal := &storage.Withdrawal{
+		ID:           withdrawID,
+		Amount:       amount.String(),
+		Token:        cur.Symbol,
+		TokenAddress: cur.Address,
+		RollupStatus: "included",
+		Created:      now,
+		Updated:      now,
+	}
+	if err = storage.SetUserWithdrawal(userid, withdrawal); err != nil {
+		res.Error = "failed to save user withdrawal"
+		return err
+	}
+
+	if err = UserWithdrawFinalize(userid, withdrawID); err != nil {
+		res.Error = "failed to schedule withdraw finalization"
+		return err
+	}
 
+	info := withdrawalInfoFrom(withdrawal)
+	res.Withdrawal = &info
 	return nil
 }
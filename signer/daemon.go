@@ -0,0 +1,258 @@
+package signer
+
+import (
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+)
+
+const daemonService = "Signer"
+
+type SignRequest struct {
+	UserID  string `json:"userid"`
+	Message string `json:"message,omitempty"`
+	Tx      []byte `json:"tx,omitempty"`
+}
+
+type SecretRequest struct {
+	Label  string `json:"label"`
+	Secret []byte `json:"secret,omitempty"`
+	Sealed []byte `json:"sealed,omitempty"`
+}
+
+// DaemonService is exported over JSON-RPC by the signing daemon, it signs on
+// behalf of users with signers taken from the daemon's own provider, and
+// creates keys and seals secrets if that provider holds keys.
+type DaemonService struct {
+	provider Provider
+}
+
+func (s *DaemonService) keyStore() (KeyStore, error) {
+	keys, ok := s.provider.(KeyStore)
+	if !ok {
+		return nil, ErrNoKeyStore
+	}
+	return keys, nil
+}
+
+func (s *DaemonService) L1Address(userid string, reply *string) error {
+	l1signer, err := s.provider.L1Signer(userid)
+	if err != nil {
+		return err
+	}
+	*reply = l1signer.GetAddress()
+	return nil
+}
+
+func (s *DaemonService) L1SignMessage(req SignRequest, reply *[]byte) error {
+	l1signer, err := s.provider.L1Signer(req.UserID)
+	if err != nil {
+		return err
+	}
+	*reply, err = l1signer.SignMessage(req.Message)
+	return err
+}
+
+func (s *DaemonService) L1SignTx(req SignRequest, reply *[]byte) error {
+	l1signer, err := s.provider.L1Signer(req.UserID)
+	if err != nil {
+		return err
+	}
+	tx := new(types.Transaction)
+	if err = tx.UnmarshalBinary(req.Tx); err != nil {
+		return err
+	}
+	signed, err := l1signer.SignTx(tx)
+	if err != nil {
+		return err
+	}
+	*reply, err = signed.MarshalBinary()
+	return err
+}
+
+func (s *DaemonService) L2Address(userid string, reply *string) error {
+	l2signer, err := s.provider.L2Signer(userid)
+	if err != nil {
+		return err
+	}
+	*reply = l2signer.GetAddress()
+	return nil
+}
+
+func (s *DaemonService) L2SignMessage(req SignRequest, reply *string) error {
+	l2signer, err := s.provider.L2Signer(req.UserID)
+	if err != nil {
+		return err
+	}
+	*reply, err = l2signer.SignMessage(req.Message)
+	return err
+}
+
+func (s *DaemonService) CreateKeys(req KeyRequest, reply *Keys) error {
+	keys, err := s.keyStore()
+	if err != nil {
+		return err
+	}
+	created, err := keys.CreateKeys(req)
+	if err != nil {
+		return err
+	}
+	*reply = *created
+	return nil
+}
+
+func (s *DaemonService) MigrateKeys(userid string, reply *bool) error {
+	keys, err := s.keyStore()
+	if err != nil {
+		return err
+	}
+	*reply = true
+	return keys.MigrateKeys(userid)
+}
+
+func (s *DaemonService) SealSecret(req SecretRequest, reply *[]byte) error {
+	keys, err := s.keyStore()
+	if err != nil {
+		return err
+	}
+	*reply, err = keys.SealSecret(req.Label, req.Secret)
+	return err
+}
+
+func (s *DaemonService) OpenSecret(req SecretRequest, reply *[]byte) error {
+	keys, err := s.keyStore()
+	if err != nil {
+		return err
+	}
+	*reply, err = keys.OpenSecret(req.Label, req.Sealed)
+	return err
+}
+
+// Serve answers signing requests coming in on the listener (normally a Unix
+// socket only accessible to the market process) until the listener is closed.
+func Serve(listener net.Listener, p Provider) error {
+	server := rpc.NewServer()
+	if err := server.RegisterName(daemonService, &DaemonService{provider: p}); err != nil {
+		return err
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	}
+}
+
+// DaemonProvider asks a signing daemon listening on a Unix socket to sign,
+// to create the keys of new users and to seal secrets. Neither the keystore
+// master key nor the wallet seed enter the market process, and user keys
+// only pass through it when they are imported on registration.
+type DaemonProvider struct {
+	Socket string
+}
+
+func NewDaemonProvider(socket string) *DaemonProvider {
+	return &DaemonProvider{Socket: socket}
+}
+
+func (p *DaemonProvider) call(method string, args interface{}, reply interface{}) error {
+	conn, err := net.Dial("unix", p.Socket)
+	if err != nil {
+		return err
+	}
+	client := jsonrpc.NewClient(conn)
+	defer client.Close()
+	return client.Call(daemonService+"."+method, args, reply)
+}
+
+func (p *DaemonProvider) L1Signer(userid string) (imx.L1Signer, error) {
+	var address string
+	if err := p.call("L1Address", userid, &address); err != nil {
+		return nil, err
+	}
+	return &daemonL1Signer{provider: p, userid: userid, address: address}, nil
+}
+
+func (p *DaemonProvider) L2Signer(userid string) (imx.L2Signer, error) {
+	var address string
+	if err := p.call("L2Address", userid, &address); err != nil {
+		return nil, err
+	}
+	return &daemonL2Signer{provider: p, userid: userid, address: address}, nil
+}
+
+func (p *DaemonProvider) CreateKeys(req KeyRequest) (*Keys, error) {
+	var keys Keys
+	if err := p.call("CreateKeys", req, &keys); err != nil {
+		return nil, err
+	}
+	return &keys, nil
+}
+
+func (p *DaemonProvider) MigrateKeys(userid string) error {
+	var ok bool
+	return p.call("MigrateKeys", userid, &ok)
+}
+
+func (p *DaemonProvider) SealSecret(label string, secret []byte) ([]byte, error) {
+	var sealed []byte
+	err := p.call("SealSecret", SecretRequest{Label: label, Secret: secret}, &sealed)
+	return sealed, err
+}
+
+func (p *DaemonProvider) OpenSecret(label string, sealed []byte) ([]byte, error) {
+	var secret []byte
+	err := p.call("OpenSecret", SecretRequest{Label: label, Sealed: sealed}, &secret)
+	return secret, err
+}
+
+type daemonL1Signer struct {
+	provider *DaemonProvider
+	userid   string
+	address  string
+}
+
+func (s *daemonL1Signer) SignMessage(message string) ([]byte, error) {
+	var signature []byte
+	err := s.provider.call("L1SignMessage", SignRequest{UserID: s.userid, Message: message}, &signature)
+	return signature, err
+}
+
+func (s *daemonL1Signer) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var signed []byte
+	if err = s.provider.call("L1SignTx", SignRequest{UserID: s.userid, Tx: raw}, &signed); err != nil {
+		return nil, err
+	}
+	res := new(types.Transaction)
+	if err = res.UnmarshalBinary(signed); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (s *daemonL1Signer) GetAddress() string {
+	return s.address
+}
+
+type daemonL2Signer struct {
+	provider *DaemonProvider
+	userid   string
+	address  string
+}
+
+func (s *daemonL2Signer) SignMessage(message string) (string, error) {
+	var signature string
+	err := s.provider.call("L2SignMessage", SignRequest{UserID: s.userid, Message: message}, &signature)
+	return signature, err
+}
+
+func (s *daemonL2Signer) GetAddress() string {
+	return s.address
+}
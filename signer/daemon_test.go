@@ -0,0 +1,134 @@
+package signer
+
+import (
+	"math/big"
+	"net"
+	"nft-market/keystore"
+	"nft-market/storage"
+	"nft-market/wallet"
+	"strings"
+	"testing"
+)
+
+// serve starts a daemon with the provider on a socket in a temporary
+// directory and returns a provider asking it
+func serve(t *testing.T, p Provider) *DaemonProvider {
+	t.Helper()
+	socket := t.TempDir() + "/signerd.sock"
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go Serve(listener, p)
+	return NewDaemonProvider(socket)
+}
+
+func TestDaemonCreateKeys(t *testing.T) {
+	storage.SetBackend(storage.NewFSBackend(t.TempDir() + "/"))
+	if err := keystore.Init(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	// the Hardhat test mnemonic, the first user gets its first account
+	if err := wallet.Init("test test test test test test test test test test test junk"); err != nil {
+		t.Fatal(err)
+	}
+	p := serve(t, NewKeystoreProvider(big.NewInt(1)))
+
+	const starkAddress = "0x078c3293f5ee6607bc48b68c03b86ef492b75afcb444a702cfd6fde74e658ce5"
+	tests := []struct {
+		name         string
+		req          KeyRequest
+		address      string
+		starkAddress string
+		err          string
+	}{
+		{"derived", KeyRequest{UserID: "derived"}, "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266", starkAddress, ""},
+		{"imported", KeyRequest{
+			UserID:     "imported",
+			PrivateKey: "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+		}, "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", "", ""},
+		{"imported Stark key", KeyRequest{
+			UserID:          "stark",
+			PrivateKey:      "59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d",
+			StarkPrivateKey: "242bcc810538a112273bcf60d7bce0df08f9058746058b1a571c408159f07e1",
+		}, "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", starkAddress, ""},
+		{"invalid private key", KeyRequest{UserID: "invalid", PrivateKey: "1234"}, "", "", "invalid private key"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := storage.CreateUser(test.req.UserID); err != nil {
+				t.Fatal(err)
+			}
+			keys, err := p.CreateKeys(test.req)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if keys.Address != test.address {
+				t.Errorf("got address %v, want %v", keys.Address, test.address)
+			}
+			if test.starkAddress != "" && keys.StarkAddress != test.starkAddress {
+				t.Errorf("got Stark address %v, want %v", keys.StarkAddress, test.starkAddress)
+			}
+
+			// the sealed keys sign for the user
+			l1signer, err := p.L1Signer(test.req.UserID)
+			if err != nil || l1signer.GetAddress() != keys.Address {
+				t.Errorf("got L1 signer %v, %v, want %v", l1signer, err, keys.Address)
+			}
+			l2signer, err := p.L2Signer(test.req.UserID)
+			if err != nil || l2signer.GetAddress() != keys.StarkAddress {
+				t.Errorf("got L2 signer %v, %v, want %v", l2signer, err, keys.StarkAddress)
+			}
+		})
+	}
+
+	if index, err := storage.GetUserWalletIndex("derived"); err != nil || index != 0 {
+		t.Errorf("got wallet index %v, %v, want 0", index, err)
+	}
+	if _, err := storage.GetUserWalletIndex("imported"); err != storage.ErrNoWalletIndex {
+		t.Errorf("imported key got wallet index, error %v", err)
+	}
+}
+
+func TestDaemonSecret(t *testing.T) {
+	storage.SetBackend(storage.NewFSBackend(t.TempDir() + "/"))
+	if err := keystore.Init(strings.Repeat("ab", 32)); err != nil {
+		t.Fatal(err)
+	}
+	p := serve(t, NewKeystoreProvider(big.NewInt(1)))
+
+	sealed, err := p.SealSecret("apikey/0123456789abcdef", []byte("secret"))
+	if err != nil {
+		t.Fatalf("failed to seal: %v", err)
+	}
+	if secret, err := p.OpenSecret("apikey/0123456789abcdef", sealed); err != nil || string(secret) != "secret" {
+		t.Errorf("got %s, %v, want secret", secret, err)
+	}
+
+	// user keys can't be read over the socket
+	if err = storage.CreateUser("user"); err != nil {
+		t.Fatal(err)
+	}
+	if err = keystore.SetUserPrivateKey("user", []byte("59c6995e998f97a5a0044966f0945389dc9e86dae88c7a8412f4603b6b78690d")); err != nil {
+		t.Fatal(err)
+	}
+	key, _ := storage.GetUserSealedPrivateKey("user")
+	if _, err = p.OpenSecret("user/private_key", key); err == nil || err.Error() != "label is reserved for user keys" {
+		t.Errorf("got error %v, want label is reserved for user keys", err)
+	}
+}
+
+func TestDaemonNoKeyStore(t *testing.T) {
+	p := serve(t, NewStaticProvider())
+	if _, err := p.CreateKeys(KeyRequest{UserID: "user"}); err == nil || err.Error() != ErrNoKeyStore.Error() {
+		t.Errorf("got error %v, want %v", err, ErrNoKeyStore)
+	}
+}
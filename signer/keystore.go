@@ -0,0 +1,110 @@
+package signer
+
+import (
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
+	"math/big"
+	"nft-market/keystore"
+	"nft-market/storage"
+	"nft-market/wallet"
+)
+
+// KeystoreProvider opens the user's sealed keys from the local keystore.
+// New keys are generated or derived from the wallet seed in this process,
+// which needs the keystore master key and the wallet seed.
+type KeystoreProvider struct {
+	ChainID *big.Int
+}
+
+func NewKeystoreProvider(chainID *big.Int) *KeystoreProvider {
+	return &KeystoreProvider{ChainID: chainID}
+}
+
+func (p *KeystoreProvider) L1Signer(userid string) (imx.L1Signer, error) {
+	return keystore.L1Signer(userid, p.ChainID)
+}
+
+func (p *KeystoreProvider) L2Signer(userid string) (imx.L2Signer, error) {
+	return keystore.L2Signer(userid)
+}
+
+// privateKey returns the imported key of req, the key derived from the
+// wallet seed at a new wallet index of the user or a random one.
+func (p *KeystoreProvider) privateKey(req KeyRequest) (*ecdsa.PrivateKey, error) {
+	if req.PrivateKey != "" {
+		key, err := wallet.ParsePrivateKey(req.PrivateKey)
+		if err != nil {
+			return nil, errors.New("invalid private key")
+		}
+		return key, nil
+	}
+	if !wallet.Derived() {
+		return crypto.GenerateKey()
+	}
+
+	index, err := storage.NextWalletIndex()
+	if err != nil {
+		return nil, fmt.Errorf("failed to allocate wallet index: %v", err)
+	}
+	key, err := wallet.UserKey(index)
+	if err != nil {
+		return nil, err
+	}
+	if err = storage.SetUserWalletIndex(req.UserID, index); err != nil {
+		return nil, fmt.Errorf("failed to save wallet index: %v", err)
+	}
+	return key, nil
+}
+
+func (p *KeystoreProvider) CreateKeys(req KeyRequest) (*Keys, error) {
+	privateKey, err := p.privateKey(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = keystore.SetUserPrivateKey(req.UserID, []byte(hex.EncodeToString(crypto.FromECDSA(privateKey)))); err != nil {
+		return nil, fmt.Errorf("failed to seal private key: %v", err)
+	}
+
+	var starkPrivateKey *big.Int
+	if req.StarkPrivateKey != "" {
+		starkPrivateKey, err = wallet.ParseStarkPrivateKey(req.StarkPrivateKey)
+		if err != nil {
+			return nil, errors.New("invalid Stark private key")
+		}
+	} else {
+		starkPrivateKey, err = wallet.DeriveStarkKey(privateKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive Stark private key: %v", err)
+		}
+	}
+	if err = keystore.SetUserStarkPrivateKey(req.UserID, []byte(fmt.Sprintf("%x", starkPrivateKey))); err != nil {
+		return nil, fmt.Errorf("failed to seal Stark private key: %v", err)
+	}
+	l2signer, err := stark.NewSigner(starkPrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Keys{
+		PublicKey:    hex.EncodeToString(crypto.FromECDSAPub(&privateKey.PublicKey)[1:]),
+		Address:      crypto.PubkeyToAddress(privateKey.PublicKey).Hex(),
+		StarkAddress: l2signer.GetAddress(),
+	}, nil
+}
+
+func (p *KeystoreProvider) MigrateKeys(userid string) error {
+	return keystore.MigrateUser(userid)
+}
+
+func (p *KeystoreProvider) SealSecret(label string, secret []byte) ([]byte, error) {
+	return keystore.SealSecret(label, secret)
+}
+
+func (p *KeystoreProvider) OpenSecret(label string, sealed []byte) ([]byte, error) {
+	return keystore.OpenSecret(label, sealed)
+}
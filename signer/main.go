@@ -0,0 +1,126 @@
+package signer
+
+import (
+	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"nft-market/storage"
+)
+
+// ErrExternalWallet is returned for users who signed in with their own
+// wallet, the market holds none of their keys and can't sign for them.
+var ErrExternalWallet = errors.New("user signs with an external wallet, the market can't sign for it: link the wallet to a registered user to trade")
+
+// Provider hands out L1 (Ethereum) and L2 (Stark) signers for a user, so
+// that handlers can sign IMX requests without ever seeing the keys.
+type Provider interface {
+	L1Signer(userid string) (imx.L1Signer, error)
+	L2Signer(userid string) (imx.L2Signer, error)
+}
+
+// KeyRequest asks for the keys of a new user. PrivateKey and
+// StarkPrivateKey import hex encoded keys. An Ethereum key that isn't
+// imported is derived from the wallet seed if there is one and generated
+// otherwise, a Stark key that isn't is derived from the Ethereum key.
+type KeyRequest struct {
+	UserID          string `json:"userid"`
+	PrivateKey      string `json:"private_key,omitempty"`
+	StarkPrivateKey string `json:"stark_private_key,omitempty"`
+}
+
+// Keys are the public parts of the keys created for a user, PublicKey is
+// uncompressed without its 04 prefix.
+type Keys struct {
+	PublicKey    string `json:"public_key"`
+	Address      string `json:"address"`
+	StarkAddress string `json:"stark_address"`
+}
+
+// KeyStore is implemented by providers that hold user keys. Keys are
+// created, imported and sealed where they are used to sign, and so are the
+// secrets sealed with the same master key.
+type KeyStore interface {
+	CreateKeys(req KeyRequest) (*Keys, error)
+	MigrateKeys(userid string) error
+	SealSecret(label string, secret []byte) ([]byte, error)
+	OpenSecret(label string, sealed []byte) ([]byte, error)
+}
+
+// ErrNoKeyStore is returned for key operations when the provider doesn't
+// hold keys, like StaticProvider.
+var ErrNoKeyStore = errors.New("signer provider doesn't hold keys")
+
+var provider Provider
+
+// SetProvider selects where signers come from. It is expected to be called
+// once on startup, before serving any requests.
+func SetProvider(p Provider) {
+	provider = p
+}
+
+func L1Signer(userid string) (imx.L1Signer, error) {
+	if storage.UserExternal(userid) {
+		return nil, ErrExternalWallet
+	}
+	return provider.L1Signer(userid)
+}
+
+func L2Signer(userid string) (imx.L2Signer, error) {
+	if storage.UserExternal(userid) {
+		return nil, ErrExternalWallet
+	}
+	return provider.L2Signer(userid)
+}
+
+func keyStore() (KeyStore, error) {
+	keys, ok := provider.(KeyStore)
+	if !ok {
+		return nil, ErrNoKeyStore
+	}
+	return keys, nil
+}
+
+// CreateKeys creates or imports the keys of a new user and seals them.
+func CreateKeys(req KeyRequest) (*Keys, error) {
+	keys, err := keyStore()
+	if err != nil {
+		return nil, err
+	}
+	return keys.CreateKeys(req)
+}
+
+// MigrateKeys seals the keys of the user left in plaintext by older
+// versions.
+func MigrateKeys(userid string) error {
+	keys, err := keyStore()
+	if err != nil {
+		return err
+	}
+	return keys.MigrateKeys(userid)
+}
+
+// SealSecret seals a secret that isn't a user key, see keystore.SealSecret.
+func SealSecret(label string, secret []byte) ([]byte, error) {
+	keys, err := keyStore()
+	if err != nil {
+		return nil, err
+	}
+	return keys.SealSecret(label, secret)
+}
+
+// OpenSecret decrypts a secret sealed by SealSecret with the same label.
+func OpenSecret(label string, sealed []byte) ([]byte, error) {
+	keys, err := keyStore()
+	if err != nil {
+		return nil, err
+	}
+	return keys.OpenSecret(label, sealed)
+}
+
+// Failure returns what to report to the user for err getting a signer,
+// message unless the user is read-only, which is worth telling.
+func Failure(err error, message string) string {
+	if errors.Is(err, ErrExternalWallet) {
+		return err.Error()
+	}
+	return message
+}
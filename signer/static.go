@@ -0,0 +1,49 @@
+package signer
+
+import (
+	"errors"
+	"github.com/immutable/imx-core-sdk-golang/imx"
+	"sync"
+)
+
+// StaticProvider returns signers registered upfront with Add, it is meant
+// as a test double for running the handlers without any real keys.
+type StaticProvider struct {
+	mutex     sync.RWMutex
+	l1signers map[string]imx.L1Signer
+	l2signers map[string]imx.L2Signer
+}
+
+func NewStaticProvider() *StaticProvider {
+	return &StaticProvider{
+		l1signers: make(map[string]imx.L1Signer),
+		l2signers: make(map[string]imx.L2Signer),
+	}
+}
+
+func (p *StaticProvider) Add(userid string, l1signer imx.L1Signer, l2signer imx.L2Signer) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.l1signers[userid] = l1signer
+	p.l2signers[userid] = l2signer
+}
+
+func (p *StaticProvider) L1Signer(userid string) (imx.L1Signer, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	l1signer, ok := p.l1signers[userid]
+	if !ok {
+		return nil, errors.New("no L1 signer for user " + userid)
+	}
+	return l1signer, nil
+}
+
+func (p *StaticProvider) L2Signer(userid string) (imx.L2Signer, error) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	l2signer, ok := p.l2signers[userid]
+	if !ok {
+		return nil, errors.New("no L2 signer for user " + userid)
+	}
+	return l2signer, nil
+}
@@ -0,0 +1,315 @@
+// Package siwe verifies Sign-In with Ethereum (EIP-4361) messages, with
+// which users prove they control an Ethereum address by signing a message
+// carrying a nonce issued by the market.
+package siwe
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"nft-market/config"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Version = "1"
+
+const header = " wants you to sign in with your Ethereum account:"
+
+var nonceFormat = regexp.MustCompile(`^[a-zA-Z0-9]{8,}$`)
+
+// Message is a parsed EIP-4361 message, optional times are zero if absent.
+type Message struct {
+	Domain         string
+	Address        string
+	Statement      string
+	URI            string
+	Version        string
+	ChainID        int64
+	Nonce          string
+	IssuedAt       time.Time
+	ExpirationTime time.Time
+	NotBefore      time.Time
+	RequestID      string
+	Resources      []string
+}
+
+// String formats the message the way it is signed.
+func (m *Message) String() string {
+	var b strings.Builder
+	b.WriteString(m.Domain + header + "\n")
+	b.WriteString(m.Address + "\n\n")
+	if m.Statement != "" {
+		b.WriteString(m.Statement + "\n")
+	}
+	b.WriteString("\n")
+	b.WriteString("URI: " + m.URI + "\n")
+	b.WriteString("Version: " + m.Version + "\n")
+	b.WriteString("Chain ID: " + strconv.FormatInt(m.ChainID, 10) + "\n")
+	b.WriteString("Nonce: " + m.Nonce + "\n")
+	b.WriteString("Issued At: " + m.IssuedAt.UTC().Format(time.RFC3339))
+	if !m.ExpirationTime.IsZero() {
+		b.WriteString("\nExpiration Time: " + m.ExpirationTime.UTC().Format(time.RFC3339))
+	}
+	if !m.NotBefore.IsZero() {
+		b.WriteString("\nNot Before: " + m.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if m.RequestID != "" {
+		b.WriteString("\nRequest ID: " + m.RequestID)
+	}
+	if len(m.Resources) > 0 {
+		b.WriteString("\nResources:")
+		for _, resource := range m.Resources {
+			b.WriteString("\n- " + resource)
+		}
+	}
+	return b.String()
+}
+
+// Parse reads an EIP-4361 message. The address has to be EIP-55 checksummed.
+func Parse(text string) (*Message, error) {
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if len(lines) < 2 || !strings.HasSuffix(lines[0], header) {
+		return nil, errors.New("message isn't a Sign-In with Ethereum message")
+	}
+	m := &Message{Domain: strings.TrimSuffix(lines[0], header), Address: lines[1]}
+	if m.Domain == "" {
+		return nil, errors.New("message domain is missing")
+	}
+	if !common.IsHexAddress(m.Address) || common.HexToAddress(m.Address).Hex() != m.Address {
+		return nil, errors.New("message address isn't a checksummed Ethereum address")
+	}
+
+	// the statement is surrounded by blank lines, both kept if it is absent
+	i := 2
+	for i < len(lines) && lines[i] == "" {
+		i++
+	}
+	if i < len(lines) && !strings.HasPrefix(lines[i], "URI: ") {
+		m.Statement = lines[i]
+		i++
+		for i < len(lines) && lines[i] == "" {
+			i++
+		}
+	}
+
+	var chainID, issuedAt, expirationTime, notBefore string
+	fields := map[string]*string{
+		"URI":             &m.URI,
+		"Version":         &m.Version,
+		"Chain ID":        &chainID,
+		"Nonce":           &m.Nonce,
+		"Issued At":       &issuedAt,
+		"Expiration Time": &expirationTime,
+		"Not Before":      &notBefore,
+		"Request ID":      &m.RequestID,
+	}
+
+	for ; i < len(lines); i++ {
+		if lines[i] == "Resources:" {
+			for i++; i < len(lines); i++ {
+				resource, ok := strings.CutPrefix(lines[i], "- ")
+				if !ok {
+					return nil, errors.New("invalid message resource '" + lines[i] + "'")
+				}
+				m.Resources = append(m.Resources, resource)
+			}
+			break
+		}
+		name, value, ok := strings.Cut(lines[i], ": ")
+		field, known := fields[name]
+		if !ok || !known {
+			return nil, errors.New("invalid message line '" + lines[i] + "'")
+		}
+		if *field != "" {
+			return nil, errors.New("message has " + name + " twice")
+		}
+		*field = value
+	}
+
+	if m.URI == "" || m.Version == "" || chainID == "" || m.Nonce == "" || issuedAt == "" {
+		return nil, errors.New("message needs a URI, version, chain ID, nonce and issue time")
+	}
+	var err error
+	if m.ChainID, err = strconv.ParseInt(chainID, 10, 64); err != nil {
+		return nil, errors.New("invalid message chain ID")
+	}
+	if m.IssuedAt, err = time.Parse(time.RFC3339, issuedAt); err != nil {
+		return nil, errors.New("invalid message issue time")
+	}
+	if expirationTime != "" {
+		if m.ExpirationTime, err = time.Parse(time.RFC3339, expirationTime); err != nil {
+			return nil, errors.New("invalid message expiration time")
+		}
+	}
+	if notBefore != "" {
+		if m.NotBefore, err = time.Parse(time.RFC3339, notBefore); err != nil {
+			return nil, errors.New("invalid message not before time")
+		}
+	}
+	return m, nil
+}
+
+// RecoverAddress returns the address that signed the message with
+// personal_sign. The signature is hex encoded, with V either 0/1 or 27/28.
+func RecoverAddress(message string, signature string) (string, error) {
+	sig, err := hexutil.Decode(signature)
+	if err != nil || len(sig) != crypto.SignatureLength {
+		return "", errors.New("invalid signature")
+	}
+	if sig[crypto.RecoveryIDOffset] >= 27 {
+		sig[crypto.RecoveryIDOffset] -= 27
+	}
+	publicKey, err := crypto.SigToPub(accounts.TextHash([]byte(message)), sig)
+	if err != nil {
+		return "", errors.New("invalid signature")
+	}
+	return crypto.PubkeyToAddress(*publicKey).Hex(), nil
+}
+
+var (
+	cfg     = config.Default().SIWE
+	chainID = big.NewInt(0)
+)
+
+// Nonces aren't stored when they are handed out, so that anybody asking
+// for them can't fill up memory: a nonce carries its expiry and a random
+// part, authenticated with nonceKey. Only the nonces signed in with are
+// remembered until they expire, each can be signed in with once.
+var (
+	nonceKey   = newNonceKey()
+	usedMutex  sync.Mutex
+	usedNonces = make(map[string]time.Time)
+)
+
+func newNonceKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+func nonceMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, nonceKey)
+	mac.Write(payload)
+	return mac.Sum(nil)[:16]
+}
+
+// Configure sets the domain, URI and chain messages have to be issued for.
+// It is expected to be called once on startup, before serving any requests.
+func Configure(c config.SIWE, chain *big.Int) {
+	cfg = c
+	chainID = chain
+}
+
+func Enabled() bool {
+	return cfg.Domain != ""
+}
+
+// Domain and ChainID are what clients need to build a message.
+func Domain() string {
+	return cfg.Domain
+}
+
+func ChainID() int64 {
+	return chainID.Int64()
+}
+
+// SessionTTL is the lifetime of the API keys issued on sign in.
+func SessionTTL() time.Duration {
+	return time.Duration(cfg.SessionTTL)
+}
+
+// NewNonce issues a nonce to sign in with and returns it with its expiry.
+func NewNonce() (string, time.Time, error) {
+	expires := time.Now().Add(time.Duration(cfg.NonceTTL)).Truncate(time.Second)
+	payload := make([]byte, 16)
+	binary.BigEndian.PutUint64(payload, uint64(expires.Unix()))
+	if _, err := rand.Read(payload[8:]); err != nil {
+		return "", time.Time{}, err
+	}
+	return hex.EncodeToString(append(payload, nonceMAC(payload)...)), expires, nil
+}
+
+func useNonce(nonce string, now time.Time) bool {
+	data, err := hex.DecodeString(nonce)
+	if err != nil || len(data) != 32 || !hmac.Equal(data[16:], nonceMAC(data[:16])) {
+		return false
+	}
+	expires := time.Unix(int64(binary.BigEndian.Uint64(data)), 0)
+	if !now.Before(expires) {
+		return false
+	}
+
+	usedMutex.Lock()
+	defer usedMutex.Unlock()
+	if _, used := usedNonces[nonce]; used {
+		return false
+	}
+	for used, expiry := range usedNonces {
+		if now.After(expiry) {
+			delete(usedNonces, used)
+		}
+	}
+	usedNonces[nonce] = expires
+	return true
+}
+
+// Verify checks the signed message against the configuration and uses up
+// its nonce. It returns the parsed message, whose address is the signer's.
+func Verify(text string, signature string, now time.Time) (*Message, error) {
+	if !Enabled() {
+		return nil, errors.New("Sign-In with Ethereum is disabled")
+	}
+	m, err := Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	if m.Domain != cfg.Domain {
+		return nil, errors.New("message is for domain " + m.Domain)
+	}
+	if cfg.URI != "" && m.URI != cfg.URI {
+		return nil, errors.New("message is for URI " + m.URI)
+	}
+	if m.Version != Version {
+		return nil, errors.New("unsupported message version " + m.Version)
+	}
+	if m.ChainID != chainID.Int64() {
+		return nil, errors.New("message is for chain " + strconv.FormatInt(m.ChainID, 10))
+	}
+	if !m.ExpirationTime.IsZero() && !now.Before(m.ExpirationTime) {
+		return nil, errors.New("message has expired")
+	}
+	if !m.NotBefore.IsZero() && now.Before(m.NotBefore) {
+		return nil, errors.New("message isn't valid yet")
+	}
+	if !nonceFormat.MatchString(m.Nonce) {
+		return nil, errors.New("invalid message nonce")
+	}
+
+	signer, err := RecoverAddress(text, signature)
+	if err != nil {
+		return nil, err
+	}
+	if signer != m.Address {
+		return nil, errors.New("message isn't signed by " + m.Address)
+	}
+	// the nonce is only used up by a valid signature, so that nobody else
+	// can burn it
+	if !useNonce(m.Nonce, now) {
+		return nil, errors.New("message nonce is unknown or has expired")
+	}
+	return m, nil
+}
@@ -0,0 +1,201 @@
+package siwe
+
+import (
+	"crypto/ecdsa"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+	"nft-market/config"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAddress = "0x5aAeb6053F3E94C9b9A09f33669435E7Ef1BeAed"
+
+func TestParse(t *testing.T) {
+	issued := time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC)
+	full := &Message{
+		Domain:         "market.example",
+		Address:        testAddress,
+		Statement:      "Sign in to the market",
+		URI:            "https://market.example/login",
+		Version:        "1",
+		ChainID:        5,
+		Nonce:          "abcdef0123456789",
+		IssuedAt:       issued,
+		ExpirationTime: issued.Add(time.Hour),
+		NotBefore:      issued.Add(time.Minute),
+		RequestID:      "request-1",
+		Resources:      []string{"https://market.example/terms", "ipfs://bafy"},
+	}
+	minimal := &Message{
+		Domain:   "market.example",
+		Address:  testAddress,
+		URI:      "https://market.example/login",
+		Version:  "1",
+		ChainID:  1,
+		Nonce:    "abcdef0123456789",
+		IssuedAt: issued,
+	}
+	valid := minimal.String()
+
+	tests := []struct {
+		name string
+		text string
+		want *Message
+		err  string
+	}{
+		{"full", full.String(), full, ""},
+		{"minimal", valid, minimal, ""},
+		{"crlf", strings.ReplaceAll(valid, "\n", "\r\n"), minimal, ""},
+		{"not a message", "hello", nil, "message isn't a Sign-In with Ethereum message"},
+		{"no domain", strings.TrimPrefix(valid, "market.example"), nil, "message domain is missing"},
+		{"lower case address", strings.Replace(valid, testAddress, strings.ToLower(testAddress), 1), nil, "message address isn't a checksummed Ethereum address"},
+		{"short address", strings.Replace(valid, testAddress, testAddress[:40], 1), nil, "message address isn't a checksummed Ethereum address"},
+		{"unknown field", valid + "\nColour: red", nil, "invalid message line 'Colour: red'"},
+		{"field twice", valid + "\nNonce: 0123456789abcdef", nil, "message has Nonce twice"},
+		{"no nonce", strings.Replace(valid, "Nonce: abcdef0123456789\n", "", 1), nil, "message needs a URI, version, chain ID, nonce and issue time"},
+		{"chain ID", strings.Replace(valid, "Chain ID: 1", "Chain ID: one", 1), nil, "invalid message chain ID"},
+		{"issue time", strings.Replace(valid, "2023-05-01T12:00:00Z", "yesterday", 1), nil, "invalid message issue time"},
+		{"expiration time", valid + "\nExpiration Time: tomorrow", nil, "invalid message expiration time"},
+		{"not before time", valid + "\nNot Before: 2023-05-01", nil, "invalid message not before time"},
+		{"resource", valid + "\nResources:\nhttps://market.example/terms", nil, "invalid message resource 'https://market.example/terms'"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse(test.text)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func sign(t *testing.T, key *ecdsa.PrivateKey, text string) string {
+	t.Helper()
+
+	sig, err := crypto.Sign(accounts.TextHash([]byte(text)), key)
+	if err != nil {
+		t.Fatalf("failed to sign: %v", err)
+	}
+	return hexutil.Encode(sig)
+}
+
+// flip changes the last hex digit of nonce
+func flip(nonce string) string {
+	last := "0"
+	if strings.HasSuffix(nonce, "0") {
+		last = "1"
+	}
+	return nonce[:len(nonce)-1] + last
+}
+
+func TestVerify(t *testing.T) {
+	Configure(config.SIWE{
+		Domain:   "market.example",
+		URI:      "https://market.example/login",
+		NonceTTL: config.Duration(5 * time.Minute),
+	}, big.NewInt(5))
+
+	key, _ := crypto.GenerateKey()
+	other, _ := crypto.GenerateKey()
+	address := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tests := []struct {
+		name string
+		edit func(m *Message)
+		// signer signs the message instead of the key of its address
+		signer *ecdsa.PrivateKey
+		// v27 signs with V 27/28 instead of 0/1
+		v27 bool
+		// later verifies the message that long after now
+		later time.Duration
+		err   string
+	}{
+		{"valid", nil, nil, false, 0, ""},
+		{"valid 27", nil, nil, true, 0, ""},
+		{"not yet expired", func(m *Message) { m.ExpirationTime = now.Add(time.Second) }, nil, false, 0, ""},
+		{"valid from now", func(m *Message) { m.NotBefore = now }, nil, false, 0, ""},
+		{"domain", func(m *Message) { m.Domain = "evil.example" }, nil, false, 0, "message is for domain evil.example"},
+		{"URI", func(m *Message) { m.URI = "https://evil.example" }, nil, false, 0, "message is for URI https://evil.example"},
+		{"version", func(m *Message) { m.Version = "2" }, nil, false, 0, "unsupported message version 2"},
+		{"chain", func(m *Message) { m.ChainID = 1 }, nil, false, 0, "message is for chain 1"},
+		{"expired", func(m *Message) { m.ExpirationTime = now }, nil, false, 0, "message has expired"},
+		{"not yet valid", func(m *Message) { m.NotBefore = now.Add(time.Second) }, nil, false, 0, "message isn't valid yet"},
+		{"nonce format", func(m *Message) { m.Nonce = "short" }, nil, false, 0, "invalid message nonce"},
+		{"tampered nonce", func(m *Message) { m.Nonce = flip(m.Nonce) }, nil, false, 0, "message nonce is unknown or has expired"},
+		{"made up nonce", func(m *Message) { m.Nonce = strings.Repeat("ab", 32) }, nil, false, 0, "message nonce is unknown or has expired"},
+		{"expired nonce", nil, nil, false, 6 * time.Minute, "message nonce is unknown or has expired"},
+		{"other signer", nil, other, false, 0, "message isn't signed by " + address},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			nonce, _, err := NewNonce()
+			if err != nil {
+				t.Fatalf("failed to issue nonce: %v", err)
+			}
+			m := &Message{
+				Domain:   "market.example",
+				Address:  address,
+				URI:      "https://market.example/login",
+				Version:  Version,
+				ChainID:  5,
+				Nonce:    nonce,
+				IssuedAt: now,
+			}
+			if test.edit != nil {
+				test.edit(m)
+			}
+			signer := key
+			if test.signer != nil {
+				signer = test.signer
+			}
+			text := m.String()
+			signature := sign(t, signer, text)
+			if test.v27 {
+				signature = signature[:len(signature)-2] + map[string]string{"00": "1b", "01": "1c"}[signature[len(signature)-2:]]
+			}
+
+			got, err := Verify(text, signature, now.Add(test.later))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if got.Address != address {
+				t.Errorf("got address %v, want %v", got.Address, address)
+			}
+
+			// every nonce can only be signed in with once
+			if _, err = Verify(text, signature, now.Add(test.later)); err == nil || err.Error() != "message nonce is unknown or has expired" {
+				t.Errorf("replay got error %v, want message nonce is unknown or has expired", err)
+			}
+		})
+	}
+}
+
+func TestVerifyDisabled(t *testing.T) {
+	Configure(config.SIWE{}, big.NewInt(5))
+	if _, err := Verify("", "", time.Now()); err == nil || err.Error() != "Sign-In with Ethereum is disabled" {
+		t.Errorf("got error %v, want Sign-In with Ethereum is disabled", err)
+	}
+}
@@ -0,0 +1,1033 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/holiman/uint256"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+var (
+	boltUsersBucket       = []byte("users")
+	boltCollectionsBucket = []byte("collections")
+	boltTokensBucket      = []byte("tokens")
+	boltMetaBucket        = []byte("meta")
+	boltJobsBucket        = []byte("jobs")
+	boltKeysBucket        = []byte("keys")
+	boltWithdrawalsBucket = []byte("withdrawals")
+	boltHistoryBucket     = []byte("history")
+	boltTokenIndexKey     = []byte("token_index")
+	boltWalletIndexKey    = []byte("wallet_index")
+	boltMigrationPrefix   = "migrated/"
+)
+
+var errBoltNotFound = errors.New("not found")
+
+// BoltBackend keeps the whole market in a single bbolt database file.
+// Every user and token is a nested bucket holding the same keys as the
+// file names used by FSBackend, and a user's collections are nested
+// buckets below the user with one key per token they contain.
+type BoltBackend struct {
+	db *bolt.DB
+}
+
+func NewBoltBackend(path string) (*BoltBackend, error) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return nil, errors.New("failed to create database directory")
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return &BoltBackend{db: db}, nil
+}
+
+func (b *BoltBackend) Close() error {
+	return b.db.Close()
+}
+
+func boltUser(tx *bolt.Tx, userid string) *bolt.Bucket {
+	users := tx.Bucket(boltUsersBucket)
+	if users == nil {
+		return nil
+	}
+	return users.Bucket([]byte(userid))
+}
+
+func boltCollection(tx *bolt.Tx, userid string, collectionid string) *bolt.Bucket {
+	user := boltUser(tx, userid)
+	if user == nil {
+		return nil
+	}
+	collections := user.Bucket(boltCollectionsBucket)
+	if collections == nil {
+		return nil
+	}
+	return collections.Bucket([]byte(collectionid))
+}
+
+func boltToken(tx *bolt.Tx, tokenid string) *bolt.Bucket {
+	tokens := tx.Bucket(boltTokensBucket)
+	if tokens == nil {
+		return nil
+	}
+	return tokens.Bucket([]byte(tokenid))
+}
+
+// boltValue copies the value out of the bucket, since bbolt only guarantees
+// it to be valid for the lifetime of the transaction.
+func boltValue(bucket *bolt.Bucket, key string) ([]byte, error) {
+	if bucket == nil {
+		return nil, errBoltNotFound
+	}
+	value := bucket.Get([]byte(key))
+	if value == nil {
+		return nil, errBoltNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (b *BoltBackend) getUserValue(userid string, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, err = boltValue(boltUser(tx, userid), key)
+		return err
+	})
+	return value, err
+}
+
+func (b *BoltBackend) setUserValue(userid string, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errors.New("user " + userid + " doesn't exist")
+		}
+		return user.Put([]byte(key), value)
+	})
+}
+
+func (b *BoltBackend) getTokenValue(tokenid string, key string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, err = boltValue(boltToken(tx, tokenid), key)
+		return err
+	})
+	return value, err
+}
+
+func (b *BoltBackend) setTokenValue(tokenid string, key string, value []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		token := boltToken(tx, tokenid)
+		if token == nil {
+			return errors.New("token " + tokenid + " doesn't exist")
+		}
+		return token.Put([]byte(key), value)
+	})
+}
+
+func (b *BoltBackend) hasTokenValue(tokenid string, key string) bool {
+	_, err := b.getTokenValue(tokenid, key)
+	return err == nil
+}
+
+func (b *BoltBackend) Exists() bool {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(boltUsersBucket) == nil {
+			return errBoltNotFound
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (b *BoltBackend) Create() error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltUsersBucket, boltTokensBucket, boltMetaBucket, boltJobsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.New("failed to create user storage")
+	}
+	return nil
+}
+
+func (b *BoltBackend) ListUsers() ([]string, error) {
+	var users []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltUsersBucket)
+		if bucket == nil {
+			return errBoltNotFound
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if v == nil {
+				users = append(users, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read user storage")
+	}
+	return users, nil
+}
+
+func (b *BoltBackend) UserExists(userid string) bool {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if boltUser(tx, userid) == nil {
+			return errBoltNotFound
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (b *BoltBackend) CreateUser(userid string) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		users, err := tx.CreateBucketIfNotExists(boltUsersBucket)
+		if err != nil {
+			return err
+		}
+		user, err := users.CreateBucketIfNotExists([]byte(userid))
+		if err != nil {
+			return err
+		}
+		_, err = user.CreateBucketIfNotExists(boltCollectionsBucket)
+		return err
+	})
+	if err != nil {
+		return errors.New("failed to create user infrastructure")
+	}
+	return nil
+}
+
+func (b *BoltBackend) RemoveUser(userid string) {
+	_ = b.db.Update(func(tx *bolt.Tx) error {
+		users := tx.Bucket(boltUsersBucket)
+		if users == nil {
+			return nil
+		}
+		return users.DeleteBucket([]byte(userid))
+	})
+}
+
+func (b *BoltBackend) GetUserSealedPrivateKey(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "private_key")
+}
+
+func (b *BoltBackend) SetUserSealedPrivateKey(userid string, key []byte) error {
+	return b.setUserValue(userid, "private_key", key)
+}
+
+func (b *BoltBackend) GetUserPublicKey(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "public_key")
+}
+
+func (b *BoltBackend) SetUserPublicKey(userid string, key []byte) error {
+	return b.setUserValue(userid, "public_key", key)
+}
+
+func (b *BoltBackend) GetUserAddress(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "address")
+}
+
+func (b *BoltBackend) SetUserAddress(userid string, address []byte) error {
+	return b.setUserValue(userid, "address", address)
+}
+
+func (b *BoltBackend) GetUserLinkedAddress(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "linked_address")
+}
+
+func (b *BoltBackend) SetUserLinkedAddress(userid string, address []byte) error {
+	return b.setUserValue(userid, "linked_address", address)
+}
+
+func (b *BoltBackend) UserExternal(userid string) bool {
+	_, err := b.getUserValue(userid, "external")
+	return err == nil
+}
+
+func (b *BoltBackend) SetUserExternal(userid string) error {
+	return b.setUserValue(userid, "external", []byte{1})
+}
+
+func (b *BoltBackend) GetUserSealedStarkPrivateKey(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "stark_private_key")
+}
+
+func (b *BoltBackend) SetUserSealedStarkPrivateKey(userid string, key []byte) error {
+	return b.setUserValue(userid, "stark_private_key", key)
+}
+
+func (b *BoltBackend) GetUserStarkAddress(userid string) ([]byte, error) {
+	return b.getUserValue(userid, "stark_address")
+}
+
+func (b *BoltBackend) SetUserStarkAddress(userid string, address []byte) error {
+	return b.setUserValue(userid, "stark_address", address)
+}
+
+func (b *BoltBackend) GetUserProjectID(userid string) (int32, error) {
+	value, err := b.getUserValue(userid, "project_id")
+	if errors.Is(err, errBoltNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	projectID, err := strconv.ParseInt(string(value), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid project ID of user " + userid)
+	}
+	return int32(projectID), nil
+}
+
+func (b *BoltBackend) SetUserProjectID(userid string, projectID int32) error {
+	return b.setUserValue(userid, "project_id", []byte(strconv.FormatInt(int64(projectID), 10)))
+}
+
+func (b *BoltBackend) GetUserWalletIndex(userid string) (uint32, error) {
+	value, err := b.getUserValue(userid, "wallet_index")
+	if errors.Is(err, errBoltNotFound) {
+		return 0, ErrNoWalletIndex
+	}
+	if err != nil {
+		return 0, err
+	}
+	index, err := strconv.ParseUint(string(value), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid wallet index of user " + userid)
+	}
+	return uint32(index), nil
+}
+
+func (b *BoltBackend) SetUserWalletIndex(userid string, index uint32) error {
+	return b.setUserValue(userid, "wallet_index", []byte(strconv.FormatUint(uint64(index), 10)))
+}
+
+func (b *BoltBackend) GetUserRegistration(userid string) (*Registration, error) {
+	value, err := b.getUserValue(userid, "imx_registration")
+	if errors.Is(err, errBoltNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	registration := new(Registration)
+	if err = json.Unmarshal(value, registration); err != nil {
+		return nil, err
+	}
+	return registration, nil
+}
+
+func (b *BoltBackend) SetUserRegistration(userid string, registration *Registration) error {
+	data, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	return b.setUserValue(userid, "imx_registration", data)
+}
+
+func (b *BoltBackend) CollectionExists(userid string, collectionid string) bool {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if boltCollection(tx, userid, collectionid) == nil {
+			return errBoltNotFound
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (b *BoltBackend) CreateCollection(userid string, c *Collection) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errBoltNotFound
+		}
+		collections, err := user.CreateBucketIfNotExists(boltCollectionsBucket)
+		if err != nil {
+			return err
+		}
+		collection, err := collections.CreateBucket([]byte(c.ID))
+		if err != nil {
+			return err
+		}
+		if err = collection.Put([]byte("contract_address"), []byte(c.ContractAddress)); err != nil {
+			return err
+		}
+		if err = boltWriteCollection(collection, c); err != nil {
+			return err
+		}
+		if c.ProjectID != 0 {
+			if err = collection.Put([]byte("project_id"), []byte(strconv.FormatInt(int64(c.ProjectID), 10))); err != nil {
+				return err
+			}
+		}
+		created, err := time.Now().UTC().MarshalText()
+		if err != nil {
+			return err
+		}
+		if err = collection.Put([]byte("created"), created); err != nil {
+			return err
+		}
+		if err = collection.Put([]byte("version"), []byte("1")); err != nil {
+			return err
+		}
+		_, err = collection.CreateBucket(boltTokensBucket)
+		return err
+	})
+	if err != nil {
+		return errors.New("failed to create collection")
+	}
+	return nil
+}
+
+func (b *BoltBackend) GetUserCollectionContractAddress(userid string, collectionid string) ([]byte, error) {
+	var value []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		value, err = boltValue(boltCollection(tx, userid, collectionid), "contract_address")
+		return err
+	})
+	return value, err
+}
+
+// boltCollectionTokens maps collection IDs to the tokens in them.
+func boltCollectionTokens(tx *bolt.Tx) map[string][]string {
+	tokens := make(map[string][]string)
+	bucket := tx.Bucket(boltTokensBucket)
+	if bucket == nil {
+		return tokens
+	}
+	_ = bucket.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return nil
+		}
+		collection := bucket.Bucket(k).Get([]byte("collection_id"))
+		if collection != nil {
+			tokens[string(collection)] = append(tokens[string(collection)], string(k))
+		}
+		return nil
+	})
+	return tokens
+}
+
+// boltWriteCollection writes the editable fields of the collection
+func boltWriteCollection(bucket *bolt.Bucket, collection *Collection) error {
+	if err := bucket.Put([]byte("name"), []byte(collection.Name)); err != nil {
+		return err
+	}
+	if err := bucket.Put([]byte("description"), []byte(collection.Description)); err != nil {
+		return err
+	}
+	for name, value := range collection.urls() {
+		if err := bucket.Put([]byte(name), []byte(*value)); err != nil {
+			return err
+		}
+	}
+	schema, err := json.Marshal(collection.MetadataSchema)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte("metadata_schema"), schema)
+}
+
+// boltReadCollection returns nil for collections the user only holds tokens of.
+func boltReadCollection(bucket *bolt.Bucket, collectionid string) *Collection {
+	if bucket == nil || bucket.Get([]byte("contract_address")) == nil {
+		return nil
+	}
+	collection := &Collection{
+		ID:              collectionid,
+		ContractAddress: string(bucket.Get([]byte("contract_address"))),
+		Name:            string(bucket.Get([]byte("name"))),
+		Description:     string(bucket.Get([]byte("description"))),
+		Version:         1,
+	}
+	if version, err := strconv.Atoi(string(bucket.Get([]byte("version")))); err == nil {
+		collection.Version = version
+	}
+	if projectID, err := strconv.ParseInt(string(bucket.Get([]byte("project_id"))), 10, 32); err == nil {
+		collection.ProjectID = int32(projectID)
+	}
+	for name, value := range collection.urls() {
+		*value = string(bucket.Get([]byte(name)))
+	}
+	if schema := bucket.Get([]byte("metadata_schema")); schema != nil {
+		_ = json.Unmarshal(schema, &collection.MetadataSchema)
+	}
+	if created := bucket.Get([]byte("created")); created != nil {
+		_ = collection.Created.UnmarshalText(created)
+	}
+	return collection
+}
+
+func (b *BoltBackend) ListUserCollections(userid string) ([]Collection, error) {
+	var collections []Collection
+	err := b.db.View(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errors.New("user " + userid + " doesn't exist")
+		}
+		bucket := user.Bucket(boltCollectionsBucket)
+		if bucket == nil {
+			return nil
+		}
+		tokens := boltCollectionTokens(tx)
+		return bucket.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			collection := boltReadCollection(bucket.Bucket(k), string(k))
+			if collection == nil {
+				return nil
+			}
+			collection.Tokens = len(tokens[collection.ID])
+			collections = append(collections, *collection)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read collection storage")
+	}
+	return collections, nil
+}
+
+func (b *BoltBackend) GetUserCollection(userid string, collectionid string) (*Collection, error) {
+	var collection *Collection
+	err := b.db.View(func(tx *bolt.Tx) error {
+		collection = boltReadCollection(boltCollection(tx, userid, collectionid), collectionid)
+		if collection == nil {
+			return errors.New("collection " + collectionid + " doesn't exist")
+		}
+		collection.Tokens = len(boltCollectionTokens(tx)[collectionid])
+		return nil
+	})
+	return collection, err
+}
+
+func (b *BoltBackend) UpdateCollection(userid string, collectionid string, version int, update CollectionUpdate, editor string) (*Collection, error) {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := boltCollection(tx, userid, collectionid)
+		collection := boltReadCollection(bucket, collectionid)
+		if collection == nil {
+			return errors.New("collection " + collectionid + " doesn't exist")
+		}
+		if collection.Version != version {
+			return ErrVersionConflict
+		}
+		changes := update.apply(collection)
+		if len(changes) == 0 {
+			return nil
+		}
+		collection.Version++
+
+		change, err := json.Marshal(CollectionChange{
+			Version: collection.Version,
+			UserID:  editor,
+			Time:    time.Now().UTC(),
+			Fields:  changes,
+		})
+		if err != nil {
+			return err
+		}
+		history, err := bucket.CreateBucketIfNotExists(boltHistoryBucket)
+		if err != nil {
+			return err
+		}
+		key := make([]byte, 8)
+		binary.BigEndian.PutUint64(key, uint64(collection.Version))
+		if err = history.Put(key, change); err != nil {
+			return err
+		}
+
+		if err = boltWriteCollection(bucket, collection); err != nil {
+			return err
+		}
+		return bucket.Put([]byte("version"), []byte(strconv.Itoa(collection.Version)))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.GetUserCollection(userid, collectionid)
+}
+
+func (b *BoltBackend) GetCollectionHistory(userid string, collectionid string) ([]CollectionChange, error) {
+	var history []CollectionChange
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := boltCollection(tx, userid, collectionid)
+		if boltReadCollection(bucket, collectionid) == nil {
+			return errors.New("collection " + collectionid + " doesn't exist")
+		}
+		changes := bucket.Bucket(boltHistoryBucket)
+		if changes == nil {
+			return nil
+		}
+		// keys are big endian versions, so the cursor walks them in order
+		return changes.ForEach(func(k, v []byte) error {
+			var change CollectionChange
+			if err := json.Unmarshal(v, &change); err != nil {
+				return errors.New("failed to read collection history")
+			}
+			history = append(history, change)
+			return nil
+		})
+	})
+	return history, err
+}
+
+func (b *BoltBackend) ListCollectionTokens(collectionid string) ([]string, error) {
+	var tokens []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		tokens = boltCollectionTokens(tx)[collectionid]
+		return nil
+	})
+	return tokens, err
+}
+
+func boltWithdrawalKey(withdrawID int32) []byte {
+	return []byte(strconv.FormatInt(int64(withdrawID), 10))
+}
+
+func (b *BoltBackend) ListUserWithdrawals(userid string) ([]Withdrawal, error) {
+	var withdrawals []Withdrawal
+	err := b.db.View(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errors.New("user " + userid + " doesn't exist")
+		}
+		bucket := user.Bucket(boltWithdrawalsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var withdrawal Withdrawal
+			if err := json.Unmarshal(v, &withdrawal); err != nil {
+				return err
+			}
+			withdrawals = append(withdrawals, withdrawal)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read withdrawal storage")
+	}
+	return withdrawals, nil
+}
+
+func (b *BoltBackend) GetUserWithdrawal(userid string, withdrawID int32) (*Withdrawal, error) {
+	var data []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errBoltNotFound
+		}
+		var err error
+		data, err = boltValue(user.Bucket(boltWithdrawalsBucket), string(boltWithdrawalKey(withdrawID)))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	withdrawal := new(Withdrawal)
+	if err = json.Unmarshal(data, withdrawal); err != nil {
+		return nil, err
+	}
+	return withdrawal, nil
+}
+
+func boltSetWithdrawal(user *bolt.Bucket, withdrawal *Withdrawal) error {
+	withdrawals, err := user.CreateBucketIfNotExists(boltWithdrawalsBucket)
+	if err != nil {
+		return errors.New("failed to create withdrawal storage")
+	}
+	data, err := json.Marshal(withdrawal)
+	if err != nil {
+		return err
+	}
+	return withdrawals.Put(boltWithdrawalKey(withdrawal.ID), data)
+}
+
+func (b *BoltBackend) SetUserWithdrawal(userid string, withdrawal *Withdrawal) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errors.New("user " + userid + " doesn't exist")
+		}
+		return boltSetWithdrawal(user, withdrawal)
+	})
+}
+
+func (b *BoltBackend) MigrateUserWithdraw(userid string) (*Withdrawal, error) {
+	var withdrawal *Withdrawal
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		user := boltUser(tx, userid)
+		if user == nil {
+			return errors.New("user " + userid + " doesn't exist")
+		}
+		data := user.Get([]byte("withdraw"))
+		if data == nil {
+			return nil
+		}
+
+		var err error
+		if withdrawal, err = legacyWithdrawal(data); err != nil {
+			return err
+		}
+		if err = boltSetWithdrawal(user, withdrawal); err != nil {
+			return err
+		}
+		return user.Delete([]byte("withdraw"))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return withdrawal, nil
+}
+
+func (b *BoltBackend) NextTokenIndex() (*uint256.Int, error) {
+	tokenID := new(uint256.Int)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return errors.New("failed to create token storage")
+		}
+		tokenID.SetBytes(meta.Get(boltTokenIndexKey))
+		tokenID.AddUint64(tokenID, 1)
+		return meta.Put(boltTokenIndexKey, tokenID.Bytes())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tokenID, nil
+}
+
+func (b *BoltBackend) NextWalletIndex() (uint32, error) {
+	var index uint64
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return errors.New("failed to create user storage")
+		}
+		if value := meta.Get(boltWalletIndexKey); value != nil {
+			if index, err = strconv.ParseUint(string(value), 10, 32); err != nil {
+				return errors.New("invalid wallet index")
+			}
+		}
+		if index >= 1<<31 {
+			return errors.New("wallet indexes are exhausted")
+		}
+		return meta.Put(boltWalletIndexKey, []byte(strconv.FormatUint(index+1, 10)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return uint32(index), nil
+}
+
+func (b *BoltBackend) Migrated(name string) bool {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta == nil || meta.Get([]byte(boltMigrationPrefix+name)) == nil {
+			return errBoltNotFound
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (b *BoltBackend) SetMigrated(name string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return errors.New("failed to create migration storage")
+		}
+		return meta.Put([]byte(boltMigrationPrefix+name), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+}
+
+func (b *BoltBackend) TokenExists(tokenid string) bool {
+	err := b.db.View(func(tx *bolt.Tx) error {
+		if boltToken(tx, tokenid) == nil {
+			return errBoltNotFound
+		}
+		return nil
+	})
+	return err == nil
+}
+
+func (b *BoltBackend) CreateToken(userid string, collectionid string, tokenid string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		tokens, err := tx.CreateBucketIfNotExists(boltTokensBucket)
+		if err != nil {
+			return errors.New("failed to reserve token")
+		}
+		if _, err = tokens.CreateBucket([]byte(tokenid)); err != nil {
+			return errors.New("failed to reserve token")
+		}
+
+		collection := boltCollection(tx, userid, collectionid)
+		if collection == nil {
+			return errors.New("failed to reserve token in collection")
+		}
+		collectionTokens, err := collection.CreateBucketIfNotExists(boltTokensBucket)
+		if err != nil {
+			return errors.New("failed to reserve token in collection")
+		}
+		return collectionTokens.Put([]byte(tokenid), []byte{})
+	})
+}
+
+func (b *BoltBackend) RemoveToken(userid string, collectionid string, tokenid string) {
+	_ = b.db.Update(func(tx *bolt.Tx) error {
+		if tokens := tx.Bucket(boltTokensBucket); tokens != nil {
+			_ = tokens.DeleteBucket([]byte(tokenid))
+		}
+		if collection := boltCollection(tx, userid, collectionid); collection != nil {
+			if collectionTokens := collection.Bucket(boltTokensBucket); collectionTokens != nil {
+				_ = collectionTokens.Delete([]byte(tokenid))
+			}
+		}
+		return nil
+	})
+}
+
+// MoveToken changes the owner and relocates the token between the users'
+// collections in one transaction, so a failure leaves both untouched.
+func (b *BoltBackend) MoveToken(tokenid string, to string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		token := boltToken(tx, tokenid)
+		from, err := boltValue(token, "user_id")
+		if err != nil {
+			return err
+		}
+		collectionid, err := boltValue(token, "collection_id")
+		if err != nil {
+			return err
+		}
+
+		source := boltCollection(tx, string(from), string(collectionid))
+		if source == nil || source.Bucket(boltTokensBucket) == nil || source.Bucket(boltTokensBucket).Get([]byte(tokenid)) == nil {
+			return errors.New("token " + tokenid + " is not in owner's collection")
+		}
+
+		receiver := boltUser(tx, to)
+		if receiver == nil {
+			return errors.New("user " + to + " doesn't exist")
+		}
+
+		// removed first, so that moving to the owner puts it back
+		if err = source.Bucket(boltTokensBucket).Delete([]byte(tokenid)); err != nil {
+			return err
+		}
+		collections, err := receiver.CreateBucketIfNotExists(boltCollectionsBucket)
+		if err != nil {
+			return err
+		}
+		destination, err := collections.CreateBucketIfNotExists(collectionid)
+		if err != nil {
+			return err
+		}
+		destinationTokens, err := destination.CreateBucketIfNotExists(boltTokensBucket)
+		if err != nil {
+			return err
+		}
+
+		if err = destinationTokens.Put([]byte(tokenid), []byte{}); err != nil {
+			return err
+		}
+		return token.Put([]byte("user_id"), []byte(to))
+	})
+}
+
+func (b *BoltBackend) GetTokenOwner(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "user_id")
+}
+
+func (b *BoltBackend) SetTokenOwner(tokenid string, userid string) error {
+	return b.setTokenValue(tokenid, "user_id", []byte(userid))
+}
+
+func (b *BoltBackend) GetTokenCollection(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "collection_id")
+}
+
+func (b *BoltBackend) SetTokenCollection(tokenid string, collectionid string) error {
+	return b.setTokenValue(tokenid, "collection_id", []byte(collectionid))
+}
+
+func (b *BoltBackend) GetTokenMetadata(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "metadata")
+}
+
+func (b *BoltBackend) SetTokenMetadata(tokenid string, metadata []byte) error {
+	return b.setTokenValue(tokenid, "metadata", metadata)
+}
+
+func (b *BoltBackend) TokenMinted(userid string, tokenid string) bool {
+	return b.hasTokenValue(tokenid, "minted")
+}
+
+func (b *BoltBackend) GetTokenMintedID(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "minted")
+}
+
+func (b *BoltBackend) SetTokenMintedID(userid string, tokenid string, imxtokenid string) error {
+	return b.setTokenValue(tokenid, "minted", []byte(imxtokenid))
+}
+
+func (b *BoltBackend) TokenSelling(userid string, tokenid string) bool {
+	return b.hasTokenValue(tokenid, "selling")
+}
+
+func (b *BoltBackend) GetTokenSellingID(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "selling")
+}
+
+func (b *BoltBackend) SetTokenSellingID(tokenid string, sellingID string) error {
+	return b.setTokenValue(tokenid, "selling", []byte(sellingID))
+}
+
+func (b *BoltBackend) GetTokenSellingPrice(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "selling_price")
+}
+
+func (b *BoltBackend) SetTokenSellingPrice(tokenid string, price string) error {
+	return b.setTokenValue(tokenid, "selling_price", []byte(price))
+}
+
+func (b *BoltBackend) GetTokenSellingCurrency(tokenid string) ([]byte, error) {
+	return b.getTokenValue(tokenid, "selling_currency")
+}
+
+func (b *BoltBackend) SetTokenSellingCurrency(tokenid string, currency string) error {
+	return b.setTokenValue(tokenid, "selling_currency", []byte(currency))
+}
+
+func (b *BoltBackend) RemoveTokenSelling(tokenid string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		token := boltToken(tx, tokenid)
+		if token == nil || token.Get([]byte("selling")) == nil {
+			return errBoltNotFound
+		}
+		if err := token.Delete([]byte("selling_price")); err != nil {
+			return err
+		}
+		if err := token.Delete([]byte("selling_currency")); err != nil {
+			return err
+		}
+		return token.Delete([]byte("selling"))
+	})
+}
+
+func (b *BoltBackend) GetTokenSellingList(userid string) ([]string, error) {
+	var list []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		tokens := tx.Bucket(boltTokensBucket)
+		if tokens == nil {
+			return errBoltNotFound
+		}
+		return tokens.ForEach(func(k, v []byte) error {
+			if v != nil {
+				return nil
+			}
+			if tokens.Bucket(k).Get([]byte("selling")) != nil {
+				list = append(list, string(k))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read token storage")
+	}
+	return list, nil
+}
+
+func (b *BoltBackend) ListJobs() ([]string, error) {
+	var jobs []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltJobsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			jobs = append(jobs, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read job storage")
+	}
+	return jobs, nil
+}
+
+func (b *BoltBackend) GetJob(jobid string) ([]byte, error) {
+	var job []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		job, err = boltValue(tx.Bucket(boltJobsBucket), jobid)
+		return err
+	})
+	return job, err
+}
+
+func (b *BoltBackend) SetJob(jobid string, job []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		jobs, err := tx.CreateBucketIfNotExists(boltJobsBucket)
+		if err != nil {
+			return errors.New("failed to create job storage")
+		}
+		return jobs.Put([]byte(jobid), job)
+	})
+}
+
+func (b *BoltBackend) ListAPIKeys() ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltKeysBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.New("failed to read API key storage")
+	}
+	return keys, nil
+}
+
+func (b *BoltBackend) GetAPIKey(keyid string) ([]byte, error) {
+	var key []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		key, err = boltValue(tx.Bucket(boltKeysBucket), keyid)
+		return err
+	})
+	return key, err
+}
+
+func (b *BoltBackend) SetAPIKey(keyid string, key []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		keys, err := tx.CreateBucketIfNotExists(boltKeysBucket)
+		if err != nil {
+			return errors.New("failed to create API key storage")
+		}
+		return keys.Put([]byte(keyid), key)
+	})
+}
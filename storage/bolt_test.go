@@ -0,0 +1,416 @@
+package storage
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// openBolt returns a new database in a temporary directory, with the
+// buckets the market creates on first start
+func openBolt(t *testing.T) *BoltBackend {
+	t.Helper()
+
+	db, err := NewBoltBackend(filepath.Join(t.TempDir(), "market.db"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err = db.Create(); err != nil {
+		t.Fatalf("failed to create storage: %v", err)
+	}
+	return db
+}
+
+func TestBoltUser(t *testing.T) {
+	db := openBolt(t)
+	if users, err := db.ListUsers(); err != nil || len(users) != 0 {
+		t.Fatalf("got users %v, %v, want none", users, err)
+	}
+
+	for _, userid := range []string{"alice", "bob"} {
+		if err := db.CreateUser(userid); err != nil {
+			t.Fatalf("failed to create user %v: %v", userid, err)
+		}
+	}
+	if users, err := db.ListUsers(); err != nil || !reflect.DeepEqual(users, []string{"alice", "bob"}) {
+		t.Errorf("got users %v, %v, want alice and bob", users, err)
+	}
+
+	tests := []struct {
+		name string
+		set  func(userid string) error
+		get  func(userid string) ([]byte, error)
+	}{
+		{"private key", func(userid string) error { return db.SetUserSealedPrivateKey(userid, []byte("sealed")) }, db.GetUserSealedPrivateKey},
+		{"Stark private key", func(userid string) error { return db.SetUserSealedStarkPrivateKey(userid, []byte("sealed")) }, db.GetUserSealedStarkPrivateKey},
+		{"public key", func(userid string) error { return db.SetUserPublicKey(userid, []byte("public")) }, db.GetUserPublicKey},
+		{"address", func(userid string) error { return db.SetUserAddress(userid, []byte("0xaddress")) }, db.GetUserAddress},
+		{"linked address", func(userid string) error { return db.SetUserLinkedAddress(userid, []byte("0xlinked")) }, db.GetUserLinkedAddress},
+		{"Stark address", func(userid string) error { return db.SetUserStarkAddress(userid, []byte("0xstark")) }, db.GetUserStarkAddress},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.get("alice"); err == nil {
+				t.Fatal("got a value before it was set")
+			}
+			if err := test.set("alice"); err != nil {
+				t.Fatalf("failed to set: %v", err)
+			}
+			value, err := test.get("alice")
+			if err != nil {
+				t.Fatalf("failed to get: %v", err)
+			}
+			// values are only kept for the user they are set for
+			if _, err = test.get("bob"); err == nil {
+				t.Error("other user got the value")
+			}
+			if err = test.set("nobody"); err == nil {
+				t.Error("set a value of a user that doesn't exist")
+			}
+
+			// the value stays valid after the transaction
+			again, _ := test.get("alice")
+			if !reflect.DeepEqual(value, again) || len(value) == 0 {
+				t.Errorf("got %s, then %s", value, again)
+			}
+		})
+	}
+
+	if db.UserExternal("alice") {
+		t.Error("alice is external before SetUserExternal")
+	}
+	if err := db.SetUserExternal("alice"); err != nil || !db.UserExternal("alice") {
+		t.Errorf("alice isn't external after SetUserExternal: %v", err)
+	}
+
+	if id, err := db.GetUserProjectID("alice"); err != nil || id != 0 {
+		t.Errorf("got project %v, %v, want 0", id, err)
+	}
+	if err := db.SetUserProjectID("alice", 42); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := db.GetUserProjectID("alice"); err != nil || id != 42 {
+		t.Errorf("got project %v, %v, want 42", id, err)
+	}
+
+	if _, err := db.GetUserWalletIndex("alice"); err != ErrNoWalletIndex {
+		t.Errorf("got error %v, want %v", err, ErrNoWalletIndex)
+	}
+	if err := db.SetUserWalletIndex("alice", 7); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := db.GetUserWalletIndex("alice"); err != nil || index != 7 {
+		t.Errorf("got wallet index %v, %v, want 7", index, err)
+	}
+
+	if registration, err := db.GetUserRegistration("alice"); err != nil || registration != nil {
+		t.Errorf("got registration %v, %v, want none", registration, err)
+	}
+	want := &Registration{Status: "registered", TxHash: "0x01", Email: "alice@example.com"}
+	if err := db.SetUserRegistration("alice", want); err != nil {
+		t.Fatal(err)
+	}
+	if registration, err := db.GetUserRegistration("alice"); err != nil || !reflect.DeepEqual(registration, want) {
+		t.Errorf("got registration %v, %v, want %v", registration, err, want)
+	}
+
+	db.RemoveUser("alice")
+	if db.UserExists("alice") || !db.UserExists("bob") {
+		t.Error("RemoveUser didn't remove only alice")
+	}
+	if _, err := db.GetUserAddress("alice"); err == nil {
+		t.Error("got the address of a removed user")
+	}
+	if users, err := db.ListUsers(); err != nil || !reflect.DeepEqual(users, []string{"bob"}) {
+		t.Errorf("got users %v, %v, want bob", users, err)
+	}
+}
+
+func TestBoltCollection(t *testing.T) {
+	db := openBolt(t)
+	if err := db.CreateUser("alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	collection := &Collection{
+		ID:              "c1",
+		ContractAddress: "0xcontract",
+		Name:            "Cats",
+		Description:     "cats",
+		ProjectID:       42,
+		IconURL:         "https://example.com/icon.png",
+		MetadataSchema:  []MetadataField{{Name: "color", Type: "enum", Filterable: true}},
+	}
+	if err := db.CreateCollection("nobody", collection); err == nil {
+		t.Error("created a collection of a user that doesn't exist")
+	}
+	if err := db.CreateCollection("alice", collection); err != nil {
+		t.Fatalf("failed to create collection: %v", err)
+	}
+	if err := db.CreateCollection("alice", collection); err == nil {
+		t.Error("created the same collection twice")
+	}
+	if !db.CollectionExists("alice", "c1") || db.CollectionExists("alice", "c2") {
+		t.Error("CollectionExists doesn't tell c1 from c2")
+	}
+	if address, err := db.GetUserCollectionContractAddress("alice", "c1"); err != nil || string(address) != "0xcontract" {
+		t.Errorf("got contract address %s, %v", address, err)
+	}
+
+	got, err := db.GetUserCollection("alice", "c1")
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+	if got.Created.IsZero() {
+		t.Error("collection has no creation time")
+	}
+	want := *collection
+	want.Version = 1
+	want.Created = got.Created
+	if !reflect.DeepEqual(got, &want) {
+		t.Errorf("got %+v, want %+v", got, &want)
+	}
+	if list, err := db.ListUserCollections("alice"); err != nil || !reflect.DeepEqual(list, []Collection{want}) {
+		t.Errorf("got list %+v, %v", list, err)
+	}
+	if _, err = db.GetUserCollection("alice", "c2"); err == nil {
+		t.Error("got a collection that doesn't exist")
+	}
+
+	name := "Dogs"
+	updated, err := db.UpdateCollection("alice", "c1", 1, CollectionUpdate{Name: &name}, "bob")
+	if err != nil {
+		t.Fatalf("failed to update collection: %v", err)
+	}
+	if updated.Name != "Dogs" || updated.Version != 2 || updated.Description != "cats" {
+		t.Errorf("got %+v after update", updated)
+	}
+	if _, err = db.UpdateCollection("alice", "c1", 1, CollectionUpdate{Name: &name}, "bob"); err != ErrVersionConflict {
+		t.Errorf("got error %v, want %v", err, ErrVersionConflict)
+	}
+	// an update that changes nothing keeps the version
+	if same, err := db.UpdateCollection("alice", "c1", 2, CollectionUpdate{Name: &name}, "bob"); err != nil || same.Version != 2 {
+		t.Errorf("got %+v, %v for an update without changes", same, err)
+	}
+
+	history, err := db.GetCollectionHistory("alice", "c1")
+	if err != nil || len(history) != 1 {
+		t.Fatalf("got history %+v, %v", history, err)
+	}
+	wantFields := []CollectionFieldChange{{Field: "name", Old: "Cats", New: "Dogs"}}
+	if history[0].Version != 2 || history[0].UserID != "bob" || !reflect.DeepEqual(history[0].Fields, wantFields) {
+		t.Errorf("got history %+v", history[0])
+	}
+}
+
+// setupToken creates the users alice and bob with a collection c1 each, and
+// a token of alice's
+func setupToken(t *testing.T) *BoltBackend {
+	t.Helper()
+
+	db := openBolt(t)
+	for _, userid := range []string{"alice", "bob"} {
+		if err := db.CreateUser(userid); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateCollection(userid, &Collection{ID: "c1", ContractAddress: "0x" + userid}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateToken("alice", "c1", "1"); err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	if err := db.SetTokenOwner("1", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetTokenCollection("1", "c1"); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// inCollection tells whether the user's collection holds the token
+func inCollection(t *testing.T, db *BoltBackend, userid string, tokenid string) bool {
+	t.Helper()
+
+	found := false
+	err := db.db.View(func(tx *bolt.Tx) error {
+		if collection := boltCollection(tx, userid, "c1"); collection != nil && collection.Bucket(boltTokensBucket) != nil {
+			found = collection.Bucket(boltTokensBucket).Get([]byte(tokenid)) != nil
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func TestBoltToken(t *testing.T) {
+	db := setupToken(t)
+	if !db.TokenExists("1") || db.TokenExists("2") {
+		t.Error("TokenExists doesn't tell 1 from 2")
+	}
+	if err := db.CreateToken("alice", "c1", "1"); err == nil {
+		t.Error("created the same token twice")
+	}
+	if err := db.CreateToken("alice", "c2", "2"); err == nil || db.TokenExists("2") {
+		t.Errorf("created a token in a collection that doesn't exist: %v", err)
+	}
+	if owner, err := db.GetTokenOwner("1"); err != nil || string(owner) != "alice" {
+		t.Errorf("got owner %s, %v", owner, err)
+	}
+	if collection, err := db.GetTokenCollection("1"); err != nil || string(collection) != "c1" {
+		t.Errorf("got collection %s, %v", collection, err)
+	}
+	if tokens, err := db.ListCollectionTokens("c1"); err != nil || !reflect.DeepEqual(tokens, []string{"1"}) {
+		t.Errorf("got tokens %v, %v", tokens, err)
+	}
+	if collection, err := db.GetUserCollection("alice", "c1"); err != nil || collection.Tokens != 1 {
+		t.Errorf("got collection %+v, %v with one token", collection, err)
+	}
+
+	if err := db.SetTokenMetadata("1", []byte(`{"color":"red"}`)); err != nil {
+		t.Fatal(err)
+	}
+	if metadata, err := db.GetTokenMetadata("1"); err != nil || string(metadata) != `{"color":"red"}` {
+		t.Errorf("got metadata %s, %v", metadata, err)
+	}
+	if err := db.SetTokenMetadata("2", []byte("{}")); err == nil {
+		t.Error("set metadata of a token that doesn't exist")
+	}
+
+	if db.TokenMinted("alice", "1") {
+		t.Error("token minted before SetTokenMintedID")
+	}
+	if err := db.SetTokenMintedID("alice", "1", "imx-1"); err != nil || !db.TokenMinted("alice", "1") {
+		t.Errorf("token not minted after SetTokenMintedID: %v", err)
+	}
+	if id, err := db.GetTokenMintedID("1"); err != nil || string(id) != "imx-1" {
+		t.Errorf("got minted ID %s, %v", id, err)
+	}
+
+	if err := db.RemoveTokenSelling("1"); err == nil {
+		t.Error("removed the sale of a token not on sale")
+	}
+	_ = db.SetTokenSellingID("1", "5")
+	_ = db.SetTokenSellingPrice("1", "100")
+	_ = db.SetTokenSellingCurrency("1", "ETH")
+	if !db.TokenSelling("alice", "1") {
+		t.Error("token isn't selling after SetTokenSellingID")
+	}
+	if list, err := db.GetTokenSellingList("alice"); err != nil || !reflect.DeepEqual(list, []string{"1"}) {
+		t.Errorf("got selling list %v, %v", list, err)
+	}
+	if price, err := db.GetTokenSellingPrice("1"); err != nil || string(price) != "100" {
+		t.Errorf("got price %s, %v", price, err)
+	}
+	if err := db.RemoveTokenSelling("1"); err != nil {
+		t.Fatalf("failed to remove sale: %v", err)
+	}
+	if db.TokenSelling("alice", "1") {
+		t.Error("token is still selling")
+	}
+	for _, get := range []func(string) ([]byte, error){db.GetTokenSellingID, db.GetTokenSellingPrice, db.GetTokenSellingCurrency} {
+		if value, err := get("1"); err == nil {
+			t.Errorf("got %s after the sale has been removed", value)
+		}
+	}
+
+	db.RemoveToken("alice", "c1", "1")
+	if db.TokenExists("1") || inCollection(t, db, "alice", "1") {
+		t.Error("token is left after RemoveToken")
+	}
+}
+
+func TestBoltMoveToken(t *testing.T) {
+	tests := []struct {
+		name string
+		// prepare breaks the database before the move
+		prepare func(t *testing.T, db *BoltBackend)
+		to      string
+		err     string
+		owner   string
+	}{
+		{"to bob", nil, "bob", "", "bob"},
+		{"to owner", nil, "alice", "", "alice"},
+		{"to new collection", func(t *testing.T, db *BoltBackend) {
+			// bob holds no token of c1 and doesn't have the collection
+			err := db.db.Update(func(tx *bolt.Tx) error {
+				return boltUser(tx, "bob").Bucket(boltCollectionsBucket).DeleteBucket([]byte("c1"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, "bob", "", "bob"},
+		{"unknown user", nil, "carol", "user carol doesn't exist", "alice"},
+		{"not in collection", func(t *testing.T, db *BoltBackend) {
+			err := db.db.Update(func(tx *bolt.Tx) error {
+				return boltCollection(tx, "alice", "c1").Bucket(boltTokensBucket).Delete([]byte("1"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, "bob", "token 1 is not in owner's collection", "alice"},
+		{"failure partway", func(t *testing.T, db *BoltBackend) {
+			// the token is removed from alice's collection before bob's
+			// turns out to be unusable
+			err := db.db.Update(func(tx *bolt.Tx) error {
+				collection := boltCollection(tx, "bob", "c1")
+				if err := collection.DeleteBucket(boltTokensBucket); err != nil {
+					return err
+				}
+				return collection.Put(boltTokensBucket, []byte("not a bucket"))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}, "bob", bolt.ErrIncompatibleValue.Error(), "alice"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := setupToken(t)
+			if test.prepare != nil {
+				test.prepare(t, db)
+			}
+			wasInCollection := inCollection(t, db, "alice", "1")
+
+			err := db.MoveToken("1", test.to)
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+			} else if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+
+			if owner, err := db.GetTokenOwner("1"); err != nil || string(owner) != test.owner {
+				t.Errorf("got owner %s, %v, want %v", owner, err, test.owner)
+			}
+			if test.err != "" {
+				// nothing of the move is left behind
+				if inCollection(t, db, "alice", "1") != wasInCollection {
+					t.Error("alice's collection changed")
+				}
+				if inCollection(t, db, "bob", "1") {
+					t.Error("token is in bob's collection")
+				}
+				return
+			}
+			if !inCollection(t, db, test.owner, "1") {
+				t.Errorf("token isn't in %v's collection", test.owner)
+			}
+			if test.owner != "alice" && inCollection(t, db, "alice", "1") {
+				t.Error("token is left in alice's collection")
+			}
+			// the collection itself stays with its creator
+			if collection, err := db.GetUserCollection("alice", "c1"); err != nil || collection.Tokens != 1 {
+				t.Errorf("got collection %+v, %v with one token", collection, err)
+			}
+		})
+	}
+}
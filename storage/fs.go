@@ -0,0 +1,938 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/holiman/uint256"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FSBackend keeps every field in its own file under root, using the
+// users/<userid>/... and tokens/<tokenid>/... directory layout.
+type FSBackend struct {
+	root            string
+	indexMutex      sync.Mutex
+	collectionMutex sync.Mutex
+}
+
+func NewFSBackend(root string) *FSBackend {
+	return &FSBackend{root: root}
+}
+
+func (b *FSBackend) userPath(userid string) string {
+	return b.root + UserDir + userid
+}
+
+func (b *FSBackend) collectionPath(userid string, collectionid string) string {
+	return b.userPath(userid) + "/collections/" + collectionid
+}
+
+func (b *FSBackend) tokenPath(tokenid string) string {
+	return b.root + TokenDir + tokenid
+}
+
+func (b *FSBackend) Exists() bool {
+	if _, err := os.Stat(b.root + UserDir); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) Create() error {
+	err := os.MkdirAll(b.root+UserDir, os.ModePerm)
+	if err != nil {
+		return errors.New("failed to create user storage")
+	}
+	return nil
+}
+
+func (b *FSBackend) ListUsers() ([]string, error) {
+	entries, err := os.ReadDir(b.root + UserDir)
+	if err != nil {
+		return nil, errors.New("failed to read user storage")
+	}
+
+	var users []string
+	for _, user := range entries {
+		if !user.IsDir() {
+			continue
+		}
+		users = append(users, user.Name())
+	}
+
+	return users, nil
+}
+
+func (b *FSBackend) UserExists(userid string) bool {
+	if _, err := os.Stat(b.userPath(userid)); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) CreateUser(userid string) error {
+	err := os.MkdirAll(b.userPath(userid)+"/collections", os.ModePerm)
+	if err != nil {
+		return errors.New("failed to create user infrastructure")
+	}
+	return nil
+}
+
+func (b *FSBackend) RemoveUser(userid string) {
+	_ = os.RemoveAll(b.userPath(userid))
+}
+
+func (b *FSBackend) GetUserSealedPrivateKey(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/private_key")
+}
+
+func (b *FSBackend) SetUserSealedPrivateKey(userid string, key []byte) error {
+	return writeFileSync(b.userPath(userid)+"/private_key", key)
+}
+
+func (b *FSBackend) GetUserPublicKey(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/public_key")
+}
+
+func (b *FSBackend) SetUserPublicKey(userid string, key []byte) error {
+	return os.WriteFile(b.userPath(userid)+"/public_key", key, 0644)
+}
+
+func (b *FSBackend) GetUserAddress(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/address")
+}
+
+func (b *FSBackend) SetUserAddress(userid string, address []byte) error {
+	return os.WriteFile(b.userPath(userid)+"/address", address, 0644)
+}
+
+func (b *FSBackend) GetUserLinkedAddress(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/linked_address")
+}
+
+func (b *FSBackend) SetUserLinkedAddress(userid string, address []byte) error {
+	return os.WriteFile(b.userPath(userid)+"/linked_address", address, 0644)
+}
+
+func (b *FSBackend) UserExternal(userid string) bool {
+	if _, err := os.Stat(b.userPath(userid) + "/external"); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) SetUserExternal(userid string) error {
+	return os.WriteFile(b.userPath(userid)+"/external", nil, 0644)
+}
+
+func (b *FSBackend) GetUserSealedStarkPrivateKey(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/stark_private_key")
+}
+
+func (b *FSBackend) SetUserSealedStarkPrivateKey(userid string, key []byte) error {
+	return writeFileSync(b.userPath(userid)+"/stark_private_key", key)
+}
+
+func (b *FSBackend) GetUserStarkAddress(userid string) ([]byte, error) {
+	return os.ReadFile(b.userPath(userid) + "/stark_address")
+}
+
+func (b *FSBackend) SetUserStarkAddress(userid string, address []byte) error {
+	return os.WriteFile(b.userPath(userid)+"/stark_address", address, 0644)
+}
+
+func (b *FSBackend) GetUserProjectID(userid string) (int32, error) {
+	data, err := os.ReadFile(b.userPath(userid) + "/project_id")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	projectID, err := strconv.ParseInt(string(data), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid project ID of user " + userid)
+	}
+	return int32(projectID), nil
+}
+
+func (b *FSBackend) SetUserProjectID(userid string, projectID int32) error {
+	return os.WriteFile(b.userPath(userid)+"/project_id", []byte(strconv.FormatInt(int64(projectID), 10)), 0644)
+}
+
+func (b *FSBackend) GetUserWalletIndex(userid string) (uint32, error) {
+	data, err := os.ReadFile(b.userPath(userid) + "/wallet_index")
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNoWalletIndex
+	}
+	if err != nil {
+		return 0, err
+	}
+	index, err := strconv.ParseUint(string(data), 10, 32)
+	if err != nil {
+		return 0, errors.New("invalid wallet index of user " + userid)
+	}
+	return uint32(index), nil
+}
+
+func (b *FSBackend) SetUserWalletIndex(userid string, index uint32) error {
+	return os.WriteFile(b.userPath(userid)+"/wallet_index", []byte(strconv.FormatUint(uint64(index), 10)), 0644)
+}
+
+func (b *FSBackend) GetUserRegistration(userid string) (*Registration, error) {
+	data, err := os.ReadFile(b.userPath(userid) + "/imx_registration")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	registration := new(Registration)
+	if err = json.Unmarshal(data, registration); err != nil {
+		return nil, err
+	}
+	return registration, nil
+}
+
+func (b *FSBackend) SetUserRegistration(userid string, registration *Registration) error {
+	data, err := json.Marshal(registration)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(b.userPath(userid)+"/imx_registration", data)
+}
+
+func (b *FSBackend) CollectionExists(userid string, collectionid string) bool {
+	if _, err := os.Stat(b.collectionPath(userid, collectionid)); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) CreateCollection(userid string, collection *Collection) error {
+	collectionPath := b.collectionPath(userid, collection.ID)
+
+	err := os.MkdirAll(collectionPath, os.ModePerm)
+	if err != nil {
+		return errors.New("failed to create collection")
+	}
+
+	err = os.WriteFile(collectionPath+"/contract_address", []byte(collection.ContractAddress), 0644)
+	if err != nil {
+		_ = os.RemoveAll(collectionPath)
+		return errors.New("failed to create collection contract")
+	}
+
+	err = b.writeCollection(collectionPath, collection)
+	if err != nil {
+		_ = os.RemoveAll(collectionPath)
+		return err
+	}
+
+	if collection.ProjectID != 0 {
+		err = os.WriteFile(collectionPath+"/project_id", []byte(strconv.FormatInt(int64(collection.ProjectID), 10)), 0644)
+		if err != nil {
+			_ = os.RemoveAll(collectionPath)
+			return errors.New("failed to create collection project")
+		}
+	}
+
+	err = os.WriteFile(collectionPath+"/created", []byte(time.Now().UTC().Format(time.RFC3339Nano)), 0644)
+	if err != nil {
+		_ = os.RemoveAll(collectionPath)
+		return errors.New("failed to create collection")
+	}
+
+	err = os.WriteFile(collectionPath+"/version", []byte("1"), 0644)
+	if err != nil {
+		_ = os.RemoveAll(collectionPath)
+		return errors.New("failed to create collection")
+	}
+
+	return nil
+}
+
+// writeCollection writes the editable fields of the collection
+func (b *FSBackend) writeCollection(collectionPath string, collection *Collection) error {
+	if err := writeFileSync(collectionPath+"/name", []byte(collection.Name)); err != nil {
+		return errors.New("failed to write collection name")
+	}
+	if err := writeFileSync(collectionPath+"/description", []byte(collection.Description)); err != nil {
+		return errors.New("failed to write collection description")
+	}
+	for name, value := range collection.urls() {
+		if err := writeFileSync(collectionPath+"/"+name, []byte(*value)); err != nil {
+			return errors.New("failed to write collection " + name)
+		}
+	}
+	schema, err := json.Marshal(collection.MetadataSchema)
+	if err != nil {
+		return err
+	}
+	if err = writeFileSync(collectionPath+"/metadata_schema", schema); err != nil {
+		return errors.New("failed to write collection metadata schema")
+	}
+	return nil
+}
+
+func (b *FSBackend) GetUserCollectionContractAddress(userid string, collectionid string) ([]byte, error) {
+	return os.ReadFile(b.collectionPath(userid, collectionid) + "/contract_address")
+}
+
+// collectionTokens maps collection IDs to the tokens in them.
+func (b *FSBackend) collectionTokens() (map[string][]string, error) {
+	entries, err := os.ReadDir(b.root + TokenDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read token storage")
+	}
+
+	tokens := make(map[string][]string)
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		collection, err := b.GetTokenCollection(entry.Name())
+		if err != nil {
+			continue
+		}
+		tokens[string(collection)] = append(tokens[string(collection)], entry.Name())
+	}
+	return tokens, nil
+}
+
+func (b *FSBackend) readCollection(userid string, collectionid string) (*Collection, error) {
+	collectionPath := b.collectionPath(userid, collectionid)
+	contractAddress, err := os.ReadFile(collectionPath + "/contract_address")
+	if err != nil {
+		return nil, err
+	}
+	name, err := os.ReadFile(collectionPath + "/name")
+	if err != nil {
+		return nil, err
+	}
+	description, err := os.ReadFile(collectionPath + "/description")
+	if err != nil {
+		return nil, err
+	}
+
+	collection := &Collection{
+		ID:              collectionid,
+		ContractAddress: string(contractAddress),
+		Name:            string(name),
+		Description:     string(description),
+		Version:         1,
+	}
+	if version, err := os.ReadFile(collectionPath + "/version"); err == nil {
+		if v, err := strconv.Atoi(string(version)); err == nil {
+			collection.Version = v
+		}
+	}
+	if projectID, err := os.ReadFile(collectionPath + "/project_id"); err == nil {
+		if id, err := strconv.ParseInt(string(projectID), 10, 32); err == nil {
+			collection.ProjectID = int32(id)
+		}
+	}
+	for name, value := range collection.urls() {
+		if data, err := os.ReadFile(collectionPath + "/" + name); err == nil {
+			*value = string(data)
+		}
+	}
+	if schema, err := os.ReadFile(collectionPath + "/metadata_schema"); err == nil {
+		_ = json.Unmarshal(schema, &collection.MetadataSchema)
+	}
+	if created, err := os.ReadFile(collectionPath + "/created"); err == nil {
+		collection.Created, _ = time.Parse(time.RFC3339Nano, string(created))
+	} else if info, err := os.Stat(collectionPath + "/contract_address"); err == nil {
+		collection.Created = info.ModTime()
+	}
+	return collection, nil
+}
+
+func (b *FSBackend) ListUserCollections(userid string) ([]Collection, error) {
+	entries, err := os.ReadDir(b.userPath(userid) + "/collections")
+	if err != nil {
+		return nil, errors.New("failed to read collection storage")
+	}
+	tokens, err := b.collectionTokens()
+	if err != nil {
+		return nil, err
+	}
+
+	var collections []Collection
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// directories without a contract hold tokens bought from other users
+		collection, err := b.readCollection(userid, entry.Name())
+		if err != nil {
+			continue
+		}
+		collection.Tokens = len(tokens[collection.ID])
+		collections = append(collections, *collection)
+	}
+	return collections, nil
+}
+
+func (b *FSBackend) GetUserCollection(userid string, collectionid string) (*Collection, error) {
+	collection, err := b.readCollection(userid, collectionid)
+	if err != nil {
+		return nil, errors.New("collection " + collectionid + " doesn't exist")
+	}
+	tokens, err := b.ListCollectionTokens(collectionid)
+	if err != nil {
+		return nil, err
+	}
+	collection.Tokens = len(tokens)
+	return collection, nil
+}
+
+// UpdateCollection serializes updates with a mutex within the process and
+// an exclusive lock on the collection's lock file across processes. The
+// fields and version are written before the change is appended to the
+// history file, and rolled back if any of it fails.
+func (b *FSBackend) UpdateCollection(userid string, collectionid string, version int, update CollectionUpdate, editor string) (*Collection, error) {
+	b.collectionMutex.Lock()
+	defer b.collectionMutex.Unlock()
+
+	collectionPath := b.collectionPath(userid, collectionid)
+	if _, err := os.Stat(collectionPath + "/contract_address"); err != nil {
+		return nil, errors.New("collection " + collectionid + " doesn't exist")
+	}
+
+	lock, err := os.OpenFile(collectionPath+"/lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.New("failed to open collection lock")
+	}
+	defer lock.Close()
+	if err = lockFile(lock); err != nil {
+		return nil, errors.New("failed to lock collection")
+	}
+	defer unlockFile(lock)
+
+	collection, err := b.readCollection(userid, collectionid)
+	if err != nil {
+		return nil, err
+	}
+	if collection.Version != version {
+		return nil, ErrVersionConflict
+	}
+	previous := *collection
+	changes := update.apply(collection)
+	if len(changes) == 0 {
+		return b.GetUserCollection(userid, collectionid)
+	}
+	collection.Version++
+
+	change, err := json.Marshal(CollectionChange{
+		Version: collection.Version,
+		UserID:  editor,
+		Time:    time.Now().UTC(),
+		Fields:  changes,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the fields and the version are written before the history, which
+	// only ever records versions that have been written. The fields are
+	// restored if anything fails.
+	if err = b.writeCollection(collectionPath, collection); err != nil {
+		_ = b.writeCollection(collectionPath, &previous)
+		return nil, err
+	}
+	if err = writeFileSync(collectionPath+"/version", []byte(strconv.Itoa(collection.Version))); err != nil {
+		_ = b.writeCollection(collectionPath, &previous)
+		return nil, errors.New("failed to update collection version")
+	}
+	if err = appendHistory(collectionPath+"/history", change); err != nil {
+		_ = writeFileSync(collectionPath+"/version", []byte(strconv.Itoa(previous.Version)))
+		_ = b.writeCollection(collectionPath, &previous)
+		return nil, err
+	}
+
+	return b.GetUserCollection(userid, collectionid)
+}
+
+// appendHistory appends the change to the history file as one line, cutting
+// it back to its previous size if the line can't be written completely.
+func appendHistory(path string, change []byte) error {
+	history, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.New("failed to open collection history")
+	}
+	defer history.Close()
+	info, err := history.Stat()
+	if err != nil {
+		return errors.New("failed to open collection history")
+	}
+	if _, err = history.Write(append(change, '\n')); err == nil {
+		err = history.Sync()
+	}
+	if err != nil {
+		_ = history.Truncate(info.Size())
+		return errors.New("failed to write collection history")
+	}
+	return nil
+}
+
+func (b *FSBackend) GetCollectionHistory(userid string, collectionid string) ([]CollectionChange, error) {
+	collectionPath := b.collectionPath(userid, collectionid)
+	if _, err := os.Stat(collectionPath + "/contract_address"); err != nil {
+		return nil, errors.New("collection " + collectionid + " doesn't exist")
+	}
+
+	data, err := os.ReadFile(collectionPath + "/history")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read collection history")
+	}
+
+	var history []CollectionChange
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var change CollectionChange
+		if err = json.Unmarshal([]byte(line), &change); err != nil {
+			return nil, errors.New("failed to read collection history")
+		}
+		history = append(history, change)
+	}
+	return history, nil
+}
+
+func (b *FSBackend) ListCollectionTokens(collectionid string) ([]string, error) {
+	tokens, err := b.collectionTokens()
+	if err != nil {
+		return nil, err
+	}
+	return tokens[collectionid], nil
+}
+
+func (b *FSBackend) withdrawalPath(userid string, withdrawID int32) string {
+	return b.userPath(userid) + "/withdrawals/" + strconv.FormatInt(int64(withdrawID), 10)
+}
+
+func (b *FSBackend) ListUserWithdrawals(userid string) ([]Withdrawal, error) {
+	entries, err := os.ReadDir(b.userPath(userid) + "/withdrawals")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read withdrawal storage")
+	}
+
+	var withdrawals []Withdrawal
+	for _, entry := range entries {
+		id, err := strconv.ParseInt(entry.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		withdrawal, err := b.GetUserWithdrawal(userid, int32(id))
+		if err != nil {
+			return nil, err
+		}
+		withdrawals = append(withdrawals, *withdrawal)
+	}
+
+	return withdrawals, nil
+}
+
+func (b *FSBackend) GetUserWithdrawal(userid string, withdrawID int32) (*Withdrawal, error) {
+	data, err := os.ReadFile(b.withdrawalPath(userid, withdrawID))
+	if err != nil {
+		return nil, err
+	}
+	withdrawal := new(Withdrawal)
+	if err = json.Unmarshal(data, withdrawal); err != nil {
+		return nil, err
+	}
+	return withdrawal, nil
+}
+
+func (b *FSBackend) SetUserWithdrawal(userid string, withdrawal *Withdrawal) error {
+	if err := os.MkdirAll(b.userPath(userid)+"/withdrawals", os.ModePerm); err != nil {
+		return errors.New("failed to create withdrawal storage")
+	}
+	data, err := json.Marshal(withdrawal)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(b.withdrawalPath(userid, withdrawal.ID), data)
+}
+
+func (b *FSBackend) MigrateUserWithdraw(userid string) (*Withdrawal, error) {
+	data, err := os.ReadFile(b.userPath(userid) + "/withdraw")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	withdrawal, err := legacyWithdrawal(data)
+	if err != nil {
+		return nil, err
+	}
+	if err = b.SetUserWithdrawal(userid, withdrawal); err != nil {
+		return nil, err
+	}
+	return withdrawal, os.Remove(b.userPath(userid) + "/withdraw")
+}
+
+// NextTokenIndex serializes allocations within the process with a mutex and
+// across processes with an exclusive lock on tokens/index.lock. The new index
+// is written to a temporary file, synced and renamed over tokens/index, so a
+// crash leaves either the old or the new value on disk, never a torn one.
+func (b *FSBackend) NextTokenIndex() (*uint256.Int, error) {
+	b.indexMutex.Lock()
+	defer b.indexMutex.Unlock()
+
+	if err := os.MkdirAll(b.root+TokenDir, os.ModePerm); err != nil {
+		return nil, errors.New("failed to create token storage")
+	}
+
+	lock, err := os.OpenFile(b.root+TokenDir+"index.lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, errors.New("failed to open token index lock")
+	}
+	defer lock.Close()
+	if err = lockFile(lock); err != nil {
+		return nil, errors.New("failed to lock token index")
+	}
+	defer unlockFile(lock)
+
+	tokenID := new(uint256.Int)
+	bytes, err := os.ReadFile(b.root + TokenDir + "index")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.New("failed to read token index")
+	}
+	tokenID.SetBytes(bytes)
+	tokenID.AddUint64(tokenID, 1)
+
+	if err = writeFileSync(b.root+TokenDir+"index", tokenID.Bytes()); err != nil {
+		return nil, errors.New("failed to write token index")
+	}
+
+	return tokenID, nil
+}
+
+// NextWalletIndex keeps the next index in users/wallet_index, locked and
+// written the same way as the token index.
+func (b *FSBackend) NextWalletIndex() (uint32, error) {
+	b.indexMutex.Lock()
+	defer b.indexMutex.Unlock()
+
+	if err := os.MkdirAll(b.root+UserDir, os.ModePerm); err != nil {
+		return 0, errors.New("failed to create user storage")
+	}
+
+	lock, err := os.OpenFile(b.root+UserDir+"wallet_index.lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return 0, errors.New("failed to open wallet index lock")
+	}
+	defer lock.Close()
+	if err = lockFile(lock); err != nil {
+		return 0, errors.New("failed to lock wallet index")
+	}
+	defer unlockFile(lock)
+
+	var index uint64
+	data, err := os.ReadFile(b.root + UserDir + "wallet_index")
+	if err != nil && !os.IsNotExist(err) {
+		return 0, errors.New("failed to read wallet index")
+	}
+	if err == nil {
+		if index, err = strconv.ParseUint(string(data), 10, 32); err != nil {
+			return 0, errors.New("invalid wallet index")
+		}
+	}
+	if index >= 1<<31 {
+		return 0, errors.New("wallet indexes are exhausted")
+	}
+
+	if err = writeFileSync(b.root+UserDir+"wallet_index", []byte(strconv.FormatUint(index+1, 10))); err != nil {
+		return 0, errors.New("failed to write wallet index")
+	}
+
+	return uint32(index), nil
+}
+
+// Migrated looks for migrations/<name>, which holds when it has been
+// completed.
+func (b *FSBackend) Migrated(name string) bool {
+	if _, err := os.Stat(b.root + MigrationDir + name); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) SetMigrated(name string) error {
+	if err := os.MkdirAll(b.root+MigrationDir, os.ModePerm); err != nil {
+		return errors.New("failed to create migration storage")
+	}
+	return writeFileSync(b.root+MigrationDir+name, []byte(time.Now().UTC().Format(time.RFC3339)))
+}
+
+func (b *FSBackend) TokenExists(tokenid string) bool {
+	if _, err := os.Stat(b.tokenPath(tokenid)); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) CreateToken(userid string, collectionid string, tokenid string) error {
+	err := os.MkdirAll(b.tokenPath(tokenid), os.ModePerm)
+	if err != nil {
+		return errors.New("failed to reserve token")
+	}
+
+	// TODO: convert this to symlink
+	err = os.MkdirAll(b.collectionPath(userid, collectionid)+"/"+tokenid, os.ModePerm)
+	if err != nil {
+		b.RemoveToken(userid, collectionid, tokenid)
+		return errors.New("failed to reserve token in collection")
+	}
+
+	return nil
+}
+
+func (b *FSBackend) RemoveToken(userid string, collectionid string, tokenid string) {
+	_ = os.RemoveAll(b.tokenPath(tokenid))
+	_ = os.RemoveAll(b.collectionPath(userid, collectionid) + "/" + tokenid)
+}
+
+func (b *FSBackend) MoveToken(tokenid string, to string) error {
+	from, err := b.GetTokenOwner(tokenid)
+	if err != nil {
+		return err
+	}
+	collection, err := b.GetTokenCollection(tokenid)
+	if err != nil {
+		return err
+	}
+
+	err = b.SetTokenOwner(tokenid, to)
+	if err != nil {
+		return err
+	}
+
+	tokenPath := "/collections/" + string(collection) + "/"
+	_, err = os.Stat(b.userPath(string(from)) + tokenPath + tokenid)
+	if err != nil {
+		_ = b.SetTokenOwner(tokenid, string(from))
+		return err
+	}
+	err = os.MkdirAll(b.userPath(to)+tokenPath, os.ModePerm)
+	if err != nil {
+		_ = b.SetTokenOwner(tokenid, string(from))
+		return err
+	}
+
+	err = os.Rename(b.userPath(string(from))+tokenPath+tokenid, b.userPath(to)+tokenPath+tokenid)
+	if err != nil {
+		_ = b.SetTokenOwner(tokenid, string(from))
+		return err
+	}
+
+	return nil
+}
+
+func (b *FSBackend) GetTokenOwner(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/user_id")
+}
+
+func (b *FSBackend) SetTokenOwner(tokenid string, userid string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/user_id", []byte(userid), 0644)
+}
+
+func (b *FSBackend) GetTokenCollection(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/collection_id")
+}
+
+func (b *FSBackend) SetTokenCollection(tokenid string, collectionid string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/collection_id", []byte(collectionid), 0644)
+}
+
+func (b *FSBackend) GetTokenMetadata(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/metadata")
+}
+
+func (b *FSBackend) SetTokenMetadata(tokenid string, metadata []byte) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/metadata", metadata, 0644)
+}
+
+func (b *FSBackend) TokenMinted(userid string, tokenid string) bool {
+	if _, err := os.Stat(b.tokenPath(tokenid) + "/minted"); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) GetTokenMintedID(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/minted")
+}
+
+func (b *FSBackend) SetTokenMintedID(userid string, tokenid string, imxtokenid string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/minted", []byte(imxtokenid), 0644)
+}
+
+func (b *FSBackend) TokenSelling(userid string, tokenid string) bool {
+	if _, err := os.Stat(b.tokenPath(tokenid) + "/selling"); err != nil {
+		return false
+	}
+	return true
+}
+
+func (b *FSBackend) GetTokenSellingID(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/selling")
+}
+
+func (b *FSBackend) SetTokenSellingID(tokenid string, sellingID string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/selling", []byte(sellingID), 0644)
+}
+
+func (b *FSBackend) GetTokenSellingPrice(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/selling_price")
+}
+
+func (b *FSBackend) SetTokenSellingPrice(tokenid string, price string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/selling_price", []byte(price), 0644)
+}
+
+func (b *FSBackend) GetTokenSellingCurrency(tokenid string) ([]byte, error) {
+	return os.ReadFile(b.tokenPath(tokenid) + "/selling_currency")
+}
+
+func (b *FSBackend) SetTokenSellingCurrency(tokenid string, currency string) error {
+	return os.WriteFile(b.tokenPath(tokenid)+"/selling_currency", []byte(currency), 0644)
+}
+
+func (b *FSBackend) RemoveTokenSelling(tokenid string) error {
+	_ = os.Remove(b.tokenPath(tokenid) + "/selling_price")
+	_ = os.Remove(b.tokenPath(tokenid) + "/selling_currency")
+	return os.Remove(b.tokenPath(tokenid) + "/selling")
+}
+
+func (b *FSBackend) GetTokenSellingList(userid string) ([]string, error) {
+	entries, err := os.ReadDir(b.root + TokenDir)
+	if err != nil {
+		return nil, errors.New("failed to read token storage")
+	}
+
+	var list []string
+	for _, token := range entries {
+		tokenid := token.Name()
+		if !token.IsDir() {
+			continue
+		}
+		if b.TokenSelling(userid, tokenid) {
+			list = append(list, tokenid)
+		}
+	}
+
+	return list, nil
+}
+
+func (b *FSBackend) ListJobs() ([]string, error) {
+	entries, err := os.ReadDir(b.root + JobDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read job storage")
+	}
+
+	var jobs []string
+	for _, job := range entries {
+		if job.IsDir() || strings.Contains(job.Name(), ".tmp") {
+			continue
+		}
+		jobs = append(jobs, job.Name())
+	}
+
+	return jobs, nil
+}
+
+func (b *FSBackend) GetJob(jobid string) ([]byte, error) {
+	return os.ReadFile(b.root + JobDir + jobid)
+}
+
+func (b *FSBackend) SetJob(jobid string, job []byte) error {
+	if err := os.MkdirAll(b.root+JobDir, os.ModePerm); err != nil {
+		return errors.New("failed to create job storage")
+	}
+	return writeFileSync(b.root+JobDir+jobid, job)
+}
+
+func (b *FSBackend) ListAPIKeys() ([]string, error) {
+	entries, err := os.ReadDir(b.root + KeyDir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("failed to read API key storage")
+	}
+
+	var keys []string
+	for _, key := range entries {
+		if key.IsDir() || strings.Contains(key.Name(), ".tmp") {
+			continue
+		}
+		keys = append(keys, key.Name())
+	}
+
+	return keys, nil
+}
+
+func (b *FSBackend) GetAPIKey(keyid string) ([]byte, error) {
+	return os.ReadFile(b.root + KeyDir + keyid)
+}
+
+func (b *FSBackend) SetAPIKey(keyid string, key []byte) error {
+	if err := os.MkdirAll(b.root+KeyDir, os.ModePerm); err != nil {
+		return errors.New("failed to create API key storage")
+	}
+	return writeFileSync(b.root+KeyDir+keyid, key)
+}
+
+// writeFileSync replaces the file at path with data, making sure both the
+// contents and the rename are on disk before returning. The file is only
+// readable by the owner.
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
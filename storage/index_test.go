@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"github.com/holiman/uint256"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+const (
+	indexWorkers     = 16
+	indexAllocations = 25
+)
+
+// allocateIndexes calls NextTokenIndex from indexWorkers goroutines at once,
+// spread over the given backends, and checks that every goroutine sees its
+// own IDs strictly increasing. It returns all allocated IDs.
+func allocateIndexes(t *testing.T, backends ...Backend) []*uint256.Int {
+	t.Helper()
+
+	var wg sync.WaitGroup
+	results := make([][]*uint256.Int, indexWorkers)
+	errs := make(chan error, indexWorkers*indexAllocations)
+	for worker := 0; worker < indexWorkers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			b := backends[worker%len(backends)]
+			for i := 0; i < indexAllocations; i++ {
+				id, err := b.NextTokenIndex()
+				if err != nil {
+					errs <- err
+					return
+				}
+				results[worker] = append(results[worker], id)
+			}
+		}(worker)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("NextTokenIndex failed: %v", err)
+	}
+
+	var ids []*uint256.Int
+	for worker, result := range results {
+		for i := 1; i < len(result); i++ {
+			if !result[i-1].Lt(result[i]) {
+				t.Errorf("worker %v got %v after %v", worker, result[i], result[i-1])
+			}
+		}
+		ids = append(ids, result...)
+	}
+	return ids
+}
+
+// checkIndexes checks that ids are unique and exactly from+1 up to
+// from+len(ids), so no ID has been skipped or handed out twice.
+func checkIndexes(t *testing.T, ids []*uint256.Int, from uint64) {
+	t.Helper()
+
+	seen := make(map[uint64]bool)
+	for _, id := range ids {
+		if !id.IsUint64() {
+			t.Fatalf("token ID %v out of range", id)
+		}
+		if seen[id.Uint64()] {
+			t.Errorf("token ID %v allocated twice", id)
+		}
+		seen[id.Uint64()] = true
+	}
+	for id := from + 1; id <= from+uint64(len(ids)); id++ {
+		if !seen[id] {
+			t.Errorf("token ID %v never allocated", id)
+		}
+	}
+}
+
+func nextIndex(t *testing.T, b Backend) uint64 {
+	t.Helper()
+
+	id, err := b.NextTokenIndex()
+	if err != nil {
+		t.Fatalf("NextTokenIndex failed: %v", err)
+	}
+	return id.Uint64()
+}
+
+func TestFSNextTokenIndex(t *testing.T) {
+	root := t.TempDir() + "/"
+	total := uint64(indexWorkers * indexAllocations)
+
+	checkIndexes(t, allocateIndexes(t, NewFSBackend(root)), 0)
+
+	// two backends on the same root stand in for two processes, they only
+	// share the lock file
+	checkIndexes(t, allocateIndexes(t, NewFSBackend(root), NewFSBackend(root)), total)
+
+	if id := nextIndex(t, NewFSBackend(root)); id != 2*total+1 {
+		t.Errorf("reopened store allocated %v, want %v", id, 2*total+1)
+	}
+}
+
+func TestBoltNextTokenIndex(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "market.db")
+	total := uint64(indexWorkers * indexAllocations)
+
+	db, err := NewBoltBackend(path)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	checkIndexes(t, allocateIndexes(t, db), 0)
+	if err = db.Close(); err != nil {
+		t.Fatalf("failed to close database: %v", err)
+	}
+
+	db, err = NewBoltBackend(path)
+	if err != nil {
+		t.Fatalf("failed to reopen database: %v", err)
+	}
+	defer db.Close()
+	checkIndexes(t, allocateIndexes(t, db), total)
+
+	if id := nextIndex(t, db); id != 2*total+1 {
+		t.Errorf("reopened store allocated %v, want %v", id, 2*total+1)
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package storage
+
+import (
+	"os"
+)
+
+// without flock only allocations within a single process are serialized
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -1,233 +1,689 @@
 package storage
 
 import (
+	"encoding/json"
 	"errors"
+	"github.com/google/uuid"
 	"github.com/holiman/uint256"
+	"nft-market/config"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
-const Prefix = "data/"
 const UserDir = "users/"
 const TokenDir = "tokens/"
+const JobDir = "jobs/"
+const KeyDir = "keys/"
+const MigrationDir = "migrations/"
+
+// Withdrawal is a user's withdrawal from ImmutableX to L1, from the moment it
+// has been prepared until it has been completed on L1.
+type Withdrawal struct {
+	ID int32 `json:"id"`
+	// Amount is in base units of the token, wei for ETH
+	Amount string `json:"amount"`
+	// Token is the currency symbol, TokenAddress its ERC-20 contract
+	Token        string `json:"token"`
+	TokenAddress string `json:"token_address,omitempty"`
+	// RollupStatus is the last status reported by ImmutableX
+	RollupStatus string     `json:"rollup_status"`
+	TxHash       string     `json:"tx_hash,omitempty"`
+	Created      time.Time  `json:"created"`
+	Updated      time.Time  `json:"updated"`
+	Finalized    *time.Time `json:"finalized,omitempty"`
+}
 
-func StorageExists() bool {
-	if _, err := os.Stat(Prefix + UserDir); err != nil {
-		return false
+// Registration is the state of a user's off-chain registration on
+// ImmutableX, which users need before they can trade.
+type Registration struct {
+	Status string `json:"status"`
+	TxHash string `json:"tx_hash,omitempty"`
+	// Email is registered with the user, kept to retry failed registrations
+	Email string `json:"email,omitempty"`
+	// Error is why the last attempt failed
+	Error   string    `json:"error,omitempty"`
+	Updated time.Time `json:"updated"`
+}
+
+// Collection is a collection created by a user.
+type Collection struct {
+	ID              string
+	ContractAddress string
+	Name            string
+	Description     string
+	// Created is zero for collections created by older versions of the bolt
+	// backend, the fs backend falls back to the file modification time
+	Created time.Time
+	// Tokens is the number of tokens in the collection, whoever holds them
+	Tokens int
+	// Version starts at 1 and is incremented by every update
+	Version int
+
+	// ProjectID is the ImmutableX project the collection has been created in
+	ProjectID      int32
+	IconURL        string
+	ImageURL       string
+	MetadataAPIURL string
+	MetadataSchema []MetadataField
+}
+
+// MetadataField is a property of the token metadata of a collection, Type is
+// one of the ImmutableX property types.
+type MetadataField struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Filterable bool   `json:"filterable"`
+}
+
+// urls maps the file (fs) or key (bolt) names of the collection URLs to the
+// fields holding them.
+func (c *Collection) urls() map[string]*string {
+	return map[string]*string{
+		"icon_url":         &c.IconURL,
+		"image_url":        &c.ImageURL,
+		"metadata_api_url": &c.MetadataAPIURL,
 	}
-	return true
 }
 
-func StorageCreate() error {
-	err := os.MkdirAll(Prefix+UserDir, os.ModePerm)
-	if err != nil {
-		return errors.New("failed to create user storage")
+var ErrNoWalletIndex = errors.New("user keys aren't derived from the wallet seed")
+
+var ErrVersionConflict = errors.New("collection has been changed in the meantime")
+
+// CollectionUpdate holds the editable fields of a collection, nil fields are
+// left unchanged. MetadataSchema replaces the whole schema.
+type CollectionUpdate struct {
+	Name           *string
+	Description    *string
+	IconURL        *string
+	ImageURL       *string
+	MetadataAPIURL *string
+	MetadataSchema []MetadataField
+}
+
+type CollectionFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// CollectionChange is an entry of a collection's history, Version is the
+// version the change created.
+type CollectionChange struct {
+	Version int                     `json:"version"`
+	UserID  string                  `json:"userid"`
+	Time    time.Time               `json:"time"`
+	Fields  []CollectionFieldChange `json:"fields"`
+}
+
+// apply updates the collection and returns what changed, the version is
+// left to the backend.
+func (u *CollectionUpdate) apply(collection *Collection) []CollectionFieldChange {
+	var changes []CollectionFieldChange
+	if u.Name != nil && *u.Name != collection.Name {
+		changes = append(changes, CollectionFieldChange{Field: "name", Old: collection.Name, New: *u.Name})
+		collection.Name = *u.Name
+	}
+	if u.Description != nil && *u.Description != collection.Description {
+		changes = append(changes, CollectionFieldChange{Field: "description", Old: collection.Description, New: *u.Description})
+		collection.Description = *u.Description
+	}
+	urls := collection.urls()
+	for _, field := range []struct {
+		name  string
+		value *string
+	}{
+		{"icon_url", u.IconURL},
+		{"image_url", u.ImageURL},
+		{"metadata_api_url", u.MetadataAPIURL},
+	} {
+		if field.value != nil && *field.value != *urls[field.name] {
+			changes = append(changes, CollectionFieldChange{Field: field.name, Old: *urls[field.name], New: *field.value})
+			*urls[field.name] = *field.value
+		}
+	}
+	if u.MetadataSchema != nil {
+		old, _ := json.Marshal(collection.MetadataSchema)
+		updated, _ := json.Marshal(u.MetadataSchema)
+		if string(old) != string(updated) {
+			changes = append(changes, CollectionFieldChange{Field: "metadata_schema", Old: string(old), New: string(updated)})
+			collection.MetadataSchema = u.MetadataSchema
+		}
 	}
-	return nil
+	return changes
 }
 
-func UserExists(userid string) bool {
-	if _, err := os.Stat(Prefix + UserDir + userid); err != nil {
-		return false
+// legacyWithdrawal is the record of a withdraw ID stored by older versions,
+// they only supported ETH and didn't keep the amount.
+func legacyWithdrawal(data []byte) (*Withdrawal, error) {
+	id, err := strconv.ParseInt(string(data), 10, 32)
+	if err != nil {
+		return nil, err
 	}
-	return true
+	now := time.Now()
+	return &Withdrawal{ID: int32(id), Token: "ETH", Created: now, Updated: now}, nil
 }
 
-func CollectionExists(userid string, collectionid string) bool {
-	if _, err := os.Stat(Prefix + UserDir + userid + "/collections/" + collectionid); err != nil {
-		return false
+// Backend is implemented by every storage engine the market can run on.
+// Handlers never talk to a backend directly, they go through the package
+// level functions below which forward to the currently selected backend.
+type Backend interface {
+	Exists() bool
+	Create() error
+
+	ListUsers() ([]string, error)
+	UserExists(userid string) bool
+	CreateUser(userid string) error
+	RemoveUser(userid string)
+	GetUserSealedPrivateKey(userid string) ([]byte, error)
+	SetUserSealedPrivateKey(userid string, key []byte) error
+	GetUserPublicKey(userid string) ([]byte, error)
+	SetUserPublicKey(userid string, key []byte) error
+	GetUserAddress(userid string) ([]byte, error)
+	SetUserAddress(userid string, address []byte) error
+	// GetUserLinkedAddress returns the external wallet the user can sign in
+	// with besides the custodial address
+	GetUserLinkedAddress(userid string) ([]byte, error)
+	SetUserLinkedAddress(userid string, address []byte) error
+	// UserExternal tells whether the user has been created on sign in with
+	// an external wallet, the market holds no keys of such users
+	UserExternal(userid string) bool
+	SetUserExternal(userid string) error
+	GetUserSealedStarkPrivateKey(userid string) ([]byte, error)
+	SetUserSealedStarkPrivateKey(userid string, key []byte) error
+	GetUserStarkAddress(userid string) ([]byte, error)
+	SetUserStarkAddress(userid string, address []byte) error
+	// GetUserProjectID returns the ImmutableX project the user's collections
+	// are created in, or 0 if the user has none yet
+	GetUserProjectID(userid string) (int32, error)
+	SetUserProjectID(userid string, projectID int32) error
+	// GetUserWalletIndex returns the index the user's keys are derived from
+	// the wallet seed with, or ErrNoWalletIndex if they aren't derived
+	GetUserWalletIndex(userid string) (uint32, error)
+	SetUserWalletIndex(userid string, index uint32) error
+	// NextWalletIndex allocates wallet indexes from 0 up, each only once
+	NextWalletIndex() (uint32, error)
+	// GetUserRegistration returns nil if the user's registration has never
+	// been attempted
+	GetUserRegistration(userid string) (*Registration, error)
+	SetUserRegistration(userid string, registration *Registration) error
+
+	CollectionExists(userid string, collectionid string) bool
+	// CreateCollection stores a collection under its ID at version 1, new
+	// collections get their ID from the package level CreateCollection
+	CreateCollection(userid string, collection *Collection) error
+	GetUserCollectionContractAddress(userid string, collectionid string) ([]byte, error)
+	// ListUserCollections returns the collections created by the user, not
+	// the ones the user only holds tokens of
+	ListUserCollections(userid string) ([]Collection, error)
+	GetUserCollection(userid string, collectionid string) (*Collection, error)
+	// UpdateCollection applies the update if the collection is still at the
+	// given version, bumping the version and appending the change made by
+	// editor to the collection's history. It fails with ErrVersionConflict if
+	// the collection has been changed in the meantime.
+	UpdateCollection(userid string, collectionid string, version int, update CollectionUpdate, editor string) (*Collection, error)
+	GetCollectionHistory(userid string, collectionid string) ([]CollectionChange, error)
+	// ListCollectionTokens returns every token of the collection, whoever holds it
+	ListCollectionTokens(collectionid string) ([]string, error)
+
+	ListUserWithdrawals(userid string) ([]Withdrawal, error)
+	GetUserWithdrawal(userid string, withdrawID int32) (*Withdrawal, error)
+	SetUserWithdrawal(userid string, withdrawal *Withdrawal) error
+	// MigrateUserWithdraw turns the single withdraw ID kept by older versions
+	// into a withdrawal record, it returns nil if there was none.
+	MigrateUserWithdraw(userid string) (*Withdrawal, error)
+
+	NextTokenIndex() (*uint256.Int, error)
+
+	// Migrated tells whether the named one-time migration has been completed,
+	// SetMigrated records that it has
+	Migrated(name string) bool
+	SetMigrated(name string) error
+
+	TokenExists(tokenid string) bool
+	CreateToken(userid string, collectionid string, tokenid string) error
+	RemoveToken(userid string, collectionid string, tokenid string)
+	MoveToken(tokenid string, to string) error
+	GetTokenOwner(tokenid string) ([]byte, error)
+	SetTokenOwner(tokenid string, userid string) error
+	GetTokenCollection(tokenid string) ([]byte, error)
+	SetTokenCollection(tokenid string, collectionid string) error
+	GetTokenMetadata(tokenid string) ([]byte, error)
+	SetTokenMetadata(tokenid string, metadata []byte) error
+	TokenMinted(userid string, tokenid string) bool
+	GetTokenMintedID(tokenid string) ([]byte, error)
+	SetTokenMintedID(userid string, tokenid string, imxtokenid string) error
+	TokenSelling(userid string, tokenid string) bool
+	GetTokenSellingID(tokenid string) ([]byte, error)
+	SetTokenSellingID(tokenid string, sellingID string) error
+	GetTokenSellingPrice(tokenid string) ([]byte, error)
+	SetTokenSellingPrice(tokenid string, price string) error
+	// GetTokenSellingCurrency returns the currency key of the selling price,
+	// tokens put on sale by older versions have none and are sold for ETH
+	GetTokenSellingCurrency(tokenid string) ([]byte, error)
+	SetTokenSellingCurrency(tokenid string, currency string) error
+	// RemoveTokenSelling removes the selling ID, price and currency
+	RemoveTokenSelling(tokenid string) error
+	GetTokenSellingList(userid string) ([]string, error)
+
+	// jobs are stored as opaque records, see package jobs
+	ListJobs() ([]string, error)
+	GetJob(jobid string) ([]byte, error)
+	SetJob(jobid string, job []byte) error
+
+	// API keys are stored as opaque records too, see package auth
+	ListAPIKeys() ([]string, error)
+	GetAPIKey(keyid string) ([]byte, error)
+	SetAPIKey(keyid string, key []byte) error
+}
+
+var backend Backend
+
+// Open selects the backend configured in cfg. The returned function releases
+// it and has to be called on shutdown.
+func Open(cfg config.Storage) (func(), error) {
+	switch cfg.Backend {
+	case "fs":
+		SetBackend(NewFSBackend(cfg.Path))
+		return func() {}, nil
+	case "bolt":
+		db, err := NewBoltBackend(cfg.Database)
+		if err != nil {
+			return nil, err
+		}
+		SetBackend(db)
+		return func() { db.Close() }, nil
 	}
-	return true
+	return nil, errors.New("unknown storage backend '" + cfg.Backend + "'")
 }
 
-func GetUserPrivateKey(userid string) ([]byte, error) {
-	return os.ReadFile(Prefix + UserDir + userid + "/private_key")
+// SetBackend replaces the storage engine used by the package level functions.
+// It is expected to be called once on startup, before serving any requests.
+func SetBackend(b Backend) {
+	backend = b
 }
 
-func GetUserPublicKey(userid string) ([]byte, error) {
-	return os.ReadFile(Prefix + UserDir + userid + "/public_key")
+func StorageExists() bool {
+	return backend.Exists()
 }
 
-func GetUserAddress(userid string) ([]byte, error) {
-	return os.ReadFile(Prefix + UserDir + userid + "/address")
+func StorageCreate() error {
+	return backend.Create()
 }
 
-func GetUserStarkPrivateKey(userid string) ([]byte, error) {
-	return os.ReadFile(Prefix + UserDir + userid + "/stark_private_key")
+func ListUsers() ([]string, error) {
+	return backend.ListUsers()
 }
 
-func GetUserCollectionContractAddress(userid string, collectionid string) ([]byte, error) {
-	return os.ReadFile(Prefix + UserDir + userid + "/collections/" + collectionid + "/contract_address")
+func UserExists(userid string) bool {
+	return backend.UserExists(userid)
 }
 
-func UserWithdrawInProgress(userid string) bool {
-	if _, err := os.Stat(Prefix + UserDir + userid + "/withdraw"); err != nil {
-		return false
-	}
-	return true
+func CreateUser(userid string) error {
+	return backend.CreateUser(userid)
 }
 
-func UserWithdrawFinalize(userid string) bool {
-	if err := os.Remove(Prefix + UserDir + userid + "/withdraw"); err != nil {
-		return false
-	}
-	return true
+func RemoveUser(userid string) {
+	backend.RemoveUser(userid)
 }
 
-func GetUserWithdrawID(userid string) (int32, error) {
-	bytes, err := os.ReadFile(Prefix + UserDir + userid + "/withdraw")
-	if err != nil {
-		return -1, err
-	}
+// User keys are stored sealed by the keystore package, storage only ever
+// sees the encrypted envelopes.
+func GetUserSealedPrivateKey(userid string) ([]byte, error) {
+	return backend.GetUserSealedPrivateKey(userid)
+}
 
-	id, err := strconv.ParseInt(string(bytes), 10, 32)
-	if err != nil {
-		return -1, err
-	}
+func SetUserSealedPrivateKey(userid string, key []byte) error {
+	return backend.SetUserSealedPrivateKey(userid, key)
+}
 
-	return int32(id), nil
+func GetUserPublicKey(userid string) ([]byte, error) {
+	return backend.GetUserPublicKey(userid)
 }
 
-func SetUserWithdrawID(userid string, withdrawID int32) error {
-	return os.WriteFile(Prefix+UserDir+userid+"/withdraw", []byte(strconv.FormatInt(int64(withdrawID), 10)), 0644)
+func SetUserPublicKey(userid string, key []byte) error {
+	return backend.SetUserPublicKey(userid, key)
 }
 
-func GetTokenIndex(tokenID *uint256.Int) error {
-	if _, err := os.Stat(Prefix + "tokens/index"); err == nil {
-		bytes, err := os.ReadFile(Prefix + "tokens/index")
-		if err != nil {
-			return errors.New("failed to read token index")
+func GetUserAddress(userid string) ([]byte, error) {
+	return backend.GetUserAddress(userid)
+}
+
+// FindUserByAddress returns the user whose L1 address is address, compared
+// case insensitively.
+func FindUserByAddress(address string) (string, error) {
+	users, err := backend.ListUsers()
+	if err != nil {
+		return "", err
+	}
+	for _, userid := range users {
+		userAddress, err := backend.GetUserAddress(userid)
+		if err == nil && strings.EqualFold(string(userAddress), address) {
+			return userid, nil
 		}
-		tokenID.SetBytes(bytes)
 	}
+	return "", errors.New("user with address " + address + " doesn't exist")
+}
 
-	return nil
+func SetUserAddress(userid string, address []byte) error {
+	return backend.SetUserAddress(userid, address)
 }
 
-func SetTokenIndex(tokenID *uint256.Int) error {
-	if _, err := os.Stat(Prefix + TokenDir); err != nil {
-		err = os.MkdirAll(Prefix+TokenDir, os.ModePerm)
-		if err != nil {
-			return errors.New("failed to create token storage")
+func GetUserLinkedAddress(userid string) ([]byte, error) {
+	return backend.GetUserLinkedAddress(userid)
+}
+
+// FindUserByLinkedAddress returns the user who has linked the external
+// wallet address, compared case insensitively.
+func FindUserByLinkedAddress(address string) (string, error) {
+	users, err := backend.ListUsers()
+	if err != nil {
+		return "", err
+	}
+	for _, userid := range users {
+		linked, err := backend.GetUserLinkedAddress(userid)
+		if err == nil && strings.EqualFold(string(linked), address) {
+			return userid, nil
 		}
 	}
-	return os.WriteFile(Prefix+"tokens/index", tokenID.Bytes(), 0644)
+	return "", errors.New("no user has linked address " + address)
 }
 
-func TokenSelling(userid string, tokenid string) bool {
-	if _, err := os.Stat(Prefix + TokenDir + tokenid + "/selling"); err != nil {
-		return false
-	}
-	return true
+func SetUserLinkedAddress(userid string, address []byte) error {
+	return backend.SetUserLinkedAddress(userid, address)
 }
 
-func GetTokenSellingID(tokenid string) ([]byte, error) {
-	return os.ReadFile(Prefix + TokenDir + tokenid + "/selling")
+func UserExternal(userid string) bool {
+	return backend.UserExternal(userid)
+}
+
+func SetUserExternal(userid string) error {
+	return backend.SetUserExternal(userid)
+}
+
+func GetUserSealedStarkPrivateKey(userid string) ([]byte, error) {
+	return backend.GetUserSealedStarkPrivateKey(userid)
 }
 
-func GetTokenSellingList(userid string, list []string) error {
-	entries, err := os.ReadDir(Prefix + TokenDir)
+func SetUserSealedStarkPrivateKey(userid string, key []byte) error {
+	return backend.SetUserSealedStarkPrivateKey(userid, key)
+}
+
+func GetUserStarkAddress(userid string) ([]byte, error) {
+	return backend.GetUserStarkAddress(userid)
+}
+
+func SetUserStarkAddress(userid string, address []byte) error {
+	return backend.SetUserStarkAddress(userid, address)
+}
+
+func GetUserProjectID(userid string) (int32, error) {
+	return backend.GetUserProjectID(userid)
+}
+
+func SetUserProjectID(userid string, projectID int32) error {
+	return backend.SetUserProjectID(userid, projectID)
+}
+
+func GetUserWalletIndex(userid string) (uint32, error) {
+	return backend.GetUserWalletIndex(userid)
+}
+
+func SetUserWalletIndex(userid string, index uint32) error {
+	return backend.SetUserWalletIndex(userid, index)
+}
+
+func NextWalletIndex() (uint32, error) {
+	return backend.NextWalletIndex()
+}
+
+func GetUserRegistration(userid string) (*Registration, error) {
+	return backend.GetUserRegistration(userid)
+}
+
+func SetUserRegistration(userid string, registration *Registration) error {
+	return backend.SetUserRegistration(userid, registration)
+}
+
+func CollectionExists(userid string, collectionid string) bool {
+	return backend.CollectionExists(userid, collectionid)
+}
+
+// CreateCollection stores a new collection and returns its ID. The ID is
+// random so that it doesn't change when the name or description is edited.
+func CreateCollection(userid string, collection *Collection) (string, error) {
+	collection.ID = uuid.NewString()
+	if err := backend.CreateCollection(userid, collection); err != nil {
+		return "", err
+	}
+	return collection.ID, nil
+}
+
+// MigrateUserCollections moves the collections older versions wrote to
+// <legacyRoot><userid>/collections/<id> into storage, keeping their IDs. It
+// returns the number of collections moved.
+func MigrateUserCollections(legacyRoot string, userid string) (int, error) {
+	legacyPath := legacyRoot + userid + "/collections/"
+	entries, err := os.ReadDir(legacyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
 	if err != nil {
-		return errors.New("failed to read token storage")
+		return 0, err
 	}
 
-	for _, token := range entries {
-		tokenid := token.Name()
-		if !token.IsDir() {
+	moved := 0
+	for _, entry := range entries {
+		collectionid := entry.Name()
+		collectionPath := legacyPath + collectionid
+		contractAddress, err := os.ReadFile(collectionPath + "/contract_address")
+		if err != nil {
 			continue
 		}
-		if TokenSelling(userid, tokenid) {
-			list = append(list, tokenid)
+		name, _ := os.ReadFile(collectionPath + "/name")
+		description, _ := os.ReadFile(collectionPath + "/description")
+
+		if !backend.CollectionExists(userid, collectionid) {
+			err = backend.CreateCollection(userid, &Collection{
+				ID:              collectionid,
+				ContractAddress: string(contractAddress),
+				Name:            string(name),
+				Description:     string(description),
+			})
+			if err != nil {
+				return moved, err
+			}
+		}
+		if err = os.RemoveAll(collectionPath); err != nil {
+			return moved, err
 		}
+		moved++
 	}
 
-	return nil
+	// only removed once empty, the directories might hold something else
+	_ = os.Remove(legacyPath)
+	_ = os.Remove(legacyRoot + userid)
+	return moved, nil
+}
+
+func GetUserCollectionContractAddress(userid string, collectionid string) ([]byte, error) {
+	return backend.GetUserCollectionContractAddress(userid, collectionid)
+}
+
+func ListUserCollections(userid string) ([]Collection, error) {
+	return backend.ListUserCollections(userid)
+}
+
+func GetUserCollection(userid string, collectionid string) (*Collection, error) {
+	return backend.GetUserCollection(userid, collectionid)
+}
+
+func UpdateCollection(userid string, collectionid string, version int, update CollectionUpdate, editor string) (*Collection, error) {
+	return backend.UpdateCollection(userid, collectionid, version, update, editor)
+}
+
+func GetCollectionHistory(userid string, collectionid string) ([]CollectionChange, error) {
+	return backend.GetCollectionHistory(userid, collectionid)
+}
+
+// FindCollection returns the collection with the given ID whoever created
+// it, tokens can be held by other users than the collection's creator.
+func FindCollection(collectionid string) (*Collection, error) {
+	users, err := backend.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+	for _, userid := range users {
+		if collection, err := backend.GetUserCollection(userid, collectionid); err == nil {
+			return collection, nil
+		}
+	}
+	return nil, errors.New("collection " + collectionid + " doesn't exist")
+}
+
+func ListCollectionTokens(collectionid string) ([]string, error) {
+	return backend.ListCollectionTokens(collectionid)
+}
+
+func ListUserWithdrawals(userid string) ([]Withdrawal, error) {
+	return backend.ListUserWithdrawals(userid)
+}
+
+func GetUserWithdrawal(userid string, withdrawID int32) (*Withdrawal, error) {
+	return backend.GetUserWithdrawal(userid, withdrawID)
+}
+
+func SetUserWithdrawal(userid string, withdrawal *Withdrawal) error {
+	return backend.SetUserWithdrawal(userid, withdrawal)
+}
+
+func MigrateUserWithdraw(userid string) (*Withdrawal, error) {
+	return backend.MigrateUserWithdraw(userid)
+}
+
+// NextTokenIndex atomically allocates a new token index. Indexes are unique
+// and increasing across concurrent callers and process restarts.
+func NextTokenIndex() (*uint256.Int, error) {
+	return backend.NextTokenIndex()
+}
+
+func Migrated(name string) bool {
+	return backend.Migrated(name)
+}
+
+func SetMigrated(name string) error {
+	return backend.SetMigrated(name)
+}
+
+func TokenExists(tokenid string) bool {
+	return backend.TokenExists(tokenid)
+}
+
+func CreateToken(userid string, collectionid string, tokenid string) error {
+	return backend.CreateToken(userid, collectionid, tokenid)
+}
+
+func RemoveToken(userid string, collectionid string, tokenid string) {
+	backend.RemoveToken(userid, collectionid, tokenid)
+}
+
+func MoveToken(tokenid string, to string) error {
+	return backend.MoveToken(tokenid, to)
 }
 
 func GetTokenOwner(tokenid string) ([]byte, error) {
-	return os.ReadFile(Prefix + TokenDir + tokenid + "/user_id")
+	return backend.GetTokenOwner(tokenid)
 }
 
 func SetTokenOwner(tokenid string, userid string) error {
-	return os.WriteFile(Prefix+TokenDir+tokenid+"/user_id", []byte(userid), 0644)
+	return backend.SetTokenOwner(tokenid, userid)
 }
 
 func GetTokenCollection(tokenid string) ([]byte, error) {
-	return os.ReadFile(Prefix + TokenDir + tokenid + "/collection_id")
+	return backend.GetTokenCollection(tokenid)
 }
 
 func SetTokenCollection(tokenid string, collectionid string) error {
-	return os.WriteFile(Prefix+TokenDir+tokenid+"/collection_id", []byte(collectionid), 0644)
+	return backend.SetTokenCollection(tokenid, collectionid)
+}
+
+func GetTokenMetadata(tokenid string) ([]byte, error) {
+	return backend.GetTokenMetadata(tokenid)
+}
+
+func SetTokenMetadata(tokenid string, metadata []byte) error {
+	return backend.SetTokenMetadata(tokenid, metadata)
 }
 
 func TokenMinted(userid string, tokenid string) bool {
-	if _, err := os.Stat(Prefix + TokenDir + tokenid + "/minted"); err != nil {
-		return false
-	}
-	return true
+	return backend.TokenMinted(userid, tokenid)
 }
 
 func GetTokenMintedID(tokenid string) ([]byte, error) {
-	return os.ReadFile(Prefix + TokenDir + tokenid + "/minted")
+	return backend.GetTokenMintedID(tokenid)
 }
 
 func SetTokenMintedID(userid string, tokenid string, imxtokenid string) error {
-	return os.WriteFile(Prefix+TokenDir+tokenid+"/minted", []byte(imxtokenid), 0644)
+	return backend.SetTokenMintedID(userid, tokenid, imxtokenid)
 }
 
-func CreateToken(userid string, collectionid string, tokenid string) error {
-	err := os.MkdirAll(Prefix+TokenDir+tokenid, os.ModePerm)
-	if err != nil {
-		return errors.New("failed to reserve token")
-	}
+func TokenSelling(userid string, tokenid string) bool {
+	return backend.TokenSelling(userid, tokenid)
+}
 
-	// TODO: convert this to symlink
-	err = os.MkdirAll(Prefix+UserDir+userid+"/collections/"+collectionid+"/"+tokenid, os.ModePerm)
-	if err != nil {
-		RemoveToken(userid, collectionid, tokenid)
-		return errors.New("failed to reserve token in collection")
-	}
+func GetTokenSellingID(tokenid string) ([]byte, error) {
+	return backend.GetTokenSellingID(tokenid)
+}
 
-	return nil
+func SetTokenSellingID(tokenid string, sellingID string) error {
+	return backend.SetTokenSellingID(tokenid, sellingID)
 }
 
-func RemoveToken(userid string, collectionid string, tokenid string) {
-	_ = os.RemoveAll(Prefix + TokenDir + tokenid)
-	_ = os.RemoveAll(Prefix + UserDir + userid + "/collections/" + collectionid + "/" + tokenid)
+func GetTokenSellingPrice(tokenid string) ([]byte, error) {
+	return backend.GetTokenSellingPrice(tokenid)
 }
 
-func MoveToken(tokenid string, to string) error {
-	from, err := GetTokenOwner(tokenid)
-	if err != nil {
-		return err
-	}
-	collection, err := GetTokenCollection(tokenid)
-	if err != nil {
-		return err
-	}
+func SetTokenSellingPrice(tokenid string, price string) error {
+	return backend.SetTokenSellingPrice(tokenid, price)
+}
 
-	err = SetTokenOwner(tokenid, to)
-	if err != nil {
-		return err
-	}
+func GetTokenSellingCurrency(tokenid string) ([]byte, error) {
+	return backend.GetTokenSellingCurrency(tokenid)
+}
 
-	tokenPath := "/collections/" + string(collection)
-	_, err = os.Stat(Prefix + UserDir + string(from) + tokenPath + tokenid)
-	if err != nil {
-		_ = SetTokenOwner(tokenid, string(from))
-		return err
-	}
-	err = os.MkdirAll(Prefix+UserDir+to+tokenPath, os.ModePerm)
-	if err != nil {
-		_ = SetTokenOwner(tokenid, string(from))
-		return err
-	}
+func SetTokenSellingCurrency(tokenid string, currency string) error {
+	return backend.SetTokenSellingCurrency(tokenid, currency)
+}
 
-	err = os.Rename(Prefix+UserDir+string(from)+tokenPath+tokenid, Prefix+UserDir+to+tokenPath+tokenid)
-	if err != nil {
-		_ = SetTokenOwner(tokenid, string(from))
-		return err
-	}
+func RemoveTokenSelling(tokenid string) error {
+	return backend.RemoveTokenSelling(tokenid)
+}
+
+func GetTokenSellingList(userid string) ([]string, error) {
+	return backend.GetTokenSellingList(userid)
+}
+
+func ListJobs() ([]string, error) {
+	return backend.ListJobs()
+}
+
+func GetJob(jobid string) ([]byte, error) {
+	return backend.GetJob(jobid)
+}
+
+func SetJob(jobid string, job []byte) error {
+	return backend.SetJob(jobid, job)
+}
+
+func ListAPIKeys() ([]string, error) {
+	return backend.ListAPIKeys()
+}
+
+func GetAPIKey(keyid string) ([]byte, error) {
+	return backend.GetAPIKey(keyid)
+}
 
-	return nil
+func SetAPIKey(keyid string, key []byte) error {
+	return backend.SetAPIKey(keyid, key)
 }
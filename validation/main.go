@@ -0,0 +1,172 @@
+// Package validation checks the format of request fields. The verify
+// functions of the handlers collect problems with a Validator and return
+// them as Errors, which the handlers pass on to the client field by field.
+package validation
+
+import (
+	"errors"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/google/uuid"
+	"math/big"
+	"net/mail"
+	"nft-market/currency"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FieldError is a problem with a single field, Error completes a sentence
+// starting with the field name, e.g. "is missing".
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// Errors lists every invalid field of a request.
+type Errors []FieldError
+
+func (e Errors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, field := range e {
+		messages = append(messages, field.Field+" "+field.Error)
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Fields returns the field errors of err, or nil if it isn't Errors.
+func Fields(err error) []FieldError {
+	var errs Errors
+	if errors.As(err, &errs) {
+		return errs
+	}
+	return nil
+}
+
+// Validator collects the field errors of a request.
+type Validator struct {
+	errs Errors
+}
+
+// Check records err, if any, as the error of field.
+func (v *Validator) Check(field string, err error) {
+	if err != nil {
+		v.errs = append(v.errs, FieldError{Field: field, Error: err.Error()})
+	}
+}
+
+// Fail records msg as the error of field.
+func (v *Validator) Fail(field string, msg string) {
+	v.errs = append(v.errs, FieldError{Field: field, Error: msg})
+}
+
+// Required records an error if value is empty and returns whether it isn't,
+// so that format checks can be skipped for missing fields.
+func (v *Validator) Required(field string, value string) bool {
+	if value == "" {
+		v.Fail(field, "is missing")
+		return false
+	}
+	return true
+}
+
+// Optional runs check on value unless it is empty.
+func (v *Validator) Optional(field string, value string, check func(string) error) {
+	if value != "" {
+		v.Check(field, check(value))
+	}
+}
+
+// Err returns the collected errors as Errors, or nil if there are none.
+func (v *Validator) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs
+}
+
+var (
+	hexAddress   = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	hexStarkKey  = regexp.MustCompile(`^0x[0-9a-fA-F]{1,64}$`)
+	decimal      = regexp.MustCompile(`^(0|[1-9][0-9]*)$`)
+	tokenID      = regexp.MustCompile(`^(0|[1-9a-f][0-9a-f]{0,63})$`)
+	legacyHashID = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// starkPrime is the order of the field Stark keys are elements of,
+// 2^251 + 17 * 2^192 + 1
+var starkPrime, _ = new(big.Int).SetString("800000000000011000000000000000000000000000000000000000000000001", 16)
+
+// Address checks an Ethereum address. Mixed case addresses have to carry a
+// valid EIP-55 checksum, all lower or upper case ones carry none.
+func Address(value string) error {
+	if !hexAddress.MatchString(value) {
+		return errors.New("is not an Ethereum address")
+	}
+	digits := value[2:]
+	if digits != strings.ToLower(digits) && digits != strings.ToUpper(digits) &&
+		common.HexToAddress(value).Hex() != value {
+		return errors.New("has an invalid EIP-55 checksum")
+	}
+	return nil
+}
+
+// StarkKey checks a hex encoded Stark key, which has to be an element of
+// the Stark field.
+func StarkKey(value string) error {
+	if !hexStarkKey.MatchString(value) {
+		return errors.New("is not a Stark key")
+	}
+	key, _ := new(big.Int).SetString(value[2:], 16)
+	if key.Cmp(starkPrime) >= 0 {
+		return errors.New("is not a Stark key")
+	}
+	return nil
+}
+
+// Amount checks an amount of the currency cur as accepted by its Parse
+// method, in base units or followed by a unit.
+func Amount(cur *currency.Currency, value string) error {
+	_, err := cur.Parse(value)
+	return err
+}
+
+// TokenID checks a token ID as allocated by the market: a 256 bit number in
+// lower case hex, without 0x prefix and leading zeros.
+func TokenID(value string) error {
+	if !tokenID.MatchString(value) {
+		return errors.New("is not a token ID")
+	}
+	return nil
+}
+
+// CollectionID checks a collection ID, a UUID or the hash used as ID by
+// older versions.
+func CollectionID(value string) error {
+	if legacyHashID.MatchString(value) {
+		return nil
+	}
+	if _, err := uuid.Parse(value); err != nil || len(value) != 36 {
+		return errors.New("is not a collection ID")
+	}
+	return nil
+}
+
+// ID checks the decimal ID of an ImmutableX object like an order, which is
+// a positive 32 bit integer.
+func ID(value string) error {
+	id, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || id < 1 || !decimal.MatchString(value) {
+		return errors.New("is not an ImmutableX ID")
+	}
+	return nil
+}
+
+// Email checks a plain RFC 5322 address, without display name or angle
+// brackets.
+func Email(value string) error {
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value || len(value) > 254 {
+		return errors.New("is not an email address")
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package wallet
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"math/big"
+)
+
+// BIP-32 private key derivation, only as much of it as needed to derive
+// private keys from a seed: there are no extended public keys.
+
+type extendedKey struct {
+	key       []byte
+	chainCode []byte
+}
+
+// indexes from hardened on derive hardened keys
+const hardened = 0x80000000
+
+var errInvalidKey = errors.New("derived an invalid key, use another index")
+
+func masterKey(seed []byte) (*extendedKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	key := new(big.Int).SetBytes(sum[:32])
+	if key.Sign() == 0 || key.Cmp(crypto.S256().Params().N) >= 0 {
+		return nil, errInvalidKey
+	}
+	return &extendedKey{key: sum[:32], chainCode: sum[32:]}, nil
+}
+
+func (k *extendedKey) child(index uint32) (*extendedKey, error) {
+	var data []byte
+	if index >= hardened {
+		data = append([]byte{0}, k.key...)
+	} else {
+		private, err := crypto.ToECDSA(k.key)
+		if err != nil {
+			return nil, err
+		}
+		data = crypto.CompressPubkey(&private.PublicKey)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := crypto.S256().Params().N
+	tweak := new(big.Int).SetBytes(sum[:32])
+	if tweak.Cmp(n) >= 0 {
+		return nil, errInvalidKey
+	}
+	key := tweak.Add(tweak, new(big.Int).SetBytes(k.key))
+	key.Mod(key, n)
+	if key.Sign() == 0 {
+		return nil, errInvalidKey
+	}
+	return &extendedKey{key: math.PaddedBigBytes(key, 32), chainCode: sum[32:]}, nil
+}
+
+// derive returns the 32 byte private key at path from the master key of seed.
+func derive(seed []byte, path accounts.DerivationPath) ([]byte, error) {
+	k, err := masterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range path {
+		if k, err = k.child(index); err != nil {
+			return nil, err
+		}
+	}
+	return k.key, nil
+}
@@ -0,0 +1,122 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"testing"
+)
+
+// bip32Step is a derivation step of a BIP-32 test vector, key and chain
+// code are those of the xprv the vector lists for the path so far
+type bip32Step struct {
+	index     uint32
+	key       string
+	chainCode string
+}
+
+// bip32Vectors are the test vectors 1 to 3 of BIP-32, the first step of
+// each is the master key
+var bip32Vectors = []struct {
+	name  string
+	seed  string
+	steps []bip32Step
+}{
+	{"vector 1", "000102030405060708090a0b0c0d0e0f", []bip32Step{
+		{0, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35", "873dff81c02f525623fd1fe5167eac3a55a049de3d314bb42ee227ffed37d508"},
+		{hardened + 0, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea", "47fdacbd0f1097043b78c63c20c34ef4ed9a111d980047ad16282c7ae6236141"},
+		{1, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368", "2a7857631386ba23dacac34180dd1983734e444fdbf774041578e9b6adb37c19"},
+		{hardened + 2, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca", "04466b9cc8e161e966409ca52986c584f07e9dc81f735db683c3ff6ec7b1503f"},
+		{2, "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4", "cfb71883f01676f587d023cc53a35bc7f88f724b1f8c2892ac1275ac822a3edd"},
+		{1000000000, "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8", "c783e67b921d2beb8f6b389cc646d7263b4145701dadd2161548a8b078e65e9e"},
+	}},
+	{"vector 2", "fffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542", []bip32Step{
+		{0, "4b03d6fc340455b363f51020ad3ecca4f0850280cf436c70c727923f6db46c3e", "60499f801b896d83179a4374aeb7822aaeaceaa0db1f85ee3e904c4defbd9689"},
+		{0, "abe74a98f6c7eabee0428f53798f0ab8aa1bd37873999041703c742f15ac7e1e", "f0909affaa7ee7abe5dd4e100598d4dc53cd709d5a5c2cac40e7412f232f7c9c"},
+		{hardened + 2147483647, "877c779ad9687164e9c2f4f0f4ff0340814392330693ce95a58fe18fd52e6e93", "be17a268474a6bb9c61e1d720cf6215e2a88c5406c4aee7b38547f585c9a37d9"},
+		{1, "704addf544a06e5ee4bea37098463c23613da32020d604506da8c0518e1da4b7", "f366f48f1ea9f2d1d3fe958c95ca84ea18e4c4ddb9366c336c927eb246fb38cb"},
+		{hardened + 2147483646, "f1c7c871a54a804afe328b4c83a1c33b8e5ff48f5087273f04efa83b247d6a2d", "637807030d55d01f9a0cb3a7839515d796bd07706386a6eddf06cc29a65a0e29"},
+		{2, "bb7d39bdb83ecf58f2fd82b6d918341cbef428661ef01ab97c28a4842125ac23", "9452b549be8cea3ecb7a84bec10dcfd94afe4d129ebfd3b3cb58eedf394ed271"},
+	}},
+	// vector 3 is about keys with leading zeros, which have to be kept
+	{"vector 3", "4b381541583be4423346c643850da4b320e46a87ae3d2a4e6da11eba819cd4acba45d239319ac14f863b8d5ab5a0d0c64d2e8a1e7d1457df2e5a3c51c73235be", []bip32Step{
+		{0, "00ddb80b067e0d4993197fe10f2657a844a384589847602d56f0c629c81aae32", "01d28a3e53cffa419ec122c968b3259e16b65076495494d97cae10bbfec3c36f"},
+		{hardened + 0, "491f7a2eebc7b57028e0d3faa0acda02e75c33b03c48fb288c41e2ea44e1daef", "e5fea12a97b927fc9dc3d2cb0d1ea1cf50aa5a1fdc1f933e8906bb38df3377bd"},
+	}},
+}
+
+func TestBIP32Vectors(t *testing.T) {
+	for _, vector := range bip32Vectors {
+		t.Run(vector.name, func(t *testing.T) {
+			seed, _ := hex.DecodeString(vector.seed)
+			k, err := masterKey(seed)
+			if err != nil {
+				t.Fatalf("failed to derive master key: %v", err)
+			}
+
+			var path accounts.DerivationPath
+			for i, step := range vector.steps {
+				if i > 0 {
+					path = append(path, step.index)
+					if k, err = k.child(step.index); err != nil {
+						t.Fatalf("failed to derive %v: %v", path, err)
+					}
+				}
+				if got := hex.EncodeToString(k.key); got != step.key {
+					t.Errorf("%v: got key %v, want %v", path, got, step.key)
+				}
+				if got := hex.EncodeToString(k.chainCode); got != step.chainCode {
+					t.Errorf("%v: got chain code %v, want %v", path, got, step.chainCode)
+				}
+
+				key, err := derive(seed, path)
+				if err != nil {
+					t.Fatalf("failed to derive %v: %v", path, err)
+				}
+				if got := hex.EncodeToString(key); got != step.key {
+					t.Errorf("derive %v: got key %v, want %v", path, got, step.key)
+				}
+			}
+		})
+	}
+}
+
+func TestUserKey(t *testing.T) {
+	tests := []struct {
+		mnemonic string
+		index    uint32
+		address  string
+	}{
+		// the accounts of the test mnemonic of Hardhat and Anvil
+		{"test test test test test test test test test test test junk", 0, "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"},
+		{"test test test test test test test test test test test junk", 1, "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"},
+		{"test test test test test test test test test test test junk", 2, "0x3C44CdDdB6a900fa2b585dd299e03d12FA4293BC"},
+		{"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", 0, "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.address, func(t *testing.T) {
+			if err := Init(test.mnemonic); err != nil {
+				t.Fatalf("failed to set seed: %v", err)
+			}
+			defer Init("")
+
+			key, err := UserKey(test.index)
+			if err != nil {
+				t.Fatalf("failed to derive key: %v", err)
+			}
+			if got := crypto.PubkeyToAddress(key.PublicKey).Hex(); got != test.address {
+				t.Errorf("got %v, want %v", got, test.address)
+			}
+
+			// importing the mnemonic at the user's path gives the same key
+			imported, err := FromMnemonic(test.mnemonic, "", UserPath(test.index))
+			if err != nil {
+				t.Fatalf("failed to import mnemonic: %v", err)
+			}
+			if !imported.Equal(key) {
+				t.Error("imported key differs from the derived one")
+			}
+		})
+	}
+}
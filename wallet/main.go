@@ -0,0 +1,106 @@
+// Package wallet imports and derives user keys: Ethereum keys given as hex
+// or as a BIP-39 mnemonic, and Stark keys given as hex or derived from an
+// Ethereum signature the way ImmutableX wallets do.
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+	"math/big"
+	"strings"
+)
+
+// DefaultPath is the BIP-44 path of the first Ethereum account.
+const DefaultPath = "m/44'/60'/0'/0/0"
+
+// starkOrder is the order of the Stark curve, Stark private keys are below it
+var starkOrder, _ = new(big.Int).SetString("0800000000000010ffffffffffffffffb781126dcae7b2321e66a241adc64d2f", 16)
+
+func trimHex(value string) string {
+	if strings.HasPrefix(value, "0x") || strings.HasPrefix(value, "0X") {
+		return value[2:]
+	}
+	return value
+}
+
+// ParsePrivateKey reads a hex encoded Ethereum private key, with or without
+// 0x prefix.
+func ParsePrivateKey(value string) (*ecdsa.PrivateKey, error) {
+	key, err := crypto.HexToECDSA(trimHex(value))
+	if err != nil {
+		return nil, errors.New("is not an Ethereum private key")
+	}
+	return key, nil
+}
+
+// ParsePath reads a BIP-32 derivation path like DefaultPath.
+func ParsePath(value string) (accounts.DerivationPath, error) {
+	path, err := accounts.ParseDerivationPath(value)
+	if err != nil || !strings.HasPrefix(value, "m/") {
+		return nil, errors.New("is not a derivation path")
+	}
+	return path, nil
+}
+
+// CheckMnemonic checks the words and checksum of a BIP-39 mnemonic.
+func CheckMnemonic(mnemonic string) error {
+	if !bip39.IsMnemonicValid(mnemonic) {
+		return errors.New("is not a BIP-39 mnemonic")
+	}
+	return nil
+}
+
+// FromMnemonic derives the Ethereum key at path from a BIP-39 mnemonic and
+// its optional passphrase.
+func FromMnemonic(mnemonic string, passphrase string, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, errors.New("is not a BIP-39 mnemonic")
+	}
+	key, err := derive(seed, path)
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToECDSA(key)
+}
+
+// MatchPublicKey checks that public, a hex encoded public key, uncompressed
+// with or without its 04 prefix or compressed, belongs to key.
+func MatchPublicKey(key *ecdsa.PrivateKey, public string) error {
+	bytes, err := hexutil.Decode("0x" + trimHex(public))
+	if err != nil {
+		return errors.New("is not a public key")
+	}
+	var publicKey *ecdsa.PublicKey
+	switch len(bytes) {
+	case 33:
+		publicKey, err = crypto.DecompressPubkey(bytes)
+	case 64:
+		publicKey, err = crypto.UnmarshalPubkey(append([]byte{4}, bytes...))
+	case 65:
+		publicKey, err = crypto.UnmarshalPubkey(bytes)
+	default:
+		err = errors.New("invalid length")
+	}
+	if err != nil {
+		return errors.New("is not a public key")
+	}
+	if !publicKey.Equal(&key.PublicKey) {
+		return errors.New("doesn't match the private key")
+	}
+	return nil
+}
+
+// ParseStarkPrivateKey reads a hex encoded Stark private key, with or
+// without 0x prefix.
+func ParseStarkPrivateKey(value string) (*big.Int, error) {
+	key, ok := new(big.Int).SetString(trimHex(value), 16)
+	if !ok || key.Sign() <= 0 || key.Cmp(starkOrder) >= 0 || len(trimHex(value)) > 64 {
+		return nil, errors.New("is not a Stark private key")
+	}
+	return key, nil
+}
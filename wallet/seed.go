@@ -0,0 +1,57 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// SeedEnv holds the BIP-39 mnemonic of the master seed the keys of
+// custodial users are derived from. Backing it up once backs up every key
+// derived from it.
+const SeedEnv = "NFT_MARKET_WALLET_MNEMONIC"
+
+var seed []byte
+
+// Init sets the master seed from its mnemonic. Without one keys aren't
+// derived, new users get random keys as before.
+func Init(mnemonic string) error {
+	if mnemonic == "" {
+		seed = nil
+		return nil
+	}
+	s, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return errors.New("wallet seed is not a BIP-39 mnemonic")
+	}
+	seed = s
+	return nil
+}
+
+// Derived tells whether Init has been given a master seed.
+func Derived() bool {
+	return seed != nil
+}
+
+// UserPath is the BIP-44 path of the user key with the index,
+// m/44'/60'/0'/0/index.
+func UserPath(index uint32) accounts.DerivationPath {
+	return accounts.DerivationPath{hardened + 44, hardened + 60, hardened + 0, 0, index}
+}
+
+// UserKey derives the user key with the index from the master seed.
+func UserKey(index uint32) (*ecdsa.PrivateKey, error) {
+	if seed == nil {
+		return nil, errors.New("wallet seed isn't set")
+	}
+	if index >= hardened {
+		return nil, errors.New("invalid wallet index")
+	}
+	key, err := derive(seed, UserPath(index))
+	if err != nil {
+		return nil, err
+	}
+	return crypto.ToECDSA(key)
+}
@@ -0,0 +1,95 @@
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/immutable/imx-core-sdk-golang/imx/signers/stark"
+	"math/big"
+)
+
+// StarkMessage is what ImmutableX wallets sign to derive the Stark key.
+const StarkMessage = "Only sign this request if you’ve initiated an action with Immutable X."
+
+// accountPath is the EIP-2645 path of the account of address in the layer
+// and application: m/2645'/layer'/application'/address bits 0-30'/31-61'/index,
+// with the low 31 bits of the SHA-256 of layer and application.
+func accountPath(layer string, application string, address common.Address, index uint32) accounts.DerivationPath {
+	bits := func(value *big.Int, shift uint) uint32 {
+		return uint32(new(big.Int).Rsh(value, shift).Uint64() & 0x7fffffff)
+	}
+	layerHash := sha256.Sum256([]byte(layer))
+	applicationHash := sha256.Sum256([]byte(application))
+	addressInt := new(big.Int).SetBytes(address.Bytes())
+	return accounts.DerivationPath{
+		hardened + 2645,
+		hardened + bits(new(big.Int).SetBytes(layerHash[:]), 0),
+		hardened + bits(new(big.Int).SetBytes(applicationHash[:]), 0),
+		hardened + bits(addressInt, 0),
+		hardened + bits(addressInt, 31),
+		index,
+	}
+}
+
+// starkPath is the path ImmutableX derives the Stark key of address along,
+// the account 1 of the layer "starkex" and the application "immutablex".
+func starkPath(address common.Address) accounts.DerivationPath {
+	return accountPath("starkex", "immutablex", address, 1)
+}
+
+// grind maps a derived key to a Stark private key without modulo bias by
+// hashing it with an increasing index until the hash is below the largest
+// multiple of the Stark curve order that fits in 256 bits.
+func grind(key []byte) *big.Int {
+	limit := new(big.Int).Lsh(big.NewInt(1), 256)
+	limit.Sub(limit, new(big.Int).Mod(limit, starkOrder))
+	for index := 0; ; index++ {
+		hash := sha256.Sum256(append(append([]byte{}, key...), byte(index)))
+		value := new(big.Int).SetBytes(hash[:])
+		if value.Cmp(limit) < 0 {
+			return value.Mod(value, starkOrder)
+		}
+	}
+}
+
+// StarkKeyFromSignature derives the Stark private key of address from its
+// signature of StarkMessage. The S value of the signature is the seed of
+// the derivation.
+func StarkKeyFromSignature(address string, signature []byte) (*big.Int, error) {
+	if len(signature) != crypto.SignatureLength {
+		return nil, errors.New("invalid signature")
+	}
+	key, err := derive(signature[32:64], starkPath(common.HexToAddress(address)))
+	if err != nil {
+		return nil, err
+	}
+	return grind(key), nil
+}
+
+// DeriveStarkKey derives the Stark private key of the Ethereum key's
+// account, the same one ImmutableX wallets derive for it.
+func DeriveStarkKey(key *ecdsa.PrivateKey) (*big.Int, error) {
+	signature, err := crypto.Sign(accounts.TextHash([]byte(StarkMessage)), key)
+	if err != nil {
+		return nil, err
+	}
+	return StarkKeyFromSignature(crypto.PubkeyToAddress(key.PublicKey).Hex(), signature)
+}
+
+// MatchStarkKey checks that public, a hex encoded Stark key as checked by
+// validation.StarkKey, belongs to key.
+func MatchStarkKey(key *big.Int, public string) error {
+	signer, err := stark.NewSigner(key)
+	if err != nil {
+		return errors.New("is not a Stark key")
+	}
+	own, _ := new(big.Int).SetString(trimHex(signer.GetAddress()), 16)
+	given, ok := new(big.Int).SetString(trimHex(public), 16)
+	if !ok || own == nil || own.Cmp(given) != 0 {
+		return errors.New("doesn't match the Stark private key")
+	}
+	return nil
+}
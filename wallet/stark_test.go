@@ -0,0 +1,112 @@
+package wallet
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/tyler-smith/go-bip39"
+	"strings"
+	"testing"
+)
+
+// the key derivation vectors of starkware-crypto-utils, a Stark key derived
+// along an EIP-2645 path from a BIP-39 seed
+func TestAccountPath(t *testing.T) {
+	const mnemonic = "range mountain blast problem vibrant void vivid doctor cluster enough melody salt layer language laptop boat major space monkey unit glimpse pause change vibrant"
+	address := common.HexToAddress("0xa4864d977b944315389d1765ffa7e66F74ee8cd7")
+	seed := bip39.NewSeed(mnemonic, "")
+
+	tests := []struct {
+		index uint32
+		path  string
+		key   string
+	}{
+		{0, "m/2645'/579218131'/891216374'/1961790679'/2135936222'/0", "6cf0a8bf113352eb863157a45c5e5567abb34f8d32cddafd2c22aa803f4892c"},
+		{7, "m/2645'/579218131'/891216374'/1961790679'/2135936222'/7", "341751bdc42841da35ab74d13a1372c1f0250617e8a2ef96034d9f46e6847af"},
+		{598, "m/2645'/579218131'/891216374'/1961790679'/2135936222'/598", "41a4d591a868353d28b7947eb132aa4d00c4a022743689ffd20a3628d6ca28c"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			path := accountPath("starkex", "starkdeployement", address, test.index)
+			if path.String() != test.path {
+				t.Fatalf("got path %v, want %v", path, test.path)
+			}
+			key, err := derive(seed, path)
+			if err != nil {
+				t.Fatalf("failed to derive key: %v", err)
+			}
+			if got := grind(key).Text(16); got != test.key {
+				t.Errorf("got Stark key %v, want %v", got, test.key)
+			}
+		})
+	}
+}
+
+func TestStarkKeyFromSignature(t *testing.T) {
+	// the first Hardhat account's signature of StarkMessage, the Stark key
+	// matches an independent port of the ImmutableX derivation
+	const (
+		address   = "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"
+		signature = "0x3d48b7997ffb45a7d4a5c13c2444d59e74756958bda2c26eedb0cf204ba0c6691a77323239154c797f0ef4ed6dc4ada79edf2b4ed71f30af7281addfbae45a0000"
+		starkKey  = "242bcc810538a112273bcf60d7bce0df08f9058746058b1a571c408159f07e1"
+		public    = "0x078c3293f5ee6607bc48b68c03b86ef492b75afcb444a702cfd6fde74e658ce5"
+	)
+	// only S seeds the derivation, R and V don't matter
+	otherR := "0x" + strings.Repeat("11", 32) + signature[66:130] + "1b"
+
+	tests := []struct {
+		name      string
+		address   string
+		signature string
+		want      string
+		err       string
+	}{
+		{"vector", address, signature, starkKey, ""},
+		{"lower case address", strings.ToLower(address), signature, starkKey, ""},
+		{"other R and V", address, otherR, starkKey, ""},
+		{"other address", "0x70997970C51812dc3A010C7d01b50e0d17dc79C8", signature, "", ""},
+		{"short signature", address, signature[:130], "", "invalid signature"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := StarkKeyFromSignature(test.address, hexutil.MustDecode(test.signature))
+			if test.err != "" {
+				if err == nil || err.Error() != test.err {
+					t.Fatalf("got error %v, want %v", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("got error %v", err)
+			}
+			if test.want == "" {
+				if got.Text(16) == starkKey {
+					t.Error("got the Stark key of another address")
+				}
+				return
+			}
+			if got.Text(16) != test.want {
+				t.Errorf("got %v, want %v", got.Text(16), test.want)
+			}
+			if err = MatchStarkKey(got, public); err != nil {
+				t.Errorf("public key doesn't match: %v", err)
+			}
+		})
+	}
+}
+
+func TestDeriveStarkKey(t *testing.T) {
+	key, err := ParsePrivateKey("ac0974bec39a17e36ba4a6b4d238ff944bacb478cbed5efcae784d7bf4f2ff80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// signing is deterministic, so is the derived key
+	got, err := DeriveStarkKey(key)
+	if err != nil {
+		t.Fatalf("got error %v", err)
+	}
+	if want := "242bcc810538a112273bcf60d7bce0df08f9058746058b1a571c408159f07e1"; got.Text(16) != want {
+		t.Errorf("got %v, want %v", got.Text(16), want)
+	}
+}
@@ -0,0 +1,105 @@
+// Package wei parses ETH amounts into wei, and decimal amounts of other
+// assets into their base units. Amounts are carried as *big.Int
+// everywhere, limited to the 256 bits Ethereum and ImmutableX accept.
+package wei
+
+import (
+	"errors"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+// Units maps the accepted units, case insensitive, to their number of
+// decimals in wei.
+var Units = map[string]int{
+	"wei":    0,
+	"kwei":   3,
+	"mwei":   6,
+	"gwei":   9,
+	"szabo":  12,
+	"finney": 15,
+	"eth":    18,
+	"ether":  18,
+}
+
+var (
+	amount = regexp.MustCompile(`^([0-9]+)(\.([0-9]+))?\s*([a-zA-Z]*)$`)
+	max    = new(big.Int).Lsh(big.NewInt(1), 256)
+)
+
+var (
+	ErrInvalid   = errors.New("is not an amount")
+	ErrUnit      = errors.New("has an unknown unit")
+	ErrNoUnit    = errors.New("needs a unit to have a fraction")
+	ErrPrecision = errors.New("is more precise than the smallest unit")
+	ErrOverflow  = errors.New("exceeds 256 bits")
+)
+
+// Parse converts an amount like "1500000000000000000" (wei), "1.5 ETH" or
+// "20gwei" into wei. Fractions are only allowed together with a unit and
+// have to be a whole number of wei.
+func Parse(value string) (*big.Int, error) {
+	match := amount.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil {
+		return nil, ErrInvalid
+	}
+	whole, fraction, unit := match[1], match[3], strings.ToLower(match[4])
+
+	decimals := 0
+	if unit != "" {
+		var ok bool
+		if decimals, ok = Units[unit]; !ok {
+			return nil, ErrUnit
+		}
+	} else if fraction != "" {
+		// "1.5" would be ambiguous, wei is the default but ETH is meant
+		return nil, ErrNoUnit
+	}
+
+	return scale(whole, fraction, decimals)
+}
+
+// Scale converts a plain decimal number like "1.5" into base units of an
+// asset with the given number of decimals, e.g. 1500000 for 6 decimals.
+func Scale(value string, decimals int) (*big.Int, error) {
+	match := amount.FindStringSubmatch(strings.TrimSpace(value))
+	if match == nil || match[4] != "" {
+		return nil, ErrInvalid
+	}
+	return scale(match[1], match[3], decimals)
+}
+
+func scale(whole string, fraction string, decimals int) (*big.Int, error) {
+	fraction = strings.TrimRight(fraction, "0")
+	if len(fraction) > decimals {
+		return nil, ErrPrecision
+	}
+	digits := whole + fraction + strings.Repeat("0", decimals-len(fraction))
+
+	result, ok := new(big.Int).SetString(digits, 10)
+	if !ok {
+		return nil, ErrInvalid
+	}
+	if result.Cmp(max) >= 0 {
+		return nil, ErrOverflow
+	}
+	return result, nil
+}
+
+// Format writes base units of an asset with the given number of decimals as
+// a decimal number without trailing zeros, e.g. "1.5".
+func Format(value *big.Int, decimals int) string {
+	digits := new(big.Int).Abs(value).String()
+	if len(digits) <= decimals {
+		digits = strings.Repeat("0", decimals-len(digits)+1) + digits
+	}
+	whole, fraction := digits[:len(digits)-decimals], strings.TrimRight(digits[len(digits)-decimals:], "0")
+	if value.Sign() < 0 {
+		whole = "-" + whole
+	}
+	if fraction == "" {
+		return whole
+	}
+	return whole + "." + fraction
+}
@@ -0,0 +1,124 @@
+package wei
+
+import (
+	"math/big"
+	"testing"
+)
+
+const (
+	// maxUint256 is the largest amount, 2^256-1
+	maxUint256 = "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+	// overUint256 is 2^256, the smallest amount that overflows
+	overUint256 = "115792089237316195423570985008687907853269984665640564039457584007913129639936"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+		err   error
+	}{
+		{"0", "0", nil},
+		{"1500000000000000000", "1500000000000000000", nil},
+		{"1.5 ETH", "1500000000000000000", nil},
+		{"1.5eth", "1500000000000000000", nil},
+		{"20gwei", "20000000000", nil},
+		{" 2 Ether ", "2000000000000000000", nil},
+		{"0.000000000000000001 ether", "1", nil},
+		{"1.50000 finney", "1500000000000000", nil},
+		{"3 wei", "3", nil},
+		{maxUint256, maxUint256, nil},
+		{maxUint256 + " wei", maxUint256, nil},
+		{"115792089237316195423570985008687907853269984665640564039457.584007913129639935 eth", maxUint256, nil},
+		{overUint256, "", ErrOverflow},
+		{"115792089237316195423570985008687907853269984665640564039457.584007913129639936 eth", "", ErrOverflow},
+		{"115792089237316195423570985008687907853269984665640564039458 eth", "", ErrOverflow},
+		{"1" + maxUint256, "", ErrOverflow},
+		{"-1", "", ErrInvalid},
+		{"-1.5 eth", "", ErrInvalid},
+		{"+1", "", ErrInvalid},
+		{"", "", ErrInvalid},
+		{"eth", "", ErrInvalid},
+		{"1.", "", ErrInvalid},
+		{".5 eth", "", ErrInvalid},
+		{"1e18", "", ErrInvalid},
+		{"0x10", "", ErrInvalid},
+		{"1 btc", "", ErrUnit},
+		{"1.5", "", ErrNoUnit},
+		{"1.5 wei", "", ErrPrecision},
+		{"0.0000000000000000001 eth", "", ErrPrecision},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := Parse(test.value)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && got.String() != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScale(t *testing.T) {
+	tests := []struct {
+		value    string
+		decimals int
+		want     string
+		err      error
+	}{
+		{"12.5", 6, "12500000", nil},
+		{"12", 6, "12000000", nil},
+		{"0.000001", 6, "1", nil},
+		{"7", 0, "7", nil},
+		{maxUint256, 0, maxUint256, nil},
+		{overUint256, 0, "", ErrOverflow},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639.936", 3, "", ErrOverflow},
+		{"0.0000001", 6, "", ErrPrecision},
+		{"1.5", 0, "", ErrPrecision},
+		{"-12.5", 6, "", ErrInvalid},
+		{"12.5 USDC", 6, "", ErrInvalid},
+		{"", 6, "", ErrInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			got, err := Scale(test.value, test.decimals)
+			if err != test.err {
+				t.Fatalf("got error %v, want %v", err, test.err)
+			}
+			if err == nil && got.String() != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		value    string
+		decimals int
+		want     string
+	}{
+		{"0", 18, "0"},
+		{"1500000000000000000", 18, "1.5"},
+		{"1", 18, "0.000000000000000001"},
+		{"12500000", 6, "12.5"},
+		{"12000000", 6, "12"},
+		{"7", 0, "7"},
+		{"-1500000", 6, "-1.5"},
+		{"-1", 6, "-0.000001"},
+		{maxUint256, 18, "115792089237316195423570985008687907853269984665640564039457.584007913129639935"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			value, _ := new(big.Int).SetString(test.value, 10)
+			if got := Format(value, test.decimals); got != test.want {
+				t.Errorf("got %v, want %v", got, test.want)
+			}
+		})
+	}
+}